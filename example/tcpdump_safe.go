@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 
 	"github.com/VividCortex/golibpcap/trace"
 )
@@ -58,6 +59,51 @@ func main() {
 			v.Bytes, v.PLBytes)
 	}
 
+	// Display who talks to whom, the busiest host pairs first, with both
+	// directions of each pair together and broken down by service.
+	convMap := trace.GetIPConversations(*t.Data)
+	convs := make([]*trace.IPConversation, 0, len(convMap))
+	for _, v := range convMap {
+		convs = append(convs, v)
+	}
+	sort.Slice(convs, func(i, j int) bool {
+		if convs[i].Bytes() != convs[j].Bytes() {
+			return convs[i].Bytes() > convs[j].Bytes()
+		}
+		return convs[i].IPTuple.String() < convs[j].IPTuple.String()
+	})
+	for _, c := range convs {
+		fmt.Printf("%s <-> %s -- %d-%d %d/%d pkts %d/%d bytes\n",
+			c.IPTuple.Src, c.IPTuple.Dst,
+			c.StartTime.Unix(), c.EndTime.Unix(),
+			c.SrcPktCnt, c.DstPktCnt, c.SrcBytes, c.DstBytes)
+		protos := make([]int, 0, len(c.Protos))
+		for proto := range c.Protos {
+			protos = append(protos, int(proto))
+		}
+		sort.Ints(protos)
+		for _, proto := range protos {
+			n := c.Protos[uint8(proto)]
+			fmt.Printf("\tproto %d -- %d/%d pkts %d/%d bytes\n", proto,
+				n.SrcPktCnt, n.DstPktCnt, n.SrcBytes, n.DstBytes)
+		}
+		ports := make([]trace.ConversationPort, 0, len(c.Ports))
+		for port := range c.Ports {
+			ports = append(ports, port)
+		}
+		sort.Slice(ports, func(i, j int) bool {
+			if ports[i].Proto != ports[j].Proto {
+				return ports[i].Proto < ports[j].Proto
+			}
+			return ports[i].Port < ports[j].Port
+		})
+		for _, port := range ports {
+			n := c.Ports[port]
+			fmt.Printf("\t%s -- %d/%d pkts %d/%d bytes\n", port,
+				n.SrcPktCnt, n.DstPktCnt, n.SrcBytes, n.DstBytes)
+		}
+	}
+
 	// Display aggregate stats for the TCP headers from the trace.PktTrace.
 	tcpMap := trace.GetTCPTraffic(*t.Data)
 	for k, v := range tcpMap {
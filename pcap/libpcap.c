@@ -18,3 +18,11 @@ pt2cb getCallbackLoop() {
 pt2cb getCallbackLoopAllocless() {
   return (pt2cb)goCallbackLoopAllocless;
 }
+
+pt2cb getCallbackLoopTransport() {
+  return (pt2cb)goCallbackLoopTransport;
+}
+
+pt2cb getCallbackLoopLayers() {
+  return (pt2cb)goCallbackLoopLayers;
+}
@@ -10,6 +10,8 @@
 extern void goCallbackChan(u_char *, struct pcap_pkthdr *, u_char *);
 extern void goCallbackLoop(u_char *, struct pcap_pkthdr *, u_char *);
 extern void goCallbackLoopAllocless(u_char *, struct pcap_pkthdr *, u_char *);
+extern void goCallbackLoopTransport(u_char *, struct pcap_pkthdr *, u_char *);
+extern void goCallbackLoopLayers(u_char *, struct pcap_pkthdr *, u_char *);
 
 typedef void(*pt2cb)(u_char *, const struct pcap_pkthdr *, const u_char *);
 
@@ -17,3 +19,5 @@ typedef void(*pt2cb)(u_char *, const struct pcap_pkthdr *, const u_char *);
 pt2cb getCallbackChan();
 pt2cb getCallbackLoop();
 pt2cb getCallbackLoopAllocless();
+pt2cb getCallbackLoopTransport();
+pt2cb getCallbackLoopLayers();
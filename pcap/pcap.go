@@ -23,6 +23,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -48,8 +49,9 @@ var (
 func goCallbackChan(user *C.u_char, pkthdr_ptr *C.struct_pcap_pkthdr, buf_ptr *C.u_char) {
 	p := (*Pcap)(unsafe.Pointer(user))
 	p.m.Lock()
-	packet := pkt.NewPacket(unsafe.Pointer(pkthdr_ptr), unsafe.Pointer(buf_ptr))
+	packet := p.newPacket(pkthdr_ptr, buf_ptr)
 	p.m.Unlock()
+	p.countDecodeErr(packet.Err())
 	p.Pchan <- packet
 	p.pktCnt++
 }
@@ -61,10 +63,13 @@ func goCallbackLoop(user *C.u_char, pkthdr_ptr *C.struct_pcap_pkthdr, buf_ptr *C
 	if p.datalinkType < 0 {
 		p.datalinkType = p.Datalink()
 	}
-	if packet := pkt.NewPacket2(unsafe.Pointer(pkthdr_ptr), unsafe.Pointer(buf_ptr), p.datalinkType); packet != nil {
-		if p.loopCallback(packet) {
-			p.BreakLoop()
-		}
+	packet, err := pkt.NewPacket2(unsafe.Pointer(pkthdr_ptr), unsafe.Pointer(buf_ptr), p.datalinkType)
+	if err != nil {
+		p.countDecodeErr(err)
+		return
+	}
+	if p.loopCallback(packet) {
+		p.BreakLoop()
 	}
 }
 
@@ -75,10 +80,59 @@ func goCallbackLoopAllocless(user *C.u_char, pkthdr_ptr *C.struct_pcap_pkthdr, b
 	if p.datalinkType < 0 {
 		p.datalinkType = p.Datalink()
 	}
-	if pkt.NewPacketAllocless(unsafe.Pointer(pkthdr_ptr), unsafe.Pointer(buf_ptr), p.datalinkType, &p.Packet) {
-		if p.loopCallback(&p.Packet) {
-			p.BreakLoop()
-		}
+	if err := pkt.NewPacketAllocless(unsafe.Pointer(pkthdr_ptr), unsafe.Pointer(buf_ptr), p.datalinkType, &p.Packet); err != nil {
+		p.countDecodeErr(err)
+		return
+	}
+	if p.loopCallback(&p.Packet) {
+		p.BreakLoop()
+	}
+}
+
+//export goCallbackLoopTransport
+func goCallbackLoopTransport(user *C.u_char, pkthdr_ptr *C.struct_pcap_pkthdr, buf_ptr *C.u_char) {
+	p := (*Pcap)(unsafe.Pointer(user))
+	p.pktCnt++
+	if p.datalinkType < 0 {
+		p.datalinkType = p.Datalink()
+	}
+	var tcp *pkt.TcpPacket
+	var udp *pkt.UdpPacket
+	if p.loopCallback != nil {
+		tcp = &p.Packet
+	}
+	if p.udpCallback != nil {
+		udp = &p.UdpPacket
+	}
+	proto, err := pkt.NewTransportAllocless(unsafe.Pointer(pkthdr_ptr), unsafe.Pointer(buf_ptr), p.datalinkType, tcp, udp)
+	if err != nil {
+		p.countDecodeErr(err)
+		return
+	}
+	var quit bool
+	if proto == pkt.IpProtoTCP {
+		quit = p.loopCallback(tcp)
+	} else {
+		quit = p.udpCallback(udp)
+	}
+	if quit {
+		p.BreakLoop()
+	}
+}
+
+//export goCallbackLoopLayers
+func goCallbackLoopLayers(user *C.u_char, pkthdr_ptr *C.struct_pcap_pkthdr, buf_ptr *C.u_char) {
+	p := (*Pcap)(unsafe.Pointer(user))
+	p.pktCnt++
+	if p.datalinkType < 0 {
+		p.datalinkType = p.Datalink()
+	}
+	if err := pkt.NewPacketLayers(unsafe.Pointer(pkthdr_ptr), unsafe.Pointer(buf_ptr), p.datalinkType, &p.Layers); err != nil {
+		p.countDecodeErr(err)
+		return
+	}
+	if p.layersCallback(&p.Layers) {
+		p.BreakLoop()
 	}
 }
 
@@ -95,19 +149,26 @@ func Statustostr(errnum int32) string {
 
 // Pcap is the wrapper for the pcap_t struct in <pcap.h>.
 type Pcap struct {
-	FileName     string                    // Used for pcap_open_offline
-	Device       string                    // Used for pcap_open_live
-	Snaplen      int32                     // Specifies the maximum number of bytes to capture
-	Promisc      int32                     // 0->false, 1->true
-	Timeout      int32                     // ms
-	Filters      []string                  // track filters applied to the capture
-	Pchan        chan *pkt.Packet          // Channel for passing Packet pointers
-	loopCallback func(*pkt.TcpPacket) bool // Callback for LoopWithCallback(), ret true to quit
-	datalinkType int32                     // type of packets libpcap will send us
-	cptr         *C.pcap_t                 // C Pointer to pcap_t
-	Packet       pkt.TcpPacket             // used by alloc-less version of loop
-	pktCnt       uint32                    // the number of packets captured
-	m            *sync.Mutex               // Mutex to protect the packet memory for decode
+	FileName       string                       // Used for pcap_open_offline
+	Device         string                       // Used for pcap_open_live
+	Snaplen        int32                        // Specifies the maximum number of bytes to capture
+	Promisc        int32                        // 0->false, 1->true
+	Timeout        int32                        // ms
+	Filters        []string                     // track filters applied to the capture
+	KeepBytes      int                          // captured bytes each Packet keeps, see pkt.Packet.Keep; -1 for all
+	Pchan          chan *pkt.Packet             // Channel for passing Packet pointers
+	loopCallback   func(*pkt.TcpPacket) bool    // Callback for LoopWithCallback(), ret true to quit
+	udpCallback    func(*pkt.UdpPacket) bool    // UDP callback for LoopWithCallbacksAllocless(), ret true to quit
+	layersCallback func(*pkt.LayerDecoder) bool // Callback for LoopWithCallbackLayers(), ret true to quit
+	datalinkType   int32                        // type of packets libpcap will send us
+	cptr           *C.pcap_t                    // C Pointer to pcap_t
+	Packet         pkt.TcpPacket                // used by alloc-less version of loop
+	UdpPacket      pkt.UdpPacket                // used by alloc-less version of loop for UDP
+	Layers         pkt.LayerDecoder             // used by the layered version of loop
+	pktCnt         uint32                       // the number of packets captured
+	m              *sync.Mutex                  // Mutex to protect the packet memory for decode
+
+	decodeErrs [pkt.NumDecodeReasons]atomic.Uint64 // packets not (fully) decoded, by reason
 }
 
 // OpenOffline returns a *Pcap and opens it to read pcap packets from a save file.
@@ -304,6 +365,27 @@ func (p *Pcap) LoopWithCallbackAllocless(cnt int, callback func(*pkt.TcpPacket)
 	C.pcap_loop(p.cptr, C.int(cnt), C.getCallbackLoopAllocless(), (*C.u_char)(unsafe.Pointer(p)))
 }
 
+// Callback+allocless version of Loop() for TCP and UDP.  TCP packets go to
+// tcpCB and UDP packets to udpCB; either one may be nil to skip that protocol.
+// A CB signals to quit returning true.  CBs must not keep a ref to packet.
+// They can use Clone() to get their own copy.
+func (p *Pcap) LoopWithCallbacksAllocless(cnt int, tcpCB func(*pkt.TcpPacket) bool, udpCB func(*pkt.UdpPacket) bool) {
+	if tcpCB == nil && udpCB == nil {
+		return
+	}
+	p.loopCallback = tcpCB
+	p.udpCallback = udpCB
+	C.pcap_loop(p.cptr, C.int(cnt), C.getCallbackLoopTransport(), (*C.u_char)(unsafe.Pointer(p)))
+}
+
+// Callback+allocless version of Loop() for packets of any protocol.  CB gets
+// the LayerDecoder each packet was decoded into, and signals to quit returning
+// true.  CB must not keep a ref to the decoder, its headers or its Payload.
+func (p *Pcap) LoopWithCallbackLayers(cnt int, callback func(*pkt.LayerDecoder) bool) {
+	p.layersCallback = callback
+	C.pcap_loop(p.cptr, C.int(cnt), C.getCallbackLoopLayers(), (*C.u_char)(unsafe.Pointer(p)))
+}
+
 // Listen will accumulate packets until it is stopped by a nil packet pointer.
 func (p *Pcap) Listen(r chan *[]*pkt.Packet) {
 	var b []*pkt.Packet
@@ -353,8 +435,9 @@ func (p *Pcap) NextEx() (*pkt.Packet, int32) {
 	res := int32(C.pcap_next_ex(p.cptr, &pkthdr_ptr, &buf_ptr))
 	if res == 1 {
 		p.m.Lock()
-		packet := pkt.NewPacket(unsafe.Pointer(pkthdr_ptr), unsafe.Pointer(buf_ptr))
+		packet := p.newPacket(pkthdr_ptr, buf_ptr)
 		p.m.Unlock()
+		p.countDecodeErr(packet.Err())
 		p.pktCnt++
 		return packet, res
 	}
@@ -373,14 +456,29 @@ func (p *Pcap) NextEx2() (pkt.TcpPacket, int32) {
 		if p.datalinkType < 0 {
 			p.datalinkType = p.Datalink()
 		}
-		if packet := pkt.NewPacket2(unsafe.Pointer(pkthdr_ptr), unsafe.Pointer(buf_ptr), p.datalinkType); packet != nil {
+		packet, err := pkt.NewPacket2(unsafe.Pointer(pkthdr_ptr), unsafe.Pointer(buf_ptr), p.datalinkType)
+		if err == nil {
 			return *packet, res
 		}
+		p.countDecodeErr(err)
 		res = 0
 	}
 	return pkt.TcpPacket{}, res
 }
 
+// newPacket decodes a packet from libpcap and keeps KeepBytes of its captured
+// bytes.
+func (p *Pcap) newPacket(pkthdr_ptr *C.struct_pcap_pkthdr, buf_ptr *C.u_char) *pkt.Packet {
+	if p.datalinkType < 0 {
+		p.datalinkType = p.Datalink()
+	}
+	packet := pkt.NewPacketDatalink(unsafe.Pointer(pkthdr_ptr), unsafe.Pointer(buf_ptr), p.datalinkType)
+	if p.KeepBytes != 0 {
+		packet.Keep(p.KeepBytes)
+	}
+	return packet
+}
+
 // Getstats returns a filled in Stat struct.
 func (p *Pcap) Getstats() (*stat.Stat, error) {
 	var cs C.struct_pcap_stat
@@ -398,6 +496,28 @@ func (p *Pcap) Getstats() (*stat.Stat, error) {
 	return s, nil
 }
 
+// countDecodeErr keeps track of why packets could not be decoded.
+func (p *Pcap) countDecodeErr(err error) {
+	if e, ok := err.(*pkt.DecodeError); ok && e.Reason < pkt.NumDecodeReasons {
+		p.decodeErrs[e.Reason].Add(1)
+	}
+}
+
+// DecodeErrors returns the number of packets that could not be decoded, or
+// were only partly decoded, for each pkt.DecodeReason seen so far.  Packets
+// skipped by LoopWithCallback, LoopWithCallbackAllocless,
+// LoopWithCallbacksAllocless, LoopWithCallbackLayers and NextEx2 are counted
+// here, so this tells why traffic is not making it to the callback.
+func (p *Pcap) DecodeErrors() map[pkt.DecodeReason]uint64 {
+	m := make(map[pkt.DecodeReason]uint64)
+	for r := range p.decodeErrs {
+		if n := p.decodeErrs[r].Load(); n > 0 {
+			m[pkt.DecodeReason(r)] = n
+		}
+	}
+	return m
+}
+
 // Setfilter compiles a filter string into a bpf program and sets the filter.
 func (p *Pcap) Setfilter(expr string) error {
 	cexpr := C.CString(expr)
@@ -0,0 +1,118 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// ARP operation codes: compare these with the ArpHdr.Op field.
+const (
+	ArpRequest = uint16(1) // request to resolve ArpHdr.TargetProtoAddr
+	ArpReply   = uint16(2) // response to an ArpRequest
+)
+
+// The ArpHdr struct is the arphdr struct from <net/if_arp.h> along with the
+// addresses that follow it.  For Ethernet and IPv4, the usual case, the
+// hardware addresses are MACs and the protocol addresses are IPs.
+type ArpHdr struct {
+	HwType          uint16           // format of hardware address (ar_hrd)
+	ProtoType       uint16           // format of protocol address (ar_pro)
+	HwLen           uint8            // length of hardware address (ar_hln)
+	ProtoLen        uint8            // length of protocol address (ar_pln)
+	Op              uint16           // ARP opcode (ar_op)
+	SenderHwAddr    net.HardwareAddr // sender hardware address (ar_sha)
+	SenderProtoAddr net.IP           // sender protocol address (ar_spa)
+	TargetHwAddr    net.HardwareAddr // target hardware address (ar_tha)
+	TargetProtoAddr net.IP           // target protocol address (ar_tpa)
+}
+
+// NewArpHdr returns the ARP packet at the start of b along with the bytes that
+// follow it (usually just Ethernet padding).  The addresses are copied out of
+// b.
+func NewArpHdr(b []byte) (*ArpHdr, []byte, error) {
+	if len(b) < arpHdrLen {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "arphdr", Value: arpHdrLen, Caplen: len(b)}
+	}
+	h := &ArpHdr{
+		HwType:    binary.BigEndian.Uint16(b),
+		ProtoType: binary.BigEndian.Uint16(b[2:]),
+		HwLen:     b[4],
+		ProtoLen:  b[5],
+		Op:        binary.BigEndian.Uint16(b[6:]),
+	}
+	hl, pl := int(h.HwLen), int(h.ProtoLen)
+	n := arpHdrLen + 2*(hl+pl)
+	if len(b) < n {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "arphdr", Value: n, Caplen: len(b)}
+	}
+	a := append([]byte(nil), b[arpHdrLen:n]...)
+	h.SenderHwAddr = net.HardwareAddr(a[:hl:hl])
+	h.SenderProtoAddr = net.IP(a[hl : hl+pl : hl+pl])
+	h.TargetHwAddr = net.HardwareAddr(a[hl+pl : 2*hl+pl : 2*hl+pl])
+	h.TargetProtoAddr = net.IP(a[2*hl+pl:])
+	return h, b[n:], nil
+}
+
+// IsGratuitous reports whether the ARP packet is a host announcing its own
+// address binding rather than asking about, or answering for, another host.
+func (h *ArpHdr) IsGratuitous() bool {
+	return h.SenderProtoAddr.Equal(h.TargetProtoAddr) && !h.IsProbe()
+}
+
+// IsProbe reports whether the ARP packet is an RFC 5227 probe, sent to check
+// that nobody uses TargetProtoAddr before claiming it.  A probe has no sender
+// protocol address, so it does not bind anything.
+func (h *ArpHdr) IsProbe() bool {
+	return h.Op == ArpRequest && h.SenderProtoAddr.IsUnspecified()
+}
+
+// JsonElement returns a JSON encoding of the ArpHdr struct.
+func (h *ArpHdr) JsonElement() string {
+	return fmt.Sprintf("\"arphdr\":{\"ar_hrd\":%d,\"ar_pro\":%d,\"ar_op\":%d,\"ar_sha\":\"%s\",\"ar_spa\":\"%s\",\"ar_tha\":\"%s\",\"ar_tpa\":\"%s\"}",
+		h.HwType,
+		h.ProtoType,
+		h.Op,
+		h.SenderHwAddr.String(),
+		h.SenderProtoAddr.String(),
+		h.TargetHwAddr.String(),
+		h.TargetProtoAddr.String())
+}
+
+// CsvElement returns a CSV encoding of the ArpHdr struct.
+// The string "ARP" signifies the beginning of the ArpHdr.
+func (h *ArpHdr) CsvElement() string {
+	return fmt.Sprintf("\"ARP\",%d,%d,%d,\"%s\",\"%s\",\"%s\",\"%s\"",
+		h.HwType,
+		h.ProtoType,
+		h.Op,
+		h.SenderHwAddr.String(),
+		h.SenderProtoAddr.String(),
+		h.TargetHwAddr.String(),
+		h.TargetProtoAddr.String())
+}
+
+// String returns a minimal encoding of the ArpHdr struct in the style of
+// tcpdump.
+func (h *ArpHdr) String() string {
+	switch h.Op {
+	case ArpRequest:
+		return fmt.Sprintf("who-has %s tell %s",
+			h.TargetProtoAddr.String(),
+			h.SenderProtoAddr.String())
+	case ArpReply:
+		return fmt.Sprintf("%s is-at %s",
+			h.SenderProtoAddr.String(),
+			h.SenderHwAddr.String())
+	}
+	return fmt.Sprintf("%s(%s)->%s(%s) op %d",
+		h.SenderProtoAddr.String(),
+		h.SenderHwAddr.String(),
+		h.TargetProtoAddr.String(),
+		h.TargetHwAddr.String(),
+		h.Op)
+}
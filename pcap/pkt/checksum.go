@@ -0,0 +1,199 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"fmt"
+	"net"
+)
+
+// A ChecksumStatus is the outcome of checking a checksum.
+type ChecksumStatus uint8
+
+// These are the values of ChecksumStatus.
+const (
+	ChecksumUnknown   ChecksumStatus = iota // not enough of the packet was captured to tell
+	ChecksumValid                           // the checksum is right
+	ChecksumInvalid                         // the checksum is wrong
+	ChecksumOffloaded                       // zero or partial, as left for the NIC to fill in
+	ChecksumNone                            // no checksum was sent (UDP over IPv4)
+	NumChecksumStatus                       // number of ChecksumStatus values
+)
+
+var checksumStatus = [NumChecksumStatus]string{
+	"unknown",
+	"valid",
+	"invalid",
+	"offloaded",
+	"none",
+}
+
+// String returns the name of the ChecksumStatus.
+func (s ChecksumStatus) String() string {
+	if s < NumChecksumStatus {
+		return checksumStatus[s]
+	}
+	return fmt.Sprintf("ChecksumStatus(%d)", s)
+}
+
+// sum32 adds the 16-bit words of b to sum without folding the carries.  An
+// odd last byte is padded with a zero.
+func sum32(b []byte, sum uint32) uint32 {
+	for ; len(b) >= 2; b = b[2:] {
+		sum += uint32(b[0])<<8 | uint32(b[1])
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	return sum
+}
+
+// fold folds the carries of sum back into 16 bits.
+func fold(sum uint32) uint16 {
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+	return uint16(sum)
+}
+
+// onesSum returns the 16-bit one's complement sum of b, the core of the
+// Internet checksum (RFC 1071).  An odd last byte is padded with a zero.
+func onesSum(b []byte) uint16 {
+	return fold(sum32(b, 0))
+}
+
+// pseudoSum returns the sum of the pseudo-header that the TCP and UDP
+// checksums cover, for IPv4 (RFC 768) or IPv6 (RFC 8200) addresses.
+func pseudoSum(src, dst net.IP, proto uint8, n int) uint32 {
+	sum := sum32(dst, sum32(src, 0))
+	return sum + uint32(proto) + uint32(n>>16) + uint32(n&0xFFFF)
+}
+
+// verifySegment checks the checksum check of the TCP or UDP segment seg
+// against the pseudo-header sum pseudo.  The checksum a host leaves for the
+// NIC to fill in is either zero or the folded pseudo-header sum.
+func verifySegment(pseudo uint32, seg []byte, check uint16) ChecksumStatus {
+	if fold(sum32(seg, pseudo)) == 0xFFFF {
+		return ChecksumValid
+	}
+	if check == 0 || check == fold(pseudo) {
+		return ChecksumOffloaded
+	}
+	return ChecksumInvalid
+}
+
+// Verify checks the IPv4 header checksum.  A zero checksum is taken to have
+// been left for the NIC to fill in.  A header that was not decoded from a
+// capture, for instance one read back with gob, gives ChecksumUnknown.
+func (h *IpHdr) Verify() ChecksumStatus {
+	if h.raw == nil {
+		return ChecksumUnknown
+	}
+	if onesSum(h.raw[:int(h.Ihl)*4]) == 0xFFFF {
+		return ChecksumValid
+	}
+	if h.Check == 0 {
+		return ChecksumOffloaded
+	}
+	return ChecksumInvalid
+}
+
+// fragmented reports whether ip is the header of a fragment, whose checksum
+// covers more than the fragment holds.
+func fragmented(ip InetProtoHdr) bool {
+	switch h := ip.(type) {
+	case *IpHdr:
+		return h.MoreFragments || h.FragOffset != 0
+	case *Ip6Hdr:
+		return h.Fragment() != nil
+	}
+	return false
+}
+
+// pseudoDst returns the destination address of the pseudo-header made from
+// ip.  When an IPv6 routing header has segments left, ip.Dst() is only the
+// next hop and the sender used the final destination, which is taken from the
+// routing header.  The result is nil if the routing header is of a type we
+// can't find the final destination in.
+func pseudoDst(ip InetProtoHdr) net.IP {
+	if h, ok := ip.(*Ip6Hdr); ok {
+		for _, e := range h.Extensions() {
+			if r, ok := e.(*Ip6RoutingHdr); ok && r.SegmentsLeft > 0 {
+				return r.finalDst()
+			}
+		}
+	}
+	return ip.Dst()
+}
+
+// Verify checks the TCP checksum, which covers the segment and a
+// pseudo-header made from ip, with the final destination of an IPv6 routing
+// header.  The result is ChecksumUnknown when part of the segment was not
+// captured, ip is the header of a fragment, the routing header is of a type
+// Verify can't read, or the header was not decoded from a capture.
+func (h *TcpHdr) Verify(ip InetProtoHdr) ChecksumStatus {
+	n := int(h.Doff)*4 + int(h.PayloadLen(ip.PL()))
+	dst := pseudoDst(ip)
+	if h.raw == nil || n > len(h.raw) || fragmented(ip) || dst == nil {
+		return ChecksumUnknown
+	}
+	return verifySegment(pseudoSum(ip.Src(), dst, IpProtoTCP, n), h.raw[:n], h.Check)
+}
+
+// Verify checks the UDP checksum, which covers the datagram and a
+// pseudo-header made from ip, the same way TcpHdr.Verify does.  Over IPv4 a
+// zero checksum means none was sent.
+func (h *UdpHdr) Verify(ip InetProtoHdr) ChecksumStatus {
+	n := int(h.Len)
+	dst := pseudoDst(ip)
+	if h.raw == nil || n > len(h.raw) || fragmented(ip) || dst == nil {
+		return ChecksumUnknown
+	}
+	if _, ok := ip.(*IpHdr); ok && h.Check == 0 {
+		return ChecksumNone
+	}
+	return verifySegment(pseudoSum(ip.Src(), dst, IpProtoUDP, n), h.raw[:n], h.Check)
+}
+
+// Checksum checks the IPv4 header, TCP and UDP checksums of the Packet and
+// sums them up: ChecksumInvalid if any of them is wrong, then
+// ChecksumOffloaded, ChecksumUnknown, and ChecksumValid if all of them are
+// right or absent.  Offloading only happens on the way out, so when local
+// reports that the source address is not one of this host's, a checksum that
+// looks offloaded counts as invalid.  A nil local takes every address to be
+// local.
+func (p *Packet) Checksum(local func(net.IP) bool) ChecksumStatus {
+	ip, ok := p.Headers[NetworkLayer].(InetProtoHdr)
+	if !ok {
+		return ChecksumUnknown
+	}
+	var s []ChecksumStatus
+	if h, ok := ip.(*IpHdr); ok {
+		s = append(s, h.Verify())
+	}
+	if len(p.Headers) > TransportLayer {
+		switch h := p.Headers[TransportLayer].(type) {
+		case *TcpHdr:
+			s = append(s, h.Verify(ip))
+		case *UdpHdr:
+			s = append(s, h.Verify(ip))
+		}
+	}
+	r := ChecksumValid
+	for _, c := range s {
+		switch {
+		case c == ChecksumInvalid:
+			return ChecksumInvalid
+		case c == ChecksumOffloaded:
+			r = ChecksumOffloaded
+		case c == ChecksumUnknown && r == ChecksumValid:
+			r = ChecksumUnknown
+		}
+	}
+	if r == ChecksumOffloaded && local != nil && !local(ip.Src()) {
+		return ChecksumInvalid
+	}
+	return r
+}
@@ -0,0 +1,279 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"encoding/binary"
+)
+
+// DecodePacket returns the Packet for the captured bytes in data, with its
+// headers decoded as far as they go.  linkType is the link-layer header type
+// of data, as pcap_datalink() gives it, and has to be one of the LinkType
+// constants.  The link-layer types that carry IP without a link-layer header
+// leave Headers[LinkLayer] nil.  data is not copied, so it has to stay
+// unchanged for as long as the Packet is in use.  Caplen and Len are both set
+// to len(data).
+//
+// Every multi-byte field is read in network byte order through encoding/binary
+// so the headers come out the same with or without cgo, on big and
+// little-endian hosts alike.
+func DecodePacket(data []byte, linkType int32) *Packet {
+	p := &Packet{
+		Caplen:   uint32(len(data)),
+		Len:      uint32(len(data)),
+		Headers:  make([]Hdr, 3),
+		LinkType: linkType,
+		data:     data,
+	}
+	p.decode()
+	return p
+}
+
+// Decode decodes the headers of a Packet.  Decoding stops at the first header
+// that does not fit in the capture; the error is kept for Err.
+func (p *Packet) decode() {
+	p.outer = nil
+	p.err = p.decodeHeaders()
+}
+
+func (p *Packet) decodeHeaders() error {
+	return p.decodeLayers(p.LinkType, p.data, 0)
+}
+
+// decodeLayers decodes the headers of the packet b of the given link-layer
+// type, and of any packet it carries through a tunnel, which is depth
+// tunnels deep.
+func (p *Packet) decodeLayers(linkType int32, b []byte, depth int) error {
+	var etherType uint16
+	linkAt := p.extraLen()
+	switch linkType {
+	case LinkTypeEthernet:
+		ethHdr, next, err := NewEthHdr(b)
+		if err != nil {
+			return err
+		}
+		p.Headers[LinkLayer], etherType, b = ethHdr, ethHdr.EtherType, next
+	case LinkTypeLinuxSLL:
+		sllHdr, next, err := NewSllHdr(b)
+		if err != nil {
+			return err
+		}
+		p.Headers[LinkLayer], etherType, b = sllHdr, sllHdr.Protocol, next
+	case LinkTypeLinuxSLL2:
+		sll2Hdr, next, err := NewSll2Hdr(b)
+		if err != nil {
+			return err
+		}
+		p.Headers[LinkLayer], etherType, b = sll2Hdr, sll2Hdr.Protocol, next
+	case LinkTypeNull, LinkTypeLoop:
+		if len(b) < bsdLoHdrLen {
+			return &DecodeError{Reason: DecodeTruncated, Hdr: "bsd_loopback", Value: bsdLoHdrLen, Caplen: len(b)}
+		}
+		family := bsdLoFamily(b)
+		if etherType = loEtherType(family); etherType == 0 {
+			return &DecodeError{Reason: DecodeUnsupportedEtherType, Hdr: "bsd_loopback", Value: int(family)}
+		}
+		b = b[bsdLoHdrLen:]
+	case LinkTypeRaw, LinkTypeIPv4, LinkTypeIPv6:
+		if etherType = rawEtherType(linkType, b); etherType == 0 {
+			e := rawError(b)
+			return &e
+		}
+	default:
+		h, next, err := decodeRegistered(linkTypeDecoder(linkType), b)
+		if h == nil {
+			if err == nil {
+				err = &DecodeError{Reason: DecodeUnsupportedLink, Value: int(linkType)}
+			}
+			return err
+		}
+		p.Headers[LinkLayer], b = h, next
+		l, ok := h.(LinkHdr)
+		if !ok {
+			return nil
+		}
+		etherType = l.NextEtherType()
+	}
+
+	// Any number of 802.1Q tags (QinQ has two) can come before the payload.
+	for isVlanTag(etherType) {
+		tag, next, err := NewDot1QHdr(b)
+		if err != nil {
+			return err
+		}
+		p.addHeader(tag)
+		etherType, b = tag.EtherType, next
+	}
+
+	// An MPLS label stack hides what it carries, which is taken to be IP.
+	if isMpls(etherType) {
+		mplsHdr, next, err := NewMplsHdr(b)
+		if err != nil {
+			return err
+		}
+		p.addHeader(mplsHdr)
+		if etherType, b = mplsHdr.EtherType, next; etherType == 0 {
+			e := rawError(b)
+			e.Hdr = "mpls"
+			return &e
+		}
+	}
+
+	var (
+		proto    uint8
+		fragment bool // tunnels are only decoded once reassembled
+	)
+	netAt := p.extraLen()
+	switch etherType {
+	case EtherTypeIPv4:
+		ipHdr, next, err := NewIpHdr(b)
+		if err != nil {
+			return err
+		}
+		p.Headers[NetworkLayer], b, proto = ipHdr, next, ipHdr.Protocol
+		// Only the first fragment has the upper-layer header.
+		if ipHdr.FragOffset != 0 {
+			return nil
+		}
+		fragment = ipHdr.MoreFragments
+	case EtherTypeIPv6:
+		ip6Hdr, next, err := NewIp6Hdr(b)
+		if err != nil {
+			return err
+		}
+		p.Headers[NetworkLayer], b, proto = ip6Hdr, next, ip6Hdr.Protocol
+		for _, e := range ip6Hdr.Extensions() {
+			p.addHeader(e)
+		}
+		// Only the first fragment has the upper-layer header.
+		if f := ip6Hdr.Fragment(); f != nil && f.Offset != 0 {
+			return nil
+		}
+		fragment = ip6Hdr.Fragment() != nil
+	case EtherTypeARP:
+		arpHdr, _, err := NewArpHdr(b)
+		if err != nil {
+			return err
+		}
+		p.Headers[NetworkLayer] = arpHdr
+		return nil
+	default:
+		h, next, err := decodeRegistered(etherTypeDecoder(etherType), b)
+		if h == nil {
+			if err == nil {
+				err = &DecodeError{Reason: DecodeUnsupportedEtherType, Hdr: "ether_header", Value: int(etherType)}
+			}
+			return err
+		}
+		p.Headers[NetworkLayer] = h
+		ip, ok := h.(InetProtoHdr)
+		if !ok {
+			return nil
+		}
+		b, proto = next, ip.Proto()
+	}
+
+	switch proto {
+	case IpProtoTCP:
+		tcpHdr, next, err := NewTcpHdr(b)
+		if err != nil {
+			return err
+		}
+		p.Headers[TransportLayer] = tcpHdr
+		if ip, ok := p.Headers[NetworkLayer].(InetProtoHdr); ok {
+			next = tcpHdr.GetPayloadBytes(ip.PL())
+		}
+		return p.decodeApplication(tcpHdr, next)
+	case IpProtoUDP:
+		udpHdr, next, err := NewUdpHdr(b)
+		if err != nil {
+			return err
+		}
+		p.Headers[TransportLayer] = udpHdr
+		if !fragment && (udpHdr.Dest == VxlanPort || udpHdr.Dest == GenevePort) {
+			return p.decodeTunnel(proto, udpHdr.Dest, next, linkAt, netAt, depth)
+		}
+		if ip, ok := p.Headers[NetworkLayer].(InetProtoHdr); ok {
+			next = udpHdr.GetPayloadBytes(ip.PL())
+		}
+		return p.decodeApplication(udpHdr, next)
+	case IpProtoICMP:
+		icmpHdr, _, err := NewIcmpHdr(b)
+		if err != nil {
+			return err
+		}
+		p.Headers[TransportLayer] = icmpHdr
+	case IpProtoICMPv6:
+		icmp6Hdr, _, err := NewIcmp6Hdr(b)
+		if err != nil {
+			return err
+		}
+		p.Headers[TransportLayer] = icmp6Hdr
+	case IpProtoGRE, IpProtoIPIP, IpProtoIPv6:
+		if !fragment {
+			return p.decodeTunnel(proto, 0, b, linkAt, netAt, depth)
+		}
+	default:
+		h, next, err := decodeRegistered(ipProtoDecoder(proto), b)
+		if h == nil {
+			return err
+		}
+		p.Headers[TransportLayer] = h
+		if ph, ok := h.(PortHdr); ok {
+			return p.decodeApplication(ph, next)
+		}
+	}
+	return nil
+}
+
+// bsdLoFamily returns the address family from a DLT_NULL or DLT_LOOP header.
+// DLT_NULL is written in the byte order of the host that did the capture,
+// which might not be ours when reading a savefile, and DLT_LOOP in network
+// byte order, so a value that does not fit in the low 16 bits is taken to be
+// byte-swapped.
+func bsdLoFamily(b []byte) uint32 {
+	family := binary.NativeEndian.Uint32(b)
+	if family&0xFFFF0000 != 0 {
+		family = family>>24 | family>>8&0xFF00 | family<<8&0xFF0000 | family<<24
+	}
+	return family
+}
+
+// loEtherType returns the EtherType for the address family of a DLT_NULL or
+// DLT_LOOP header, or 0 if it is not one we decode.
+func loEtherType(family uint32) uint16 {
+	switch family {
+	case BSD_LO_IPV4:
+		return EtherTypeIPv4
+	case BSD_LO_IPV6, FBSD_LO_IPV6, OSX_LO_IPV6:
+		return EtherTypeIPv6
+	}
+	return 0
+}
+
+// rawEtherType returns the EtherType of the packet b of a link-layer type
+// without a link-layer header.  DLT_RAW carries either version of IP, told
+// apart by the version field; 0 is returned when it is neither.
+func rawEtherType(linkType int32, b []byte) uint16 {
+	switch {
+	case linkType == LinkTypeIPv4:
+		return EtherTypeIPv4
+	case linkType == LinkTypeIPv6:
+		return EtherTypeIPv6
+	case len(b) > 0 && b[0]>>4 == 4:
+		return EtherTypeIPv4
+	case len(b) > 0 && b[0]>>4 == 6:
+		return EtherTypeIPv6
+	}
+	return 0
+}
+
+// rawError returns why rawEtherType gave 0 for b.
+func rawError(b []byte) DecodeError {
+	if len(b) == 0 {
+		return DecodeError{Reason: DecodeTruncated, Hdr: "iphdr", Value: 1, Caplen: 0}
+	}
+	return DecodeError{Reason: DecodeBadVersion, Hdr: "iphdr", Value: int(b[0] >> 4)}
+}
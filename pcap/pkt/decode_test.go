@@ -0,0 +1,819 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !appengine
+
+package pkt
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// guarded copies b to the very end of a mapping that is followed by an
+// inaccessible page, so a decoder that reads past the end of b faults instead
+// of quietly reading whatever comes next.
+func guarded(t testing.TB, b []byte) ([]byte, func()) {
+	pg := syscall.Getpagesize()
+	n := (len(b)/pg + 2) * pg
+	m, err := syscall.Mmap(-1, 0, n, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		t.Fatalf("syscall.Mmap: %v", err)
+	}
+	if err = syscall.Mprotect(m[n-pg:], syscall.PROT_NONE); err != nil {
+		t.Fatalf("syscall.Mprotect: %v", err)
+	}
+	copy(m[n-pg-len(b):], b)
+	return m[n-pg-len(b) : n-pg : n-pg], func() { syscall.Munmap(m) }
+}
+
+// decodeGuarded runs the full decoder over a guarded copy of the Ethernet
+// frame.
+func decodeGuarded(t testing.TB, frame []byte) *Packet {
+	return decodeGuardedLink(t, frame, LinkTypeEthernet)
+}
+
+// decodeGuardedLink runs the full decoder over a guarded copy of frame, of the
+// given link-layer type.
+func decodeGuardedLink(t testing.TB, frame []byte, linkType int32) *Packet {
+	b, done := guarded(t, frame)
+	defer done()
+	p := DecodePacket(b, linkType)
+	// Touch every payload byte the headers hand out while the mapping is
+	// still there.
+	if ip, ok := p.Headers[NetworkLayer].(InetProtoHdr); ok {
+		var sum byte
+		switch h := p.Headers[TransportLayer].(type) {
+		case *TcpHdr:
+			for _, c := range h.GetPayloadBytes(ip.PL()) {
+				sum += c
+			}
+		case *UdpHdr:
+			for _, c := range h.GetPayloadBytes(ip.PL()) {
+				sum += c
+			}
+		}
+		_ = sum
+	}
+	return p
+}
+
+// tcp6EthFrame is tcp6SllFrame with an Ethernet header instead.
+var tcp6EthFrame = append(append(append([]byte{}, tcp4EthFrame[:ethTypeOff]...), 0x86, 0xdd),
+	tcp6SllFrame[sllHdrLen:]...)
+
+// tagged returns a copy of the Ethernet frame b with 802.1Q tags inserted in
+// front of its EtherType.  Each tag is given as TPID and TCI.
+func tagged(b []byte, tags ...uint16) []byte {
+	c := append([]byte{}, b[:ethTypeOff]...)
+	for _, v := range tags {
+		c = binary.BigEndian.AppendUint16(c, v)
+	}
+	return append(c, b[ethTypeOff:]...)
+}
+
+// withMpls returns a copy of the Ethernet frame b with an MPLS label stack of
+// the given labels inserted after its EtherType, which becomes 0x8847.  The
+// bottom of stack bit is set on the last label and every TTL is 64.
+func withMpls(b []byte, labels ...uint32) []byte {
+	c := binary.BigEndian.AppendUint16(append([]byte{}, b[:ethTypeOff]...), EtherTypeMPLS)
+	for i, l := range labels {
+		e := l<<12 | 64
+		if i == len(labels)-1 {
+			e |= 0x100
+		}
+		c = binary.BigEndian.AppendUint32(c, e)
+	}
+	return append(c, b[ethHdrLen:]...)
+}
+
+// patch returns a copy of b with the bytes at off replaced by v.
+func patch(b []byte, off int, v ...byte) []byte {
+	c := append([]byte{}, b...)
+	copy(c[off:], v)
+	return c
+}
+
+// malformedFrames are Ethernet frames that used to send the decoders past the
+// end of the capture.  They double as the seed corpus for the fuzz tests.
+var malformedFrames = map[string]struct {
+	frame []byte
+	hdr   string // expected DecodeError.Hdr
+	field string // expected DecodeError.Field
+}{
+	"short ether": {tcp4EthFrame[:ethHdrLen-1], "ether_header", ""},
+	"short ip":    {tcp4EthFrame[:ethHdrLen+ipHdrLen-1], "iphdr", ""},
+	"ihl 0":       {patch(tcp4EthFrame, ethHdrLen, 0x40), "iphdr", "ihl"},
+	"ihl 15":      {patch(tcp4EthFrame[:ethHdrLen+ipHdrLen+8], ethHdrLen, 0x4f), "iphdr", ""},
+	"tot_len 4":   {patch(tcp4EthFrame, ethHdrLen+ipTotLenOff, 0, 4), "iphdr", "tot_len"},
+	"short ip6":   {tcp6EthFrame[:ethHdrLen+IPV6_HEADER_LEN-1], "ip6hdr", ""},
+	"short tcp":   {tcp4EthFrame[:ethHdrLen+ipHdrLen+tcpHdrLen-1], "tcphdr", ""},
+	"doff 0":      {patch(tcp4EthFrame, ethHdrLen+ipHdrLen+tcpFlagsOff, 0x00), "tcphdr", "doff"},
+	"doff 15":     {patch(tcp4EthFrame, ethHdrLen+ipHdrLen+tcpFlagsOff, 0xf0), "tcphdr", ""},
+	"short udp": {patch(tcp4EthFrame[:ethHdrLen+ipHdrLen+udpHdrLen-1], ethHdrLen+ipProtocolOff, IpProtoUDP),
+		"udphdr", ""},
+	"udp len 4": {patch(patch(tcp4EthFrame, ethHdrLen+ipProtocolOff, IpProtoUDP), ethHdrLen+ipHdrLen+4, 0, 4),
+		"udphdr", "len"},
+}
+
+// Make sure the full decoder reports malformed frames instead of reading past
+// the end of them.
+func TestDecodeMalformed(t *testing.T) {
+	for name, m := range malformedFrames {
+		p := decodeGuarded(t, m.frame)
+		e, ok := p.Err().(*DecodeError)
+		if !ok {
+			t.Errorf("%s: p.Err() = %v, want a *DecodeError", name, p.Err())
+			continue
+		}
+		reason := DecodeTruncated
+		if m.field != "" {
+			reason = DecodeBadHeaderLength
+		}
+		if e.Reason != reason || e.Hdr != m.hdr || e.Field != m.field {
+			t.Errorf("%s: p.Err() = %v, want %s hdr %q field %q", name, e, reason, m.hdr, m.field)
+		}
+	}
+}
+
+// Make sure well-formed frames still decode all the way down, and that a
+// short snaplen only shortens the payload.
+func TestDecodeWellFormed(t *testing.T) {
+	for name, frame := range map[string][]byte{
+		"ipv4":      tcp4EthFrame,
+		"ipv6":      tcp6EthFrame,
+		"truncated": tcp4EthFrame[:len(tcp4EthFrame)-2],
+	} {
+		p := decodeGuarded(t, frame)
+		if p.Err() != nil {
+			t.Errorf("%s: p.Err() = %v", name, p.Err())
+		}
+		if _, ok := p.Headers[TransportLayer].(*TcpHdr); !ok {
+			t.Errorf("%s: no TcpHdr", name)
+		}
+	}
+}
+
+// Make sure every link-layer type gets down to the transport layer, and that
+// the cooked headers are decoded.
+func TestDecodeLinkTypes(t *testing.T) {
+	for name, c := range map[string]struct {
+		frame    []byte
+		linkType int32
+	}{
+		"ethernet": {tcp4EthFrame, LinkTypeEthernet},
+		"sll":      {tcp6SllFrame, LinkTypeLinuxSLL},
+		"sll2":     {sll2Frame(), LinkTypeLinuxSLL2},
+		"null":     {bsdLoFrame(binary.LittleEndian), LinkTypeNull},
+		"loop":     {bsdLoFrame(binary.BigEndian), LinkTypeLoop},
+		"raw ipv4": {tcp4EthFrame[ethHdrLen:], LinkTypeRaw},
+		"raw ipv6": {tcp6EthFrame[ethHdrLen:], LinkTypeRaw},
+		"ipv4":     {tcp4EthFrame[ethHdrLen:], LinkTypeIPv4},
+		"ipv6":     {tcp6EthFrame[ethHdrLen:], LinkTypeIPv6},
+	} {
+		p := decodeGuardedLink(t, c.frame, c.linkType)
+		if p.Err() != nil {
+			t.Errorf("%s: p.Err() = %v", name, p.Err())
+		}
+		if _, ok := p.Headers[TransportLayer].(*TcpHdr); !ok {
+			t.Errorf("%s: no TcpHdr", name)
+		}
+	}
+
+	mac := "66:77:88:99:aa:bb"
+	p := decodeGuardedLink(t, tcp6SllFrame, LinkTypeLinuxSLL)
+	if h, ok := p.Headers[LinkLayer].(*SllHdr); !ok || h.PacketType != SllHost || h.ArpHrd != 1 || h.Addr.String() != mac || h.Protocol != EtherTypeIPv6 {
+		t.Errorf("sll: link layer = %v", p.Headers[LinkLayer])
+	}
+	if _, _, ok := p.Interface(); ok {
+		t.Errorf("sll: p.Interface() ok")
+	}
+	p = decodeGuardedLink(t, sll2Frame(), LinkTypeLinuxSLL2)
+	if h, ok := p.Headers[LinkLayer].(*Sll2Hdr); !ok || h.PacketType != SllOutgoing || h.Addr.String() != mac || h.Protocol != EtherTypeIPv6 {
+		t.Errorf("sll2: link layer = %v", p.Headers[LinkLayer])
+	}
+	if i, _, ok := p.Interface(); i != 3 || !ok {
+		t.Errorf("sll2: p.Interface() = %d, %t, want 3, true", i, ok)
+	}
+
+	for name, c := range map[string]struct {
+		frame    []byte
+		linkType int32
+		reason   DecodeReason
+	}{
+		"ether_type 0": {patch(tcp4EthFrame, ethTypeOff, 0, 0), LinkTypeEthernet, DecodeUnsupportedEtherType},
+		"short sll":    {tcp6SllFrame[:sllHdrLen-1], LinkTypeLinuxSLL, DecodeTruncated},
+		"short sll2":   {sll2Frame()[:sll2HdrLen-1], LinkTypeLinuxSLL2, DecodeTruncated},
+		"short loop":   {bsdLoFrame(binary.BigEndian)[:bsdLoHdrLen-1], LinkTypeLoop, DecodeTruncated},
+		"raw empty":    {nil, LinkTypeRaw, DecodeTruncated},
+		"raw version":  {patch(tcp4EthFrame[ethHdrLen:], 0, 0x55), LinkTypeRaw, DecodeBadVersion},
+		"unknown":      {tcp4EthFrame, 147, DecodeUnsupportedLink},
+	} {
+		p := decodeGuardedLink(t, c.frame, c.linkType)
+		if e, ok := p.Err().(*DecodeError); !ok || e.Reason != c.reason {
+			t.Errorf("%s: p.Err() = %v, want %s", name, p.Err(), c.reason)
+		}
+	}
+}
+
+// Make sure 802.1Q and QinQ tags end up in the extra headers and the rest of
+// the frame still decodes.
+func TestDecodeVlan(t *testing.T) {
+	p := decodeGuarded(t, tagged(tcp4EthFrame, EtherTypeQinQ, 0x0064, EtherTypeDot1Q, 0xb0c8))
+	if p.Err() != nil {
+		t.Fatalf("p.Err() = %v", p.Err())
+	}
+	if len(p.Headers) != ExtraHeaders+2 {
+		t.Fatalf("len(p.Headers) = %d, want %d", len(p.Headers), ExtraHeaders+2)
+	}
+	want := []Dot1QHdr{
+		{Priority: 0, VLANID: 100, EtherType: EtherTypeDot1Q},
+		{Priority: 5, DEI: true, VLANID: 200, EtherType: EtherTypeIPv4},
+	}
+	for i := range want {
+		tag, ok := p.Headers[ExtraHeaders+i].(*Dot1QHdr)
+		if !ok || *tag != want[i] {
+			t.Errorf("p.Headers[%d] = %v, want %v", ExtraHeaders+i, p.Headers[ExtraHeaders+i], want[i])
+		}
+	}
+	if _, ok := p.Headers[TransportLayer].(*TcpHdr); !ok {
+		t.Error("no TcpHdr")
+	}
+	var j struct {
+		Tags []struct {
+			Vid int `json:"vid"`
+		} `json:"vlan_tags"`
+	}
+	if err := json.Unmarshal([]byte(p.JsonString()), &j); err != nil {
+		t.Fatalf("json.Unmarshal(p.JsonString()): %v", err)
+	}
+	if len(j.Tags) != 2 || j.Tags[0].Vid != 100 || j.Tags[1].Vid != 200 {
+		t.Errorf("p.JsonString() has vlan_tags %+v, want 100 and 200", j.Tags)
+	}
+
+	p = decodeGuarded(t, tagged(tcp4EthFrame, EtherTypeDot1Q)[:ethHdrLen+2])
+	if e, ok := p.Err().(*DecodeError); !ok || e.Reason != DecodeTruncated || e.Hdr != "vlan_tag" {
+		t.Errorf("p.Err() = %v, want a truncated vlan_tag", p.Err())
+	}
+}
+
+// Make sure an MPLS label stack ends up in the extra headers and the IP
+// packet under it still decodes, whichever version it is.
+func TestDecodeMpls(t *testing.T) {
+	for name, frame := range map[string][]byte{
+		"ipv4": withMpls(tcp4EthFrame, 16, 1048575),
+		"ipv6": withMpls(tcp6EthFrame, 16, 1048575),
+	} {
+		p := decodeGuarded(t, frame)
+		if p.Err() != nil {
+			t.Errorf("%s: p.Err() = %v", name, p.Err())
+			continue
+		}
+		if len(p.Headers) != ExtraHeaders+1 {
+			t.Errorf("%s: len(p.Headers) = %d, want %d", name, len(p.Headers), ExtraHeaders+1)
+			continue
+		}
+		h, ok := p.Headers[ExtraHeaders].(*MplsHdr)
+		if !ok {
+			t.Errorf("%s: p.Headers[%d] = %v, want an MplsHdr", name, ExtraHeaders, p.Headers[ExtraHeaders])
+			continue
+		}
+		want := []MplsLabel{{Label: 16, TTL: 64}, {Label: 1048575, S: true, TTL: 64}}
+		if !reflect.DeepEqual(h.Labels, want) {
+			t.Errorf("%s: h.Labels = %v, want %v", name, h.Labels, want)
+		}
+		if _, ok := p.Headers[TransportLayer].(*TcpHdr); !ok {
+			t.Errorf("%s: no TcpHdr", name)
+		}
+	}
+
+	for name, c := range map[string]struct {
+		frame  []byte
+		reason DecodeReason
+	}{
+		"no bottom":  {withMpls(tcp4EthFrame, 16)[:ethHdrLen+3], DecodeTruncated},
+		"no payload": {withMpls(tcp4EthFrame, 16)[:ethHdrLen+mplsLabelLen], DecodeTruncated},
+		"not ip":     {withMpls(arpEthFrame, 16), DecodeBadVersion},
+	} {
+		p := decodeGuarded(t, c.frame)
+		if e, ok := p.Err().(*DecodeError); !ok || e.Reason != c.reason || e.Hdr != "mpls" {
+			t.Errorf("%s: p.Err() = %v, want %s in mpls", name, p.Err(), c.reason)
+		}
+	}
+}
+
+// arpEthFrame is an Ethernet frame with an ARP request, padded to the minimum
+// frame size.
+var arpEthFrame = []byte{
+	// ether_header
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb,
+	0x08, 0x06,
+	// arphdr: Ethernet, IPv4, request
+	0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01,
+	0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 192, 168, 1, 2,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 10, 0, 0, 1,
+	// padding
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+}
+
+// Make sure ARP packets decode into the network layer.
+func TestDecodeArp(t *testing.T) {
+	p := decodeGuarded(t, arpEthFrame)
+	if p.Err() != nil {
+		t.Fatalf("p.Err() = %v", p.Err())
+	}
+	arp, ok := p.Headers[NetworkLayer].(*ArpHdr)
+	if !ok {
+		t.Fatalf("p.Headers[NetworkLayer] = %v, want an *ArpHdr", p.Headers[NetworkLayer])
+	}
+	if s := arp.String(); s != "who-has 10.0.0.1 tell 192.168.1.2" {
+		t.Errorf("arp.String() = %q", s)
+	}
+	if s := arp.SenderHwAddr.String(); s != "66:77:88:99:aa:bb" {
+		t.Errorf("arp.SenderHwAddr = %s", s)
+	}
+	if arp.IsGratuitous() || arp.IsProbe() {
+		t.Errorf("arp.IsGratuitous() = %t, arp.IsProbe() = %t", arp.IsGratuitous(), arp.IsProbe())
+	}
+
+	p = decodeGuarded(t, arpEthFrame[:ethHdrLen+arpHdrLen+10])
+	if e, ok := p.Err().(*DecodeError); !ok || e.Reason != DecodeTruncated || e.Hdr != "arphdr" {
+		t.Errorf("p.Err() = %v, want a truncated arphdr", p.Err())
+	}
+}
+
+// fragNeededFrame is an Ethernet frame with an ICMP fragmentation needed
+// message about the first segment of tcp4EthFrame.
+var fragNeededFrame = append([]byte{
+	// ether_header
+	0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55,
+	0x08, 0x00,
+	// iphdr: ihl 5, tot_len 56, ttl 64, ICMP
+	0x45, 0x00, 0x00, 0x38, 0x00, 0x00, 0x00, 0x00, 0x40, 0x01, 0x00, 0x00,
+	172, 16, 0, 1,
+	192, 168, 1, 2,
+	// icmphdr: unreachable, need to frag, mtu 1400
+	0x03, 0x04, 0x00, 0x00, 0x00, 0x00, 0x05, 0x78,
+}, tcp4EthFrame[ethHdrLen:ethHdrLen+ipHdrLen+8]...)
+
+// Make sure ICMP errors decode along with the datagram they quote.
+func TestDecodeIcmp(t *testing.T) {
+	p := decodeGuarded(t, fragNeededFrame)
+	if p.Err() != nil {
+		t.Fatalf("p.Err() = %v", p.Err())
+	}
+	icmp, ok := p.Headers[TransportLayer].(*IcmpHdr)
+	if !ok {
+		t.Fatalf("p.Headers[TransportLayer] = %v, want an *IcmpHdr", p.Headers[TransportLayer])
+	}
+	if !icmp.IsError() || icmp.MTU != 1400 || icmp.Quote == nil {
+		t.Fatalf("icmp = %+v, want an error with mtu 1400 and a quote", icmp)
+	}
+	want := "unreachable need to frag (mtu 1400) for 192.168.1.2:8080->10.0.0.1:50000 0x6"
+	if s := icmp.String(); s != want {
+		t.Errorf("icmp.String() = %q, want %q", s, want)
+	}
+	if icmp.Quote.Seq != 424242 {
+		t.Errorf("icmp.Quote.Seq = %d, want 424242", icmp.Quote.Seq)
+	}
+
+	// A quote that is cut short still leaves the ICMP header.
+	p = decodeGuarded(t, fragNeededFrame[:ethHdrLen+ipHdrLen+icmpHdrLen+10])
+	icmp, ok = p.Headers[TransportLayer].(*IcmpHdr)
+	if p.Err() != nil || !ok || icmp.Quote != nil {
+		t.Errorf("short quote: p.Err() = %v, icmp = %v", p.Err(), icmp)
+	}
+
+	p = decodeGuarded(t, fragNeededFrame[:ethHdrLen+ipHdrLen+icmpHdrLen-1])
+	if e, ok := p.Err().(*DecodeError); !ok || e.Reason != DecodeTruncated || e.Hdr != "icmphdr" {
+		t.Errorf("p.Err() = %v, want a truncated icmphdr", p.Err())
+	}
+}
+
+// icmp6Frame returns an Ethernet frame with an IPv6 packet from fe80::1 to
+// ff02::1 carrying the ICMPv6 message m.
+func icmp6Frame(m ...byte) []byte {
+	b := append([]byte{}, tcp6EthFrame[:ethHdrLen+IPV6_HEADER_LEN]...)
+	binary.BigEndian.PutUint16(b[ethHdrLen+ip6PlenOff:], uint16(len(m)))
+	b[ethHdrLen+ip6NextHdrOff] = IpProtoICMPv6
+	copy(b[ethHdrLen+ip6SrcOff:], net.ParseIP("fe80::1"))
+	copy(b[ethHdrLen+ip6DstOff:], net.ParseIP("ff02::1"))
+	return append(b, m...)
+}
+
+var (
+	// A neighbor solicitation for 2001:db8::2 with a source link-layer
+	// address option.
+	neighborSolicitFrame = icmp6Frame(append([]byte{135, 0, 0, 0, 0, 0, 0, 0},
+		append(net.ParseIP("2001:db8::2"),
+			1, 1, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb)...)...)
+	// A router advertisement with a prefix information option for
+	// 2001:db8::/64 and a source link-layer address option.
+	routerAdvertFrame = icmp6Frame(append(append([]byte{
+		134, 0, 0, 0, 64, 0x40, 0x07, 0x08, 0, 0, 0, 0, 0, 0, 0, 0,
+		3, 4, 64, 0xc0, 0, 0x27, 0x8d, 0x00, 0, 0, 0x38, 0x40, 0, 0, 0, 0},
+		net.ParseIP("2001:db8::")...),
+		1, 1, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb)...)
+	// A packet too big message about the first segment of tcp6SllFrame.
+	packetTooBigFrame = icmp6Frame(append([]byte{2, 0, 0, 0, 0, 0, 0x05, 0x00},
+		tcp6SllFrame[sllHdrLen:sllHdrLen+IPV6_HEADER_LEN+8]...)...)
+)
+
+// Make sure ICMPv6 messages decode into their typed bodies.
+func TestDecodeIcmp6(t *testing.T) {
+	for name, c := range map[string]struct {
+		frame []byte
+		want  string
+	}{
+		"neighbor solicit": {neighborSolicitFrame, "neighbor solicitation who has 2001:db8::2 lladdr 66:77:88:99:aa:bb"},
+		"router advert":    {routerAdvertFrame, "router advertisement lifetime 1800s prefix 2001:db8::/64 lladdr 66:77:88:99:aa:bb"},
+		"packet too big":   {packetTooBigFrame, "packet too big (mtu 1280) for 2001:db8::1:80->2001:db8::2:54321 0x6"},
+		"unknown":          {icmp6Frame(200, 0, 0, 0, 0, 0, 0, 0), "icmp6 type 200 code 0"},
+	} {
+		p := decodeGuarded(t, c.frame)
+		if p.Err() != nil {
+			t.Errorf("%s: p.Err() = %v", name, p.Err())
+			continue
+		}
+		h, ok := p.Headers[TransportLayer].(*Icmp6Hdr)
+		if !ok {
+			t.Errorf("%s: p.Headers[TransportLayer] = %v, want an *Icmp6Hdr", name, p.Headers[TransportLayer])
+			continue
+		}
+		if s := h.String(); s != c.want {
+			t.Errorf("%s: h.String() = %q, want %q", name, s, c.want)
+		}
+		if !strings.Contains(p.JsonString(), "\"icmp6_hdr\"") {
+			t.Errorf("%s: no icmp6_hdr in %s", name, p.JsonString())
+		}
+	}
+
+	p := decodeGuarded(t, routerAdvertFrame)
+	ra := p.Headers[TransportLayer].(*Icmp6Hdr).Body.(*NdpRouterAdvert)
+	if ra.HopLimit != 64 || ra.Managed || !ra.Other || ra.Options[0].Prefix.ValidLifetime != 2592000 {
+		t.Errorf("ra = %+v", ra)
+	}
+
+	p = decodeGuarded(t, patch(neighborSolicitFrame, len(neighborSolicitFrame)-7, 0))
+	if e, ok := p.Err().(*DecodeError); !ok || e.Reason != DecodeBadHeaderLength || e.Field != "nd_opt_len" {
+		t.Errorf("p.Err() = %v, want a bad nd_opt_len", p.Err())
+	}
+}
+
+// An ip6Ext is an IPv6 extension header for withIp6Ext.
+type ip6Ext struct {
+	t uint8
+	b []byte
+}
+
+// withIp6Ext returns a copy of the frame b, with an IPv6 header at off, with
+// extension headers inserted in front of the upper-layer header.  The next
+// header and payload length fields are filled in.
+func withIp6Ext(b []byte, off int, exts ...ip6Ext) []byte {
+	c := append([]byte{}, b[:off+IPV6_HEADER_LEN]...)
+	next := &c[off+ip6NextHdrOff]
+	proto, n := *next, 0
+	for _, e := range exts {
+		*next = e.t
+		c = append(c, e.b...)
+		next = &c[len(c)-len(e.b)]
+		n += len(e.b)
+	}
+	*next = proto
+	plen := binary.BigEndian.Uint16(c[off+ip6PlenOff:])
+	binary.BigEndian.PutUint16(c[off+ip6PlenOff:], plen+uint16(n))
+	return append(c, b[off+IPV6_HEADER_LEN:]...)
+}
+
+var (
+	hopByHop   = ip6Ext{IpProtoHopOpts, []byte{0, 0, 5, 2, 0, 0, 1, 0}} // router alert
+	dstOpts    = ip6Ext{IpProtoDstOpts, []byte{0, 1, 1, 12, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}
+	firstFrag  = ip6Ext{IpProtoFragment, []byte{0, 0, 0x00, 0x01, 0, 0, 0x12, 0x34}}
+	secondFrag = ip6Ext{IpProtoFragment, []byte{0, 0, 0x05, 0xa8, 0, 0, 0x12, 0x34}}
+)
+
+// Make sure both decoders walk the IPv6 extension headers to the TCP header.
+func TestDecodeIp6Ext(t *testing.T) {
+	frame := withIp6Ext(tcp6EthFrame, ethHdrLen, hopByHop, dstOpts, firstFrag)
+	p := decodeGuarded(t, frame)
+	if p.Err() != nil {
+		t.Fatalf("p.Err() = %v", p.Err())
+	}
+	ip6 := p.Headers[NetworkLayer].(*Ip6Hdr)
+	if ip6.NextHeader != IpProtoHopOpts || ip6.Proto() != IpProtoTCP || ip6.PL() != 32 {
+		t.Errorf("ip6 next header %d, proto %d, pl %d, want 0, 6 and 32", ip6.NextHeader, ip6.Proto(), ip6.PL())
+	}
+	if len(p.Headers) != ExtraHeaders+3 {
+		t.Fatalf("len(p.Headers) = %d, want %d", len(p.Headers), ExtraHeaders+3)
+	}
+	if o, ok := p.Headers[ExtraHeaders+1].(*Ip6OptsHdr); !ok || o.Type != IpProtoDstOpts || len(o.Options) != 14 {
+		t.Errorf("p.Headers[%d] = %v, want the destination options", ExtraHeaders+1, p.Headers[ExtraHeaders+1])
+	}
+	if f := ip6.Fragment(); f == nil || f.Id != 0x1234 || f.Offset != 0 || !f.MoreFragments {
+		t.Errorf("ip6.Fragment() = %v, want the first fragment of 0x1234", f)
+	}
+	if tcp, ok := p.Headers[TransportLayer].(*TcpHdr); !ok || tcp.Source != 80 || tcp.PayloadLen(ip6.PL()) != 4 {
+		t.Errorf("p.Headers[TransportLayer] = %v, want a TcpHdr from port 80 with 4 bytes", p.Headers[TransportLayer])
+	}
+
+	var tp TcpPacket
+	if err := decodeTcpPacket(frame, LinkTypeEthernet, &tp); err != nil || string(tp.Payload) != "ping" {
+		t.Errorf("decodeTcpPacket = %v, payload %q", err, tp.Payload)
+	}
+
+	// Later fragments stop at the network layer.
+	frame = withIp6Ext(tcp6EthFrame, ethHdrLen, secondFrag)
+	p = decodeGuarded(t, frame)
+	if f := p.Headers[NetworkLayer].(*Ip6Hdr).Fragment(); p.Err() != nil || f == nil || f.Offset != 1448 {
+		t.Errorf("p.Err() = %v, fragment %v, want offset 1448", p.Err(), f)
+	}
+	if p.Headers[TransportLayer] != nil {
+		t.Errorf("p.Headers[TransportLayer] = %v, want nil", p.Headers[TransportLayer])
+	}
+	err := decodeTcpPacket(frame, LinkTypeEthernet, &tp)
+	if e, ok := err.(*DecodeError); !ok || e.Reason != DecodeFragment || e.Value != 1448 {
+		t.Errorf("decodeTcpPacket = %v, want a fragment at 1448", err)
+	}
+
+	frame = withIp6Ext(tcp6EthFrame, ethHdrLen, dstOpts)[:ethHdrLen+IPV6_HEADER_LEN+10]
+	p = decodeGuarded(t, frame)
+	if e, ok := p.Err().(*DecodeError); !ok || e.Reason != DecodeTruncated || e.Hdr != "ip6_dest" || e.Value != 16 {
+		t.Errorf("p.Err() = %v, want a truncated ip6_dest", p.Err())
+	}
+	err = decodeTcpPacket(frame, LinkTypeEthernet, &tp)
+	if e, ok := err.(*DecodeError); !ok || e.Reason != DecodeTruncated || e.Hdr != "ip6_dest" {
+		t.Errorf("decodeTcpPacket = %v, want a truncated ip6_dest", err)
+	}
+}
+
+// withIpOpts returns a copy of the Ethernet frame b with the IPv4 options
+// opts, a multiple of 4 bytes, added to its IP header.
+func withIpOpts(b []byte, opts ...byte) []byte {
+	c := append([]byte{}, b[:ethHdrLen+ipHdrLen]...)
+	c[ethHdrLen] += byte(len(opts) / 4)
+	totLen := binary.BigEndian.Uint16(c[ethHdrLen+ipTotLenOff:])
+	binary.BigEndian.PutUint16(c[ethHdrLen+ipTotLenOff:], totLen+uint16(len(opts)))
+	c = append(c, opts...)
+	return append(c, b[ethHdrLen+ipHdrLen:]...)
+}
+
+// Make sure the whole IPv4 header is decoded, options included.
+func TestDecodeIpFields(t *testing.T) {
+	frame := withIpOpts(patch(tcp4EthFrame, ethHdrLen+1, 0xb9), // DSCP 46 (EF), ECT(1)
+		IpOptRouterAlert, 4, 0, 0,
+		IpOptRecordRoute, 7, 8, 172, 16, 0, 1,
+		IpOptNOP, IpOptTimestamp, 8, 9, 0x10, 0, 0, 0x30, 0x39, IpOptEOL, 0, 0, 0)
+	p := decodeGuarded(t, frame)
+	if p.Err() != nil {
+		t.Fatalf("p.Err() = %v", p.Err())
+	}
+	ip := p.Headers[NetworkLayer].(*IpHdr)
+	if ip.DSCP() != 46 || ip.ECN() != 1 || ip.Id != 0x1234 || !ip.DontFragment || ip.MoreFragments || ip.FragOffset != 0 || ip.Ttl != 64 {
+		t.Errorf("ip = %+v", ip.IpFields)
+	}
+	if ip.PayloadLen != 26 {
+		t.Errorf("ip.PayloadLen = %d, want 26", ip.PayloadLen)
+	}
+	want := "RA{0} RR{172.16.0.1} TS{12345}"
+	if s := ip.optionsString(); s != want {
+		t.Errorf("ip.optionsString() = %q, want %q", s, want)
+	}
+	if !strings.Contains(ip.JsonElement(), "\"ttl\":64") || !strings.HasSuffix(ip.CsvElement(), ",\""+want+"\"") {
+		t.Errorf("ip.JsonElement() = %s, ip.CsvElement() = %s", ip.JsonElement(), ip.CsvElement())
+	}
+	if _, ok := p.Headers[TransportLayer].(*TcpHdr); !ok {
+		t.Error("no TcpHdr")
+	}
+
+	// Later fragments stop at the network layer.
+	frame = patch(tcp4EthFrame, ethHdrLen+ipFragOffOff, 0x20, 0xb9) // MF, offset 1480
+	p = decodeGuarded(t, frame)
+	ip = p.Headers[NetworkLayer].(*IpHdr)
+	if p.Err() != nil || !ip.MoreFragments || ip.FragOffset != 1480 || p.Headers[TransportLayer] != nil {
+		t.Errorf("p.Err() = %v, ip = %+v, transport %v", p.Err(), ip.IpFields, p.Headers[TransportLayer])
+	}
+	var tp TcpPacket
+	err := decodeTcpPacket(frame, LinkTypeEthernet, &tp)
+	if e, ok := err.(*DecodeError); !ok || e.Reason != DecodeFragment || e.Value != 1480 {
+		t.Errorf("decodeTcpPacket = %v, want a fragment at 1480", err)
+	}
+}
+
+// Make sure TCP options are decoded by both decoders, and that a bad one
+// stops the decoding of the options but not of the segment.
+func TestDecodeTcpOptions(t *testing.T) {
+	o := NewTcpOptions([]byte{
+		TcpOptNop, TcpOptNop, TcpOptTimestamps, 10, 0, 0, 0, 1, 0, 0, 0, 2,
+		TcpOptSack, 18, 0, 0, 0, 10, 0, 0, 0, 20, 0, 0, 0, 30, 0, 0, 0, 40,
+		TcpOptSackPermitted, 2, TcpOptFastOpen, 4, 0xab, 0xcd,
+		TcpOptExperimental, 4, 0xf9, 0x89, 99, 3, 7, TcpOptEnd, 0xff})
+	want := "[sackOK,TS val 1 ecr 2,sack 2 {10:20}{30:40},tfo cookiereq,unknown-99 07]"
+	if o.Malformed || o.String() != want {
+		t.Errorf("o = %s, want %s", o, want)
+	}
+
+	p := decodeGuarded(t, tcp6EthFrame)
+	h := p.Headers[TransportLayer].(*TcpHdr)
+	if h.Options == nil || h.Options.MSS != 1460 || h.Options.WindowShift() != 7 {
+		t.Errorf("h.Options = %v", h.Options)
+	}
+	if s := h.Options.String(); s != "[mss 1460,wscale 7]" {
+		t.Errorf("h.Options.String() = %s", s)
+	}
+	if s := h.String(); s != "80->54321 1 2 0x12" {
+		t.Errorf("h.String() = %s, want no options", s)
+	}
+	var tp TcpPacket
+	if err := decodeTcpPacket(tcp6SllFrame, LinkTypeLinuxSLL, &tp); err != nil || tp.Options().MSS != 1460 {
+		t.Errorf("decodeTcpPacket = %v, tp.Options() = %v", err, tp.Options())
+	}
+	if err := decodeTcpPacket(tcp4EthFrame, LinkTypeEthernet, &tp); err != nil || tp.Options().String() != "[]" {
+		t.Errorf("decodeTcpPacket = %v, tp.Options() = %v", err, tp.Options())
+	}
+
+	// A window scale option 4 bytes long instead of 3.
+	frame := patch(tcp6EthFrame, ethHdrLen+IPV6_HEADER_LEN+tcpHdrLen+4, TcpOptWScale, 4)
+	p = decodeGuarded(t, frame)
+	h = p.Headers[TransportLayer].(*TcpHdr)
+	if p.Err() != nil || !h.Options.Malformed || h.Options.MSS != 1460 || h.Options.HasWScale {
+		t.Errorf("p.Err() = %v, h.Options = %v", p.Err(), h.Options)
+	}
+}
+
+// Make sure checksums are verified against the pseudo-header, and that the
+// ones left for the NIC are told apart from the bad ones.
+func TestChecksum(t *testing.T) {
+	ipCheck, tcpCheck := ethHdrLen+ipCheckOff, ethHdrLen+ipHdrLen+tcpCheckOff
+	good := patch(patch(tcp4EthFrame, ipCheck, 0x5c, 0xeb), tcpCheck, 0x7a, 0xdf)
+	udp := patch(patch(tcp4EthFrame, ethHdrLen+ipProtocolOff, IpProtoUDP), ethHdrLen+ipHdrLen+4, 0, 26, 0, 0)
+	good6 := patch(tcp6EthFrame, ethHdrLen+IPV6_HEADER_LEN+tcpCheckOff, 0x75, 0x3a)
+	// routed returns good6 on its way to a next hop, with a routing header of
+	// type typ holding addrs and one segment left.
+	routed := func(typ uint8, addrs ...net.IP) []byte {
+		rt := []byte{0, uint8(2 * len(addrs)), typ, 1, 0, 0, 0, 0}
+		for _, a := range addrs {
+			rt = append(rt, a.To16()...)
+		}
+		b := withIp6Ext(good6, ethHdrLen, ip6Ext{IpProtoRouting, rt})
+		copy(b[ethHdrLen+ip6DstOff:], net.ParseIP("2001:db8::99"))
+		return b
+	}
+	dst6 := net.IP(good6[ethHdrLen+ip6DstOff:][:net.IPv6len])
+	for name, c := range map[string]struct {
+		frame    []byte
+		ip, tcp  ChecksumStatus
+		packet   ChecksumStatus
+		notLocal ChecksumStatus
+	}{
+		"valid":     {good, ChecksumValid, ChecksumValid, ChecksumValid, ChecksumValid},
+		"corrupt":   {patch(good, len(good)-1, '?'), ChecksumValid, ChecksumInvalid, ChecksumInvalid, ChecksumInvalid},
+		"bad ip":    {patch(good, ipCheck, 0x5c, 0xec), ChecksumInvalid, ChecksumValid, ChecksumInvalid, ChecksumInvalid},
+		"zero":      {tcp4EthFrame, ChecksumOffloaded, ChecksumOffloaded, ChecksumOffloaded, ChecksumInvalid},
+		"partial":   {patch(good, tcpCheck, 0xcb, 0xcb), ChecksumValid, ChecksumOffloaded, ChecksumOffloaded, ChecksumInvalid},
+		"truncated": {good[:len(good)-1], ChecksumValid, ChecksumUnknown, ChecksumUnknown, ChecksumUnknown},
+		"ipv6":      {good6, ChecksumUnknown, ChecksumValid, ChecksumValid, ChecksumValid},
+		"routing":   {routed(2, dst6), ChecksumUnknown, ChecksumValid, ChecksumValid, ChecksumValid},
+		"srh":       {routed(4, dst6, net.ParseIP("2001:db8::99")), ChecksumUnknown, ChecksumValid, ChecksumValid, ChecksumValid},
+		"rpl":       {routed(3, dst6), ChecksumUnknown, ChecksumUnknown, ChecksumUnknown, ChecksumUnknown},
+		"no udp":    {patch(udp, ipCheck, 0x5c, 0xe0), ChecksumValid, ChecksumNone, ChecksumValid, ChecksumValid},
+	} {
+		b, done := guarded(t, c.frame)
+		p := DecodePacket(b, LinkTypeEthernet)
+		ip := p.Headers[NetworkLayer].(InetProtoHdr)
+		s := ChecksumUnknown
+		if h, ok := ip.(*IpHdr); ok {
+			s = h.Verify()
+		}
+		if s != c.ip {
+			t.Errorf("%s: ip.Verify() = %s, want %s", name, s, c.ip)
+		}
+		switch h := p.Headers[TransportLayer].(type) {
+		case *TcpHdr:
+			s = h.Verify(ip)
+		case *UdpHdr:
+			s = h.Verify(ip)
+		}
+		if s != c.tcp {
+			t.Errorf("%s: Verify(ip) = %s, want %s", name, s, c.tcp)
+		}
+		if s = p.Checksum(nil); s != c.packet {
+			t.Errorf("%s: p.Checksum(nil) = %s, want %s", name, s, c.packet)
+		}
+		if s = p.Checksum(func(net.IP) bool { return false }); s != c.notLocal {
+			t.Errorf("%s: p.Checksum(not local) = %s, want %s", name, s, c.notLocal)
+		}
+		done()
+	}
+}
+
+// Make sure Keep copies the captured bytes and Redecode gets the same headers
+// back from them.
+func TestKeepRedecode(t *testing.T) {
+	frame := append([]byte(nil), tcp4EthFrame...)
+	p := DecodePacket(frame, LinkTypeEthernet)
+	if err := p.Redecode(); err != ErrNoRaw {
+		t.Errorf("p.Redecode() = %v, want ErrNoRaw", err)
+	}
+	p.Keep(ethHdrLen)
+	if string(p.Raw) != string(frame[:ethHdrLen]) {
+		t.Errorf("p.Keep(%d): p.Raw = % x", ethHdrLen, p.Raw)
+	}
+	p.Keep(-1)
+	want := p.String()
+	frame[len(frame)-1] = '?'
+	if err := p.Redecode(); err != nil {
+		t.Fatalf("p.Redecode() = %v", err)
+	}
+	if got := p.String(); got != want {
+		t.Errorf("p.Redecode(): p = %s, want %s", got, want)
+	}
+	ip := p.Headers[NetworkLayer].(InetProtoHdr)
+	if b := p.Headers[TransportLayer].(*TcpHdr).GetPayloadBytes(ip.PL()); string(b) != "hello!" {
+		t.Errorf("payload = %q, want %q", b, "hello!")
+	}
+}
+
+func FuzzPacketDecode(f *testing.F) {
+	f.Add(tcp4EthFrame)
+	f.Add(tcp6EthFrame)
+	f.Add(arpEthFrame)
+	f.Add(fragNeededFrame)
+	f.Add(neighborSolicitFrame)
+	f.Add(routerAdvertFrame)
+	f.Add(packetTooBigFrame)
+	f.Add(withIp6Ext(tcp6EthFrame, ethHdrLen, hopByHop, dstOpts, firstFrag))
+	f.Add(withIpOpts(tcp4EthFrame, IpOptRecordRoute, 7, 8, 172, 16, 0, 1, IpOptEOL))
+	f.Add(withIpOpts(tcp4EthFrame, IpOptTimestamp, 12, 13, 0x01, 172, 16, 0, 1, 0, 0, 0x30, 0x39))
+	f.Add(tagged(tcp4EthFrame, EtherTypeQinQ, 0x0064, EtherTypeDot1Q, 0x00c8))
+	f.Add(withMpls(tcp6EthFrame, 16, 17))
+	f.Add(tunnelFrame(f, tcp4EthFrame, outerEth, outerIp, &UdpHdr{Dest: VxlanPort}, &VxlanHdr{Flags: VxlanVNIValid, VNI: 1}))
+	f.Add(tunnelFrame(f, tcp6EthFrame[ethHdrLen:], outerEth, outerIp, &UdpHdr{Dest: GenevePort}, &GeneveHdr{Protocol: EtherTypeIPv6, Options: []byte{0, 1, 2, 1, 0, 0, 0, 0}}))
+	f.Add(tunnelFrame(f, tcp6EthFrame[ethHdrLen:], outerEth, outerIp, &GreHdr{Flags: GreKey | GreSeq, Protocol: EtherTypeIPv6}))
+	for _, m := range malformedFrames {
+		f.Add(m.frame)
+	}
+	f.Fuzz(func(t *testing.T, frame []byte) {
+		decodeGuarded(t, frame)
+	})
+}
+
+func FuzzDecodeTcpPacket(f *testing.F) {
+	f.Add(tcp4EthFrame, LinkTypeEthernet)
+	f.Add(tcp6SllFrame, LinkTypeLinuxSLL)
+	f.Add(bsdLoFrame(binary.BigEndian), LinkTypeNull)
+	f.Add(sll2Frame(), LinkTypeLinuxSLL2)
+	f.Add(tcp6EthFrame[ethHdrLen:], LinkTypeRaw)
+	f.Add(tagged(tcp6EthFrame, EtherTypeQinQ, 0x0064, EtherTypeDot1Q, 0x00c8), LinkTypeEthernet)
+	f.Add(withIp6Ext(tcp6SllFrame, sllHdrLen, hopByHop, firstFrag), LinkTypeLinuxSLL)
+	f.Add(withMpls(tcp4EthFrame, 16, 17), LinkTypeEthernet)
+	for _, m := range malformedFrames {
+		f.Add(m.frame, LinkTypeEthernet)
+	}
+	f.Fuzz(func(t *testing.T, frame []byte, linkType int32) {
+		b, done := guarded(t, frame)
+		defer done()
+		var p TcpPacket
+		if decodeTcpPacket(b, linkType, &p) == nil {
+			var sum byte
+			for _, c := range p.Payload {
+				sum += c
+			}
+			_ = sum
+		}
+	})
+}
+
+func FuzzDecodeTransport(f *testing.F) {
+	f.Add(tcp4EthFrame, LinkTypeEthernet)
+	f.Add(tcp6SllFrame, LinkTypeLinuxSLL)
+	f.Add(udpFrame(f, udpIp, "query"), LinkTypeEthernet)
+	f.Add(tagged(udpFrame(f, udpIp6, "query"), EtherTypeQinQ, 0x0064, EtherTypeDot1Q, 0x00c8), LinkTypeEthernet)
+	f.Add(withMpls(udpFrame(f, udpIp, "query"), 16), LinkTypeEthernet)
+	for _, m := range malformedFrames {
+		f.Add(m.frame, LinkTypeEthernet)
+	}
+	f.Fuzz(func(t *testing.T, frame []byte, linkType int32) {
+		b, done := guarded(t, frame)
+		defer done()
+		var tcp TcpPacket
+		var udp UdpPacket
+		proto, err := decodeTransport(b, linkType, &tcp, &udp)
+		if err == nil {
+			payload := tcp.Payload
+			if proto == IpProtoUDP {
+				payload = udp.Payload
+			}
+			var sum byte
+			for _, c := range payload {
+				sum += c
+			}
+			_ = sum
+		}
+	})
+}
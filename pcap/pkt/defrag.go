@@ -0,0 +1,366 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Defragmenter errors
+var (
+	ErrFragOverlap   = errors.New("Overlapping fragments, datagram discarded")
+	ErrFragTooBig    = errors.New("Reassembled datagram too big")
+	ErrFragTruncated = errors.New("Fragment not fully captured")
+	ErrFragLength    = errors.New("Fragment length not a multiple of 8")
+	ErrFragEvicted   = errors.New("Datagram evicted to stay within MaxBytes")
+)
+
+// Defaults for the limits of a Defragmenter, the same as Linux uses.
+const (
+	DefaultDefragMaxBytes = 4 << 20
+	DefaultDefragTimeout  = 30 * time.Second
+)
+
+// A Defragmenter reassembles fragmented IPv4 and IPv6 datagrams.  Fragments
+// belong to the same datagram when they have the same source, destination,
+// identification and protocol.  Any overlap between the fragments of a
+// datagram discards the whole datagram, including fragments that are still to
+// come (RFC 5722), although an exact duplicate of a fragment is just dropped.
+// The zero value is ready to use with the default limits.
+type Defragmenter struct {
+	MaxBytes int           // fragment bytes to hold; the oldest datagrams go first
+	Timeout  time.Duration // time, by packet time stamps, to wait for a datagram
+	Stats    DefragStats
+	pending  map[fragKey]*fragDatagram
+	bytes    int
+	expired  time.Time // when Add last called Expire
+}
+
+// DefragStats counts what happened to the datagrams a Defragmenter saw.
+type DefragStats struct {
+	Reassembled uint64 // datagrams put back together
+	Overlapped  uint64 // datagrams discarded for overlapping fragments
+	Expired     uint64 // datagrams not completed within the Timeout
+	Evicted     uint64 // datagrams dropped to stay within MaxBytes
+}
+
+// A fragKey identifies the datagram a fragment belongs to.
+type fragKey struct {
+	src, dst [16]byte
+	id       uint32
+	proto    uint8
+	v6       bool
+}
+
+// A fragDatagram is a datagram being reassembled.
+type fragDatagram struct {
+	start    time.Time  // time of the first fragment received
+	hdr      []byte     // link and IP headers of the first fragment
+	linkType int32      // link-layer header type of hdr
+	ipOff    int        // offset of the IP header in hdr
+	nextOff  int        // offset in hdr of the next header field to patch (IPv6)
+	total    int        // payload length, once the last fragment is in
+	frags    []fragment // by offset
+	bytes    int        // bytes held for the datagram
+	dropped  bool       // discarded after an overlap, waiting to expire
+}
+
+// A fragment is the payload of one fragment and where it goes.
+type fragment struct {
+	off  int
+	data []byte
+}
+
+// NewDefragmenter returns a Defragmenter with the default limits.
+func NewDefragmenter() *Defragmenter {
+	return &Defragmenter{
+		MaxBytes: DefaultDefragMaxBytes,
+		Timeout:  DefaultDefragTimeout,
+		pending:  make(map[fragKey]*fragDatagram),
+	}
+}
+
+// Add hands a decoded packet to the Defragmenter, which copies what it needs,
+// so Add has to be called before the next packet is read from the capture.  A
+// packet that is not a fragment is returned as is.  A fragment that completes
+// its datagram returns the reassembled packet, time stamped like the last
+// fragment, and decoded from the link layer on.  Any other fragment is kept
+// and Add returns nil, or an error if the fragment was dropped.  Add expires
+// the datagrams that timed out every Timeout/2, so a datagram can be kept for
+// up to half a Timeout longer.
+func (d *Defragmenter) Add(p *Packet) (*Packet, error) {
+	if d.pending == nil {
+		d.pending = make(map[fragKey]*fragDatagram)
+	}
+	if !p.Time.Before(d.expired.Add(d.timeout() / 2)) {
+		d.expired = p.Time
+		d.Expire(p.Time)
+	}
+	b := p.data
+	switch h := p.Headers[NetworkLayer].(type) {
+	case *IpHdr:
+		if !h.MoreFragments && h.FragOffset == 0 {
+			return p, nil
+		}
+		return d.addIp(p, b, len(b)-len(h.raw), h)
+	case *Ip6Hdr:
+		if h.Fragment() == nil {
+			return p, nil
+		}
+		return d.addIp6(p, b, len(b)-len(h.raw), h)
+	}
+	return p, nil
+}
+
+// Pending returns the number of datagrams waiting for more fragments.
+func (d *Defragmenter) Pending() int {
+	n := 0
+	for _, dg := range d.pending {
+		if !dg.dropped {
+			n++
+		}
+	}
+	return n
+}
+
+// Expire drops the datagrams that have been waiting for longer than the
+// Timeout at time now.  Add calls it as the packet times go by, so it only
+// needs to be called when the capture goes quiet.
+func (d *Defragmenter) Expire(now time.Time) {
+	timeout := d.timeout()
+	for k, dg := range d.pending {
+		if now.Sub(dg.start) > timeout {
+			if !dg.dropped {
+				d.Stats.Expired++
+			}
+			d.drop(k, dg)
+		}
+	}
+}
+
+// timeout returns the Timeout, or the default if it is not set.
+func (d *Defragmenter) timeout() time.Duration {
+	if d.Timeout == 0 {
+		return DefaultDefragTimeout
+	}
+	return d.Timeout
+}
+
+// addIp adds the IPv4 fragment p, whose IP header starts at off in b.
+func (d *Defragmenter) addIp(p *Packet, b []byte, off int, h *IpHdr) (*Packet, error) {
+	ihl, end := off+int(h.Ihl)*4, off+int(h.TotLen)
+	if end > len(b) {
+		return nil, ErrFragTruncated
+	}
+	k := fragKey{id: uint32(h.Id), proto: h.Protocol}
+	copy(k.src[:], h.SrcAddr)
+	copy(k.dst[:], h.DstAddr)
+	first := &fragDatagram{hdr: b[:ihl], linkType: p.LinkType, ipOff: off}
+	return d.add(p.Time, k, first, int(h.FragOffset), b[ihl:end], h.MoreFragments)
+}
+
+// addIp6 adds the IPv6 fragment p, whose IP header starts at off in b.
+func (d *Defragmenter) addIp6(p *Packet, b []byte, off int, h *Ip6Hdr) (*Packet, error) {
+	end := off + IPV6_HEADER_LEN + int(binary.BigEndian.Uint16(b[off+ip6PlenOff:]))
+	if end > len(b) {
+		return nil, ErrFragTruncated
+	}
+	// Find the fragment header, and the next header field pointing at it.
+	// Everything in front of it goes with the first fragment.
+	nextOff, n := off+ip6NextHdrOff, off+IPV6_HEADER_LEN
+	for b[nextOff] != IpProtoFragment {
+		nextOff, n = n, n+ip6ExtLen(b[nextOff], b[n:])
+	}
+	f := h.Fragment()
+	k := fragKey{id: f.Id, proto: f.NextHeader, v6: true}
+	copy(k.src[:], h.SrcAddr)
+	copy(k.dst[:], h.DstAddr)
+	first := &fragDatagram{hdr: b[:n], linkType: p.LinkType, ipOff: off, nextOff: nextOff}
+	data := b[n+ip6ExtMinLen : end]
+	if f.Offset == 0 && !f.MoreFragments {
+		// An atomic fragment is a datagram of its own (RFC 6946).
+		first.total = len(data)
+		first.frags = []fragment{{0, data}}
+		d.Stats.Reassembled++
+		return first.reassemble(p.Time, k), nil
+	}
+	return d.add(p.Time, k, first, int(f.Offset), data, f.MoreFragments)
+}
+
+// add adds a fragment with the given offset and data to the datagram k.  The
+// headers in first are only used if the fragment is the first one.
+func (d *Defragmenter) add(t time.Time, k fragKey, first *fragDatagram, off int, data []byte, more bool) (*Packet, error) {
+	if more && len(data)%8 != 0 {
+		return nil, ErrFragLength
+	}
+	dg := d.pending[k]
+	if dg == nil {
+		dg = &fragDatagram{start: t, total: -1}
+		d.pending[k] = dg
+	}
+	if dg.dropped {
+		return nil, ErrFragOverlap
+	}
+	limit := first.maxPayload(k.v6)
+	if dg.hdr != nil {
+		limit = dg.maxPayload(k.v6)
+	}
+	if off+len(data) > limit {
+		d.drop(k, dg)
+		return nil, ErrFragTooBig
+	}
+
+	// Find where the fragment goes.
+	i := 0
+	for i < len(dg.frags) && dg.frags[i].off < off {
+		i++
+	}
+	if i < len(dg.frags) && dg.frags[i].off == off && bytes.Equal(dg.frags[i].data, data) {
+		return nil, nil // an exact duplicate
+	}
+	if dg.overlaps(i, off, len(data), more) {
+		d.Stats.Overlapped++
+		d.drop(k, dg)
+		d.pending[k] = &fragDatagram{start: dg.start, dropped: true}
+		return nil, ErrFragOverlap
+	}
+	// The first fragment may bring longer headers than the others did.
+	if off == 0 && len(dg.frags) > 0 {
+		last := dg.frags[len(dg.frags)-1]
+		if last.off+len(last.data) > first.maxPayload(k.v6) {
+			d.drop(k, dg)
+			return nil, ErrFragTooBig
+		}
+	}
+
+	n := len(data)
+	if off == 0 {
+		n += len(first.hdr)
+	}
+	maxBytes := d.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = DefaultDefragMaxBytes
+	}
+	for d.bytes+n > maxBytes && d.evictOldest(k) {
+	}
+	if d.bytes+n > maxBytes {
+		d.Stats.Evicted++
+		d.drop(k, dg)
+		return nil, ErrFragEvicted
+	}
+	dg.frags = append(dg.frags, fragment{})
+	copy(dg.frags[i+1:], dg.frags[i:])
+	dg.frags[i] = fragment{off, append([]byte(nil), data...)}
+	if off == 0 {
+		dg.hdr = append([]byte(nil), first.hdr...)
+		dg.linkType, dg.ipOff, dg.nextOff = first.linkType, first.ipOff, first.nextOff
+	}
+	if !more {
+		dg.total = off + len(data)
+	}
+	dg.bytes += n
+	d.bytes += n
+
+	if !dg.complete() {
+		return nil, nil
+	}
+	d.drop(k, dg)
+	d.Stats.Reassembled++
+	return dg.reassemble(t, k), nil
+}
+
+// maxPayload returns how long the fragmentable part of the datagram can get for
+// its length to still fit in 16 bits once it is reassembled behind the headers
+// in dg.  The IPv4 total length counts the IPv4 header, and the IPv6 payload
+// length counts the extension headers in front of the fragment header.
+func (dg *fragDatagram) maxPayload(v6 bool) int {
+	n := len(dg.hdr) - dg.ipOff
+	if v6 {
+		n -= IPV6_HEADER_LEN
+	}
+	return 0xFFFF - n
+}
+
+// overlaps reports whether a fragment going in at index i would overlap the
+// fragments already there, or disagree with them on where the datagram ends.
+func (dg *fragDatagram) overlaps(i, off, n int, more bool) bool {
+	if i > 0 && dg.frags[i-1].off+len(dg.frags[i-1].data) > off {
+		return true
+	}
+	if i < len(dg.frags) && off+n > dg.frags[i].off {
+		return true
+	}
+	if dg.total >= 0 && (off+n > dg.total || !more) {
+		return true
+	}
+	return !more && i < len(dg.frags)
+}
+
+// drop forgets about the datagram k.
+func (d *Defragmenter) drop(k fragKey, dg *fragDatagram) {
+	d.bytes -= dg.bytes
+	delete(d.pending, k)
+}
+
+// evictOldest drops the datagram that started the longest ago, other than
+// keep, and reports whether there was one.
+func (d *Defragmenter) evictOldest(keep fragKey) bool {
+	var oldest *fragDatagram
+	var ok fragKey
+	for k, dg := range d.pending {
+		if k != keep && dg.bytes > 0 && (oldest == nil || dg.start.Before(oldest.start)) {
+			oldest, ok = dg, k
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	d.Stats.Evicted++
+	d.drop(ok, oldest)
+	return true
+}
+
+// complete reports whether all of the datagram is in.
+func (dg *fragDatagram) complete() bool {
+	if dg.hdr == nil || dg.total < 0 {
+		return false
+	}
+	end := 0
+	for _, f := range dg.frags {
+		if f.off != end {
+			return false
+		}
+		end += len(f.data)
+	}
+	return end == dg.total
+}
+
+// reassemble returns the packet the complete datagram k makes, with the IP
+// header fixed up to match.
+func (dg *fragDatagram) reassemble(t time.Time, k fragKey) *Packet {
+	frame := make([]byte, len(dg.hdr), len(dg.hdr)+dg.total)
+	copy(frame, dg.hdr)
+	for _, f := range dg.frags {
+		frame = append(frame, f.data...)
+	}
+	ip := frame[dg.ipOff:]
+	if k.v6 {
+		// The fragment header is left out of the chain.
+		frame[dg.nextOff] = k.proto
+		binary.BigEndian.PutUint16(ip[ip6PlenOff:], uint16(len(ip)-IPV6_HEADER_LEN))
+	} else {
+		binary.BigEndian.PutUint16(ip[ipTotLenOff:], uint16(len(ip)))
+		ip[ipFragOffOff] &= 0x40 // keep DF, clear MF and the offset
+		ip[ipFragOffOff+1] = 0
+		binary.BigEndian.PutUint16(ip[ipCheckOff:], 0)
+		binary.BigEndian.PutUint16(ip[ipCheckOff:], ^onesSum(frame[dg.ipOff:len(dg.hdr)]))
+	}
+	p := DecodePacket(frame, dg.linkType)
+	p.Time = t
+	return p
+}
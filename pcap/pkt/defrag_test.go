@@ -0,0 +1,223 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !appengine
+
+package pkt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// udpDatagram is a UDP datagram from port 53 to 5353 with a 24 byte payload,
+// to be cut into fragments.
+var udpDatagram = append([]byte{0x00, 0x35, 0x14, 0xe9, 0x00, 0x20, 0x00, 0x00},
+	[]byte("0123456789abcdefghijklmn")...)
+
+// frag4 returns an Ethernet frame with an IPv4 fragment of udpDatagram.
+func frag4(id uint16, off int, more bool, data []byte) []byte {
+	b := append([]byte{}, tcp4EthFrame[:ethHdrLen+ipHdrLen]...)
+	ip := b[ethHdrLen:]
+	binary.BigEndian.PutUint16(ip[ipTotLenOff:], uint16(ipHdrLen+len(data)))
+	binary.BigEndian.PutUint16(ip[ipIdOff:], id)
+	fragOff := uint16(off / 8)
+	if more {
+		fragOff |= 0x2000
+	}
+	binary.BigEndian.PutUint16(ip[ipFragOffOff:], fragOff)
+	ip[ipProtocolOff] = IpProtoUDP
+	return append(b, data...)
+}
+
+// frag6 returns an Ethernet frame with an IPv6 fragment of udpDatagram behind
+// a hop-by-hop options header.
+func frag6(id uint32, off int, more bool, data []byte) []byte {
+	fh := []byte{0, 0, 0, 0, 0, 0, 0, 0}
+	offlg := uint16(off)
+	if more {
+		offlg |= 1
+	}
+	binary.BigEndian.PutUint16(fh[2:], offlg)
+	binary.BigEndian.PutUint32(fh[4:], id)
+	b := append([]byte{}, tcp6EthFrame[:ethHdrLen+IPV6_HEADER_LEN]...)
+	binary.BigEndian.PutUint16(b[ethHdrLen+ip6PlenOff:], uint16(len(data)))
+	b[ethHdrLen+ip6NextHdrOff] = IpProtoUDP
+	b = append(b, data...)
+	return withIp6Ext(b, ethHdrLen, hopByHop, ip6Ext{IpProtoFragment, fh})
+}
+
+// framePacket decodes an Ethernet frame time stamped sec seconds into the
+// epoch.
+func framePacket(sec int64, frame []byte) *Packet {
+	p := DecodePacket(frame, LinkTypeEthernet)
+	p.Time = time.Unix(sec, 0)
+	return p
+}
+
+// defrag feeds the frames to d one second apart and returns what the last
+// one gave back.
+func defrag(t *testing.T, d *Defragmenter, frames ...[]byte) (*Packet, error) {
+	var p *Packet
+	var err error
+	for i, f := range frames {
+		p, err = d.Add(framePacket(int64(i), f))
+		if i < len(frames)-1 && (p != nil || err != nil) {
+			t.Fatalf("frame %d: d.Add = %v, %v, want nil, nil", i, p, err)
+		}
+	}
+	return p, err
+}
+
+// checkUdp checks that p holds all of udpDatagram.
+func checkUdp(t *testing.T, name string, p *Packet) {
+	if p == nil || p.Err() != nil {
+		t.Fatalf("%s: reassembled %v", name, p)
+	}
+	ip := p.Headers[NetworkLayer].(InetProtoHdr)
+	udp, ok := p.Headers[TransportLayer].(*UdpHdr)
+	if !ok || ip.Proto() != IpProtoUDP || udp.Source != 53 || udp.Dest != 5353 {
+		t.Fatalf("%s: reassembled %v", name, p)
+	}
+	if b := udp.GetPayloadBytes(ip.PL()); !bytes.Equal(b, udpDatagram[udpHdrLen:]) {
+		t.Errorf("%s: payload %q, want %q", name, b, udpDatagram[udpHdrLen:])
+	}
+}
+
+func TestDefragIPv4(t *testing.T) {
+	var d Defragmenter
+	p, err := defrag(t, &d,
+		frag4(1, 16, false, udpDatagram[16:]),
+		frag4(1, 0, true, udpDatagram[:8]),
+		frag4(1, 0, true, udpDatagram[:8]), // a duplicate
+		frag4(1, 8, true, udpDatagram[8:16]))
+	if err != nil {
+		t.Fatalf("d.Add: %v", err)
+	}
+	checkUdp(t, "ipv4", p)
+	ip := p.Headers[NetworkLayer].(*IpHdr)
+	if ip.MoreFragments || ip.FragOffset != 0 || int(ip.TotLen) != ipHdrLen+len(udpDatagram) {
+		t.Errorf("ip = %+v", ip)
+	}
+	if sum := onesSum(p.data[ethHdrLen : ethHdrLen+ipHdrLen]); sum != 0xFFFF {
+		t.Errorf("header checksum sums to %#x, want 0xffff", sum)
+	}
+	if d.Stats.Reassembled != 1 || d.Pending() != 0 || d.bytes != 0 {
+		t.Errorf("d.Stats = %+v, %d pending with %d bytes", d.Stats, d.Pending(), d.bytes)
+	}
+
+	// Packets that are not fragments go straight through.
+	q := framePacket(9, tcp4EthFrame)
+	if p, err := d.Add(q); p != q || err != nil {
+		t.Errorf("d.Add(tcp4EthFrame) = %v, %v", p, err)
+	}
+}
+
+func TestDefragIPv6(t *testing.T) {
+	var d Defragmenter
+	p, err := defrag(t, &d,
+		frag6(7, 8, false, udpDatagram[8:]),
+		frag6(7, 0, true, udpDatagram[:8]))
+	if err != nil {
+		t.Fatalf("d.Add: %v", err)
+	}
+	checkUdp(t, "ipv6", p)
+	ip6 := p.Headers[NetworkLayer].(*Ip6Hdr)
+	if ip6.Fragment() != nil || len(ip6.Extensions()) != 1 || ip6.PL() != uint16(len(udpDatagram)) {
+		t.Errorf("ip6 = %+v, extensions %v", ip6, ip6.Extensions())
+	}
+
+	// An atomic fragment needs no partners.
+	p, err = d.Add(framePacket(5, frag6(8, 0, false, udpDatagram)))
+	if err != nil {
+		t.Fatalf("d.Add: %v", err)
+	}
+	checkUdp(t, "atomic", p)
+}
+
+func TestDefragOverlap(t *testing.T) {
+	var d Defragmenter
+	_, err := defrag(t, &d,
+		frag4(2, 0, true, udpDatagram[:16]),
+		frag4(2, 8, true, udpDatagram[8:16]))
+	if err != ErrFragOverlap {
+		t.Errorf("d.Add = %v, want ErrFragOverlap", err)
+	}
+	// The rest of the datagram is thrown away too.
+	if _, err := d.Add(framePacket(2, frag4(2, 16, false, udpDatagram[16:]))); err != ErrFragOverlap {
+		t.Errorf("d.Add = %v, want ErrFragOverlap", err)
+	}
+	if d.Stats.Overlapped != 1 || d.Pending() != 0 || d.bytes != 0 {
+		t.Errorf("d.Stats = %+v, %d pending with %d bytes", d.Stats, d.Pending(), d.bytes)
+	}
+}
+
+func TestDefragLimits(t *testing.T) {
+	d := NewDefragmenter()
+	defrag(t, d, frag4(3, 0, true, udpDatagram[:8]))
+	d.Add(framePacket(31, tcp4EthFrame))
+	if d.Stats.Expired != 1 || d.Pending() != 0 {
+		t.Errorf("d.Stats = %+v, %d pending", d.Stats, d.Pending())
+	}
+
+	d = &Defragmenter{MaxBytes: 60}
+	defrag(t, d, frag4(4, 0, true, udpDatagram[:8]), frag4(5, 0, true, udpDatagram[:8]))
+	if d.Stats.Evicted != 1 || d.Pending() != 1 || d.bytes > d.MaxBytes {
+		t.Errorf("d.Stats = %+v, %d pending with %d bytes", d.Stats, d.Pending(), d.bytes)
+	}
+
+	// A datagram that can't fit even on its own is dropped, and says so.
+	if _, err := d.Add(framePacket(2, frag4(7, 0, true, udpDatagram))); err != ErrFragEvicted {
+		t.Errorf("d.Add = %v, want ErrFragEvicted", err)
+	}
+	if d.Stats.Evicted != 3 || d.Pending() != 0 || d.bytes != 0 {
+		t.Errorf("d.Stats = %+v, %d pending", d.Stats, d.Pending())
+	}
+
+	if _, err := d.Add(framePacket(3, frag4(6, 0, true, udpDatagram[:6]))); err != ErrFragLength {
+		t.Errorf("d.Add = %v, want ErrFragLength", err)
+	}
+	if _, err := d.Add(framePacket(3, frag4(6, 0, true, udpDatagram[:8])[:ethHdrLen+ipHdrLen+4])); err != ErrFragTruncated {
+		t.Errorf("d.Add = %v, want ErrFragTruncated", err)
+	}
+}
+
+// Make sure Add only looks for timed out datagrams every half Timeout.
+func TestDefragExpireRate(t *testing.T) {
+	d := &Defragmenter{Timeout: 10 * time.Second}
+	defrag(t, d, frag4(8, 0, true, udpDatagram[:8]))
+	d.Add(framePacket(4, tcp4EthFrame))
+	if !d.expired.Equal(time.Unix(0, 0)) {
+		t.Errorf("d.expired = %v, want the time of the first packet", d.expired)
+	}
+	d.Add(framePacket(11, tcp4EthFrame))
+	if d.Stats.Expired != 1 || d.Pending() != 0 || !d.expired.Equal(time.Unix(11, 0)) {
+		t.Errorf("d.Stats = %+v, %d pending, expired at %v", d.Stats, d.Pending(), d.expired)
+	}
+}
+
+// Make sure a datagram whose length would not fit in the IPv4 total length or
+// the IPv6 payload length, headers included, is turned down.
+func TestDefragTooBig(t *testing.T) {
+	data := make([]byte, 8)
+	for name, c := range map[string]struct {
+		frag func(n int) []byte
+		max  int
+	}{
+		// 65512 + 3 bytes of data behind a 20 byte header make 65535.
+		"ipv4": {func(n int) []byte { return frag4(9, 65512, false, data[:n]) }, 3},
+		// 65520 + 7 bytes of data behind 8 bytes of hop-by-hop options.
+		"ipv6": {func(n int) []byte { return frag6(9, 65520, false, data[:n]) }, 7},
+	} {
+		var d Defragmenter
+		if _, err := d.Add(framePacket(0, c.frag(c.max))); err != nil {
+			t.Errorf("%s: d.Add = %v, want the last fragment kept", name, err)
+		}
+		if _, err := d.Add(framePacket(0, c.frag(c.max+1))); err != ErrFragTooBig {
+			t.Errorf("%s: d.Add = %v, want ErrFragTooBig", name, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The Dot1QHdr struct is an IEEE 802.1Q VLAN tag.  The EtherType of the
+// header in front of it (0x8100, or 0x88a8 for the outer tag of an 802.1ad
+// QinQ frame) says that it is there.
+type Dot1QHdr struct {
+	Priority  uint8  // priority code point (PCP)
+	DEI       bool   // drop eligible indicator
+	VLANID    uint16 // VLAN identifier (VID)
+	EtherType uint16 // type of the encapsulated payload, or of the next tag
+}
+
+// NewDot1QHdr returns the 802.1Q tag at the start of b along with the bytes
+// that follow it.
+func NewDot1QHdr(b []byte) (*Dot1QHdr, []byte, error) {
+	if len(b) < dot1QHdrLen {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "vlan_tag", Value: dot1QHdrLen, Caplen: len(b)}
+	}
+	tci := binary.BigEndian.Uint16(b)
+	h := &Dot1QHdr{
+		Priority:  uint8(tci >> 13),
+		DEI:       tci&0x1000 != 0,
+		VLANID:    tci & 0x0FFF,
+		EtherType: binary.BigEndian.Uint16(b[2:]),
+	}
+	return h, b[dot1QHdrLen:], nil
+}
+
+// isVlanTag reports whether an EtherType announces an 802.1Q tag.
+func isVlanTag(etherType uint16) bool {
+	return etherType == EtherTypeDot1Q || etherType == EtherTypeQinQ
+}
+
+// JsonElement returns a JSON encoding of the Dot1QHdr struct.  A Packet
+// lists all of its tags under "vlan_tags" instead, see Packet.JsonString.
+func (h *Dot1QHdr) JsonElement() string {
+	return "\"vlan_tag\":" + h.jsonValue()
+}
+
+// jsonValue returns the JSON object of the Dot1QHdr, without its key.
+func (h *Dot1QHdr) jsonValue() string {
+	return fmt.Sprintf("{\"pcp\":%d,\"dei\":%t,\"vid\":%d,\"ether_type\":%d}",
+		h.Priority,
+		h.DEI,
+		h.VLANID,
+		h.EtherType)
+}
+
+// CsvElement returns a CSV encoding of the Dot1QHdr struct.
+// The string "VLAN" signifies the beginning of the Dot1QHdr.
+func (h *Dot1QHdr) CsvElement() string {
+	return fmt.Sprintf("\"VLAN\",%d,%t,%d,%d",
+		h.Priority,
+		h.DEI,
+		h.VLANID,
+		h.EtherType)
+}
+
+// String returns a minimal encoding of the Dot1QHdr struct.
+func (h *Dot1QHdr) String() string {
+	return fmt.Sprintf("vlan %d p%d %#x",
+		h.VLANID,
+		h.Priority,
+		h.EtherType)
+}
@@ -5,9 +5,35 @@
 package pkt
 
 import (
+	"encoding/binary"
 	"fmt"
+	"net"
 )
 
+// The EthHdr struct is the ether_header struct in <net/ethernet.h>.
+type EthHdr struct {
+	SrcAddr   net.HardwareAddr // the sender's MAC address
+	DstAddr   net.HardwareAddr // the receiver's MAC address
+	EtherType uint16           // packet type ID field
+	addrs     [2 * macLen]byte // backs DstAddr and SrcAddr, as on the wire
+}
+
+// NewEthHdr returns the Ethernet header at the start of b along with the bytes
+// that follow it.  The addresses are copied into the EthHdr itself, so
+// decoding them takes no allocation of its own.
+func NewEthHdr(b []byte) (*EthHdr, []byte, error) {
+	if len(b) < ethHdrLen {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "ether_header", Value: ethHdrLen, Caplen: len(b)}
+	}
+	ethHdr := &EthHdr{
+		EtherType: binary.BigEndian.Uint16(b[ethTypeOff:]),
+	}
+	copy(ethHdr.addrs[:], b)
+	ethHdr.DstAddr = net.HardwareAddr(ethHdr.addrs[:macLen:macLen])
+	ethHdr.SrcAddr = net.HardwareAddr(ethHdr.addrs[macLen:])
+	return ethHdr, b[ethHdrLen:], nil
+}
+
 // JsonElement returns a JSON encoding of the EthHdr struct.
 func (h *EthHdr) JsonElement() string {
 	return fmt.Sprintf("\"ether_header\":{\"ether_shost\":\"%s\",\"ether_dhost\":\"%s\",\"ether_type\":%d}",
@@ -0,0 +1,44 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+)
+
+// A FlowKey identifies the flow a TcpPacket or UdpPacket belongs to.  It is
+// comparable, so it can be used as a map key, and making one takes no
+// allocation.
+type FlowKey struct {
+	Src   netip.AddrPort
+	Dst   netip.AddrPort
+	Proto uint8 // IP protocol
+}
+
+// Reverse returns the FlowKey of the packets going the other way.
+func (k FlowKey) Reverse() FlowKey {
+	return FlowKey{Src: k.Dst, Dst: k.Src, Proto: k.Proto}
+}
+
+// String returns a minimal encoding of the FlowKey.
+func (k FlowKey) String() string {
+	return fmt.Sprintf("%s->%s proto %d", k.Src, k.Dst, k.Proto)
+}
+
+// wordsAddr returns the address held by the words of a TcpPacket or UdpPacket,
+// which keep the bytes in network order.
+func wordsAddr(ipv4 bool, w0, w1, w2, w3 uint32) netip.Addr {
+	var b [16]byte
+	binary.NativeEndian.PutUint32(b[0:], w0)
+	if ipv4 {
+		return netip.AddrFrom4([4]byte{b[0], b[1], b[2], b[3]})
+	}
+	binary.NativeEndian.PutUint32(b[4:], w1)
+	binary.NativeEndian.PutUint32(b[8:], w2)
+	binary.NativeEndian.PutUint32(b[12:], w3)
+	return netip.AddrFrom16(b)
+}
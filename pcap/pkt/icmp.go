@@ -0,0 +1,228 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// ICMP message types: compare these with the IcmpHdr.Type field.
+const (
+	IcmpEchoReply       = uint8(0)
+	IcmpDestUnreachable = uint8(3)
+	IcmpRedirect        = uint8(5)
+	IcmpEchoRequest     = uint8(8)
+	IcmpTimeExceeded    = uint8(11)
+)
+
+// ICMP destination unreachable codes: compare these with the IcmpHdr.Code
+// field when IcmpHdr.Type is IcmpDestUnreachable.
+const (
+	IcmpNetUnreachable  = uint8(0)
+	IcmpHostUnreachable = uint8(1)
+	IcmpProtUnreachable = uint8(2)
+	IcmpPortUnreachable = uint8(3)
+	IcmpFragNeeded      = uint8(4) // IcmpHdr.MTU holds the next-hop MTU
+)
+
+// The IcmpHdr struct is the icmphdr struct from <netinet/ip_icmp.h>.  The
+// second word is decoded according to the message type, and for error messages
+// the datagram that caused the error is decoded from the message body.
+type IcmpHdr struct {
+	Type     uint8      // message type
+	Code     uint8      // type sub-code
+	Checksum uint16     // checksum
+	Id       uint16     // echo identifier
+	Sequence uint16     // echo sequence number
+	MTU      uint16     // next-hop MTU of an IcmpFragNeeded message
+	Gateway  net.IP     // gateway address of an IcmpRedirect message
+	Quote    *IcmpQuote // the datagram an error message is about, if any
+	body     []byte
+}
+
+// An IcmpQuote is the start of the datagram that an ICMP error message is
+// about: its IP header and the first bytes of its payload, which is enough
+// for the transport ports and the TCP sequence number.
+type IcmpQuote struct {
+	SrcAddr  net.IP // source address
+	DstAddr  net.IP // dest address
+	Protocol uint8  // protocol
+	Id       uint16 // IP identification (IPv4 only)
+	TotLen   uint16 // total length of the datagram (bytes)
+	Source   uint16 // TCP or UDP source port, if quoted
+	Dest     uint16 // TCP or UDP destination port, if quoted
+	Seq      uint32 // TCP sequence number, if quoted
+}
+
+// NewIcmpHdr returns the ICMP message at the start of b along with its body.
+// An error message whose body does not hold a usable IP header is still
+// returned, just without a Quote.
+func NewIcmpHdr(b []byte) (*IcmpHdr, []byte, error) {
+	if len(b) < icmpHdrLen {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "icmphdr", Value: icmpHdrLen, Caplen: len(b)}
+	}
+	h := &IcmpHdr{
+		Type:     b[0],
+		Code:     b[1],
+		Checksum: binary.BigEndian.Uint16(b[2:]),
+		body:     b[icmpHdrLen:],
+	}
+	switch h.Type {
+	case IcmpEchoRequest, IcmpEchoReply:
+		h.Id = binary.BigEndian.Uint16(b[4:])
+		h.Sequence = binary.BigEndian.Uint16(b[6:])
+	case IcmpDestUnreachable:
+		if h.Code == IcmpFragNeeded {
+			h.MTU = binary.BigEndian.Uint16(b[6:])
+		}
+	case IcmpRedirect:
+		h.Gateway = net.IP(append([]byte(nil), b[4:8]...))
+	}
+	if h.IsError() {
+		h.Quote = newIcmpQuote(h.body)
+	}
+	return h, h.body, nil
+}
+
+// newIcmpQuote decodes the IPv4 or IPv6 datagram quoted in the body of an
+// ICMP or ICMPv6 error message, or returns nil if there is not enough of it.
+func newIcmpQuote(b []byte) *IcmpQuote {
+	if len(b) > 0 && b[0]>>4 == 6 {
+		return newIcmp6Quote(b)
+	}
+	if len(b) < ipHdrLen || b[0]>>4 != 4 {
+		return nil
+	}
+	ihl := int(b[0]&0x0F) * 4
+	if ihl < ipHdrLen || ihl > len(b) {
+		return nil
+	}
+	q := &IcmpQuote{
+		SrcAddr:  net.IP(append([]byte(nil), b[ipSrcOff:ipSrcOff+4]...)),
+		DstAddr:  net.IP(append([]byte(nil), b[ipDstOff:ipDstOff+4]...)),
+		Protocol: b[ipProtocolOff],
+		Id:       binary.BigEndian.Uint16(b[4:]),
+		TotLen:   binary.BigEndian.Uint16(b[ipTotLenOff:]),
+	}
+	q.quoteTransport(b[ihl:])
+	return q
+}
+
+// newIcmp6Quote decodes the IPv6 datagram quoted in b.
+func newIcmp6Quote(b []byte) *IcmpQuote {
+	if len(b) < IPV6_HEADER_LEN {
+		return nil
+	}
+	q := &IcmpQuote{
+		SrcAddr:  net.IP(append([]byte(nil), b[ip6SrcOff:ip6SrcOff+16]...)),
+		DstAddr:  net.IP(append([]byte(nil), b[ip6DstOff:ip6DstOff+16]...)),
+		Protocol: b[ip6NextHdrOff],
+		TotLen:   binary.BigEndian.Uint16(b[ip6PlenOff:]) + IPV6_HEADER_LEN,
+	}
+	q.quoteTransport(b[IPV6_HEADER_LEN:])
+	return q
+}
+
+// quoteTransport fills in the transport fields of q from the quoted payload
+// b, as far as it goes.
+func (q *IcmpQuote) quoteTransport(b []byte) {
+	if q.Protocol != IpProtoTCP && q.Protocol != IpProtoUDP {
+		return
+	}
+	if len(b) >= 4 {
+		q.Source = binary.BigEndian.Uint16(b[tcpSourceOff:])
+		q.Dest = binary.BigEndian.Uint16(b[tcpDestOff:])
+	}
+	if q.Protocol == IpProtoTCP && len(b) >= tcpSeqOff+4 {
+		q.Seq = binary.BigEndian.Uint32(b[tcpSeqOff:])
+	}
+}
+
+// IsError reports whether the ICMP message reports an error with another
+// datagram, and so quotes it.
+func (h *IcmpHdr) IsError() bool {
+	switch h.Type {
+	case IcmpDestUnreachable, IcmpRedirect, IcmpTimeExceeded:
+		return true
+	}
+	return false
+}
+
+// GetPayloadBytes returns the body of the ICMP message: the echo data, or the
+// quoted datagram of an error message.
+func (h *IcmpHdr) GetPayloadBytes() []byte {
+	return h.body
+}
+
+// JsonElement returns a JSON encoding of the IcmpHdr struct.
+func (h *IcmpHdr) JsonElement() string {
+	s := fmt.Sprintf("\"icmphdr\":{\"type\":%d,\"code\":%d,\"checksum\":%d,\"id\":%d,\"sequence\":%d,\"mtu\":%d",
+		h.Type,
+		h.Code,
+		h.Checksum,
+		h.Id,
+		h.Sequence,
+		h.MTU)
+	if h.Gateway != nil {
+		s += fmt.Sprintf(",\"gateway\":\"%s\"", h.Gateway.String())
+	}
+	return s + h.Quote.jsonField() + "}"
+}
+
+// CsvElement returns a CSV encoding of the IcmpHdr struct.
+// The string "ICMP" signifies the beginning of the IcmpHdr.
+func (h *IcmpHdr) CsvElement() string {
+	return fmt.Sprintf("\"ICMP\",%d,%d,%d,%d,%d,%d",
+		h.Type,
+		h.Code,
+		h.Checksum,
+		h.Id,
+		h.Sequence,
+		h.MTU)
+}
+
+// String returns a minimal encoding of the IcmpHdr struct in the style of
+// tcpdump.
+func (h *IcmpHdr) String() string {
+	switch h.Type {
+	case IcmpEchoRequest:
+		return fmt.Sprintf("echo request id %d seq %d", h.Id, h.Sequence)
+	case IcmpEchoReply:
+		return fmt.Sprintf("echo reply id %d seq %d", h.Id, h.Sequence)
+	}
+	var s string
+	switch h.Type {
+	case IcmpDestUnreachable:
+		s = fmt.Sprintf("unreachable code %d", h.Code)
+		if h.Code == IcmpFragNeeded {
+			s = fmt.Sprintf("unreachable need to frag (mtu %d)", h.MTU)
+		}
+	case IcmpRedirect:
+		s = fmt.Sprintf("redirect to %s", h.Gateway.String())
+	case IcmpTimeExceeded:
+		s = fmt.Sprintf("time exceeded code %d", h.Code)
+	default:
+		return fmt.Sprintf("type %d code %d", h.Type, h.Code)
+	}
+	return s + h.Quote.stringSuffix()
+}
+
+// String returns a minimal encoding of the IcmpQuote struct.
+func (q *IcmpQuote) String() string {
+	if q.Protocol == IpProtoTCP || q.Protocol == IpProtoUDP {
+		return fmt.Sprintf("%s:%d->%s:%d %#x",
+			q.SrcAddr.String(),
+			q.Source,
+			q.DstAddr.String(),
+			q.Dest,
+			q.Protocol)
+	}
+	return fmt.Sprintf("%s->%s %#x",
+		q.SrcAddr.String(),
+		q.DstAddr.String(),
+		q.Protocol)
+}
@@ -0,0 +1,451 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ICMPv6 message types: compare these with the Icmp6Hdr.Type field.
+const (
+	Icmp6DestUnreachable = uint8(1)
+	Icmp6PacketTooBig    = uint8(2)
+	Icmp6TimeExceeded    = uint8(3)
+	Icmp6ParamProblem    = uint8(4)
+	Icmp6EchoRequest     = uint8(128)
+	Icmp6EchoReply       = uint8(129)
+	Icmp6RouterSolicit   = uint8(133)
+	Icmp6RouterAdvert    = uint8(134)
+	Icmp6NeighborSolicit = uint8(135)
+	Icmp6NeighborAdvert  = uint8(136)
+)
+
+// NDP option types: compare these with the NdpOption.Type field.
+const (
+	NdpOptSourceLinkAddr = uint8(1)
+	NdpOptTargetLinkAddr = uint8(2)
+	NdpOptPrefixInfo     = uint8(3)
+	NdpOptMTU            = uint8(5)
+)
+
+// The Icmp6Hdr struct is the icmp6_hdr struct from <netinet/icmp6.h>.  The
+// rest of the message is decoded into a Body according to its type; types we
+// do not know about have a nil Body.
+type Icmp6Hdr struct {
+	Type     uint8     // message type
+	Code     uint8     // type sub-code
+	Checksum uint16    // checksum
+	Body     Icmp6Body // the message after the checksum
+}
+
+// An Icmp6Body is the type specific part of an ICMPv6 message.
+type Icmp6Body interface {
+	JsonElement() string
+	String() string
+}
+
+// An Icmp6Echo is the body of an echo request or reply.
+type Icmp6Echo struct {
+	Id       uint16 // echo identifier
+	Sequence uint16 // echo sequence number
+}
+
+// An Icmp6Error is the body of a destination unreachable, time exceeded or
+// parameter problem message.
+type Icmp6Error struct {
+	Pointer uint32     // offset of the problem, for a parameter problem
+	Quote   *IcmpQuote // the datagram the error is about, if quoted
+}
+
+// An Icmp6TooBig is the body of a packet too big message.
+type Icmp6TooBig struct {
+	MTU   uint32     // MTU of the next-hop link
+	Quote *IcmpQuote // the datagram that was too big, if quoted
+}
+
+// An NdpRouterSolicit is the body of an NDP router solicitation.
+type NdpRouterSolicit struct {
+	Options []NdpOption
+}
+
+// An NdpRouterAdvert is the body of an NDP router advertisement.
+type NdpRouterAdvert struct {
+	HopLimit       uint8  // hop limit hosts should use, 0 if unspecified
+	Managed        bool   // addresses are available through DHCPv6 (M)
+	Other          bool   // other configuration is available through DHCPv6 (O)
+	RouterLifetime uint16 // seconds, 0 if this is not a default router
+	ReachableTime  uint32 // milliseconds, 0 if unspecified
+	RetransTimer   uint32 // milliseconds, 0 if unspecified
+	Options        []NdpOption
+}
+
+// An NdpNeighborSolicit is the body of an NDP neighbor solicitation.
+type NdpNeighborSolicit struct {
+	Target  net.IP // the address being resolved
+	Options []NdpOption
+}
+
+// An NdpNeighborAdvert is the body of an NDP neighbor advertisement.
+type NdpNeighborAdvert struct {
+	Router    bool   // the sender is a router (R)
+	Solicited bool   // sent in response to a solicitation (S)
+	Override  bool   // should override a cached link-layer address (O)
+	Target    net.IP // the address being advertised
+	Options   []NdpOption
+}
+
+// An NdpOption is an option of an NDP message.  LinkAddr is set for the
+// link-layer address options, Prefix for the prefix information option and
+// MTU for the MTU option.  Data holds the option after its type and length.
+type NdpOption struct {
+	Type     uint8
+	LinkAddr net.HardwareAddr
+	Prefix   *NdpPrefixInfo
+	MTU      uint32
+	Data     []byte
+}
+
+// An NdpPrefixInfo is the prefix information option of a router
+// advertisement.
+type NdpPrefixInfo struct {
+	Prefix            *net.IPNet // the prefix, masked by its length
+	OnLink            bool       // the prefix can be used for on-link determination (L)
+	Autonomous        bool       // the prefix can be used for SLAAC (A)
+	ValidLifetime     uint32     // seconds
+	PreferredLifetime uint32     // seconds
+}
+
+// NewIcmp6Hdr returns the ICMPv6 message at the start of b along with the bytes
+// that follow its fixed part.  Like NewIcmpHdr, an error message without a
+// usable quote is still returned.
+func NewIcmp6Hdr(b []byte) (*Icmp6Hdr, []byte, error) {
+	if len(b) < icmp6HdrLen {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "icmp6_hdr", Value: icmp6HdrLen, Caplen: len(b)}
+	}
+	h := &Icmp6Hdr{
+		Type:     b[0],
+		Code:     b[1],
+		Checksum: binary.BigEndian.Uint16(b[2:]),
+	}
+	n := icmp6HdrLen
+	switch h.Type {
+	case Icmp6EchoRequest, Icmp6EchoReply:
+		h.Body = &Icmp6Echo{
+			Id:       binary.BigEndian.Uint16(b[4:]),
+			Sequence: binary.BigEndian.Uint16(b[6:]),
+		}
+	case Icmp6DestUnreachable, Icmp6TimeExceeded, Icmp6ParamProblem:
+		h.Body = &Icmp6Error{
+			Pointer: binary.BigEndian.Uint32(b[4:]),
+			Quote:   newIcmpQuote(b[n:]),
+		}
+	case Icmp6PacketTooBig:
+		h.Body = &Icmp6TooBig{
+			MTU:   binary.BigEndian.Uint32(b[4:]),
+			Quote: newIcmpQuote(b[n:]),
+		}
+	case Icmp6RouterSolicit:
+		opts, err := newNdpOptions(b[n:])
+		if err != nil {
+			return nil, nil, err
+		}
+		h.Body = &NdpRouterSolicit{Options: opts}
+	case Icmp6RouterAdvert:
+		if n = icmp6HdrLen + 8; len(b) < n {
+			return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "nd_router_advert", Value: n, Caplen: len(b)}
+		}
+		opts, err := newNdpOptions(b[n:])
+		if err != nil {
+			return nil, nil, err
+		}
+		h.Body = &NdpRouterAdvert{
+			HopLimit:       b[4],
+			Managed:        b[5]&0x80 != 0,
+			Other:          b[5]&0x40 != 0,
+			RouterLifetime: binary.BigEndian.Uint16(b[6:]),
+			ReachableTime:  binary.BigEndian.Uint32(b[8:]),
+			RetransTimer:   binary.BigEndian.Uint32(b[12:]),
+			Options:        opts,
+		}
+	case Icmp6NeighborSolicit, Icmp6NeighborAdvert:
+		if n = icmp6HdrLen + 16; len(b) < n {
+			return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "nd_neighbor", Value: n, Caplen: len(b)}
+		}
+		opts, err := newNdpOptions(b[n:])
+		if err != nil {
+			return nil, nil, err
+		}
+		target := net.IP(append([]byte(nil), b[icmp6HdrLen:n]...))
+		if h.Type == Icmp6NeighborSolicit {
+			h.Body = &NdpNeighborSolicit{Target: target, Options: opts}
+			break
+		}
+		h.Body = &NdpNeighborAdvert{
+			Router:    b[4]&0x80 != 0,
+			Solicited: b[4]&0x40 != 0,
+			Override:  b[4]&0x20 != 0,
+			Target:    target,
+			Options:   opts,
+		}
+	}
+	return h, b[n:], nil
+}
+
+// newNdpOptions decodes the NDP options in b.  Options cut short by the
+// capture are left out, but an option with a zero length makes the whole
+// message invalid (RFC 4861 section 4.6).
+func newNdpOptions(b []byte) ([]NdpOption, error) {
+	var opts []NdpOption
+	for len(b) >= 2 {
+		n := int(b[1]) * 8
+		if n == 0 {
+			return nil, &DecodeError{Reason: DecodeBadHeaderLength, Hdr: "nd_opt_hdr", Field: "nd_opt_len", Value: 0, Caplen: len(b)}
+		}
+		if n > len(b) {
+			break
+		}
+		o := NdpOption{
+			Type: b[0],
+			Data: append([]byte(nil), b[2:n]...),
+		}
+		switch o.Type {
+		case NdpOptSourceLinkAddr, NdpOptTargetLinkAddr:
+			// Any padding past the address stays in, since the
+			// option does not say how long the address is.
+			o.LinkAddr = net.HardwareAddr(o.Data)
+		case NdpOptPrefixInfo:
+			if n == 32 {
+				o.Prefix = &NdpPrefixInfo{
+					Prefix: &net.IPNet{
+						IP:   net.IP(o.Data[14:30]).Mask(net.CIDRMask(int(o.Data[0]), 128)),
+						Mask: net.CIDRMask(int(o.Data[0]), 128),
+					},
+					OnLink:            o.Data[1]&0x80 != 0,
+					Autonomous:        o.Data[1]&0x40 != 0,
+					ValidLifetime:     binary.BigEndian.Uint32(o.Data[2:]),
+					PreferredLifetime: binary.BigEndian.Uint32(o.Data[6:]),
+				}
+			}
+		case NdpOptMTU:
+			if n == 8 {
+				o.MTU = binary.BigEndian.Uint32(o.Data[2:])
+			}
+		}
+		opts = append(opts, o)
+		b = b[n:]
+	}
+	return opts, nil
+}
+
+// LinkAddr returns the link-layer address carried in the options of an NDP
+// message, or nil.
+func (h *Icmp6Hdr) LinkAddr() net.HardwareAddr {
+	var opts []NdpOption
+	switch b := h.Body.(type) {
+	case *NdpRouterSolicit:
+		opts = b.Options
+	case *NdpRouterAdvert:
+		opts = b.Options
+	case *NdpNeighborSolicit:
+		opts = b.Options
+	case *NdpNeighborAdvert:
+		opts = b.Options
+	}
+	for i := range opts {
+		if opts[i].LinkAddr != nil {
+			return opts[i].LinkAddr
+		}
+	}
+	return nil
+}
+
+// Quote returns the datagram an ICMPv6 error message is about, or nil.
+func (h *Icmp6Hdr) Quote() *IcmpQuote {
+	switch b := h.Body.(type) {
+	case *Icmp6Error:
+		return b.Quote
+	case *Icmp6TooBig:
+		return b.Quote
+	}
+	return nil
+}
+
+// JsonElement returns a JSON encoding of the Icmp6Hdr struct.
+func (h *Icmp6Hdr) JsonElement() string {
+	s := fmt.Sprintf("\"icmp6_hdr\":{\"icmp6_type\":%d,\"icmp6_code\":%d,\"icmp6_cksum\":%d",
+		h.Type,
+		h.Code,
+		h.Checksum)
+	if h.Body != nil {
+		s += "," + h.Body.JsonElement()
+	}
+	return s + "}"
+}
+
+// CsvElement returns a CSV encoding of the Icmp6Hdr struct.
+// The string "ICMP6" signifies the beginning of the Icmp6Hdr.
+func (h *Icmp6Hdr) CsvElement() string {
+	return fmt.Sprintf("\"ICMP6\",%d,%d,%d",
+		h.Type,
+		h.Code,
+		h.Checksum)
+}
+
+// String returns a minimal encoding of the Icmp6Hdr struct.
+func (h *Icmp6Hdr) String() string {
+	if h.Body == nil {
+		return fmt.Sprintf("icmp6 type %d code %d", h.Type, h.Code)
+	}
+	return h.Body.String()
+}
+
+// JsonElement returns a JSON encoding of the Icmp6Echo struct.
+func (b *Icmp6Echo) JsonElement() string {
+	return fmt.Sprintf("\"echo\":{\"id\":%d,\"sequence\":%d}", b.Id, b.Sequence)
+}
+
+// String returns a minimal encoding of the Icmp6Echo struct.
+func (b *Icmp6Echo) String() string {
+	return fmt.Sprintf("echo id %d seq %d", b.Id, b.Sequence)
+}
+
+// JsonElement returns a JSON encoding of the Icmp6Error struct.
+func (b *Icmp6Error) JsonElement() string {
+	return fmt.Sprintf("\"error\":{\"pointer\":%d%s}", b.Pointer, b.Quote.jsonField())
+}
+
+// String returns a minimal encoding of the Icmp6Error struct.
+func (b *Icmp6Error) String() string {
+	return "error" + b.Quote.stringSuffix()
+}
+
+// JsonElement returns a JSON encoding of the Icmp6TooBig struct.
+func (b *Icmp6TooBig) JsonElement() string {
+	return fmt.Sprintf("\"packet_too_big\":{\"mtu\":%d%s}", b.MTU, b.Quote.jsonField())
+}
+
+// String returns a minimal encoding of the Icmp6TooBig struct.
+func (b *Icmp6TooBig) String() string {
+	return fmt.Sprintf("packet too big (mtu %d)%s", b.MTU, b.Quote.stringSuffix())
+}
+
+// JsonElement returns a JSON encoding of the NdpRouterSolicit struct.
+func (b *NdpRouterSolicit) JsonElement() string {
+	return fmt.Sprintf("\"router_solicit\":{%s}", ndpOptionsJson(b.Options))
+}
+
+// String returns a minimal encoding of the NdpRouterSolicit struct.
+func (b *NdpRouterSolicit) String() string {
+	return "router solicitation" + ndpOptionsString(b.Options)
+}
+
+// JsonElement returns a JSON encoding of the NdpRouterAdvert struct.
+func (b *NdpRouterAdvert) JsonElement() string {
+	return fmt.Sprintf("\"router_advert\":{\"hop_limit\":%d,\"managed\":%t,\"other\":%t,\"router_lifetime\":%d,\"reachable_time\":%d,\"retrans_timer\":%d,%s}",
+		b.HopLimit,
+		b.Managed,
+		b.Other,
+		b.RouterLifetime,
+		b.ReachableTime,
+		b.RetransTimer,
+		ndpOptionsJson(b.Options))
+}
+
+// String returns a minimal encoding of the NdpRouterAdvert struct.
+func (b *NdpRouterAdvert) String() string {
+	return fmt.Sprintf("router advertisement lifetime %ds%s", b.RouterLifetime, ndpOptionsString(b.Options))
+}
+
+// JsonElement returns a JSON encoding of the NdpNeighborSolicit struct.
+func (b *NdpNeighborSolicit) JsonElement() string {
+	return fmt.Sprintf("\"neighbor_solicit\":{\"target\":\"%s\",%s}", b.Target.String(), ndpOptionsJson(b.Options))
+}
+
+// String returns a minimal encoding of the NdpNeighborSolicit struct.
+func (b *NdpNeighborSolicit) String() string {
+	return fmt.Sprintf("neighbor solicitation who has %s%s", b.Target.String(), ndpOptionsString(b.Options))
+}
+
+// JsonElement returns a JSON encoding of the NdpNeighborAdvert struct.
+func (b *NdpNeighborAdvert) JsonElement() string {
+	return fmt.Sprintf("\"neighbor_advert\":{\"router\":%t,\"solicited\":%t,\"override\":%t,\"target\":\"%s\",%s}",
+		b.Router,
+		b.Solicited,
+		b.Override,
+		b.Target.String(),
+		ndpOptionsJson(b.Options))
+}
+
+// String returns a minimal encoding of the NdpNeighborAdvert struct.
+func (b *NdpNeighborAdvert) String() string {
+	return fmt.Sprintf("neighbor advertisement tgt is %s%s", b.Target.String(), ndpOptionsString(b.Options))
+}
+
+// ndpOptionsJson returns a JSON encoding of NDP options as an "options" field.
+func ndpOptionsJson(opts []NdpOption) string {
+	s := make([]string, len(opts))
+	for i, o := range opts {
+		switch {
+		case o.LinkAddr != nil:
+			s[i] = fmt.Sprintf("{\"type\":%d,\"lladdr\":\"%s\"}", o.Type, o.LinkAddr.String())
+		case o.Prefix != nil:
+			s[i] = fmt.Sprintf("{\"type\":%d,\"prefix\":\"%s\",\"on_link\":%t,\"autonomous\":%t,\"valid_lifetime\":%d,\"preferred_lifetime\":%d}",
+				o.Type,
+				o.Prefix.Prefix.String(),
+				o.Prefix.OnLink,
+				o.Prefix.Autonomous,
+				o.Prefix.ValidLifetime,
+				o.Prefix.PreferredLifetime)
+		case o.Type == NdpOptMTU:
+			s[i] = fmt.Sprintf("{\"type\":%d,\"mtu\":%d}", o.Type, o.MTU)
+		default:
+			s[i] = fmt.Sprintf("{\"type\":%d,\"len\":%d}", o.Type, len(o.Data)+2)
+		}
+	}
+	return fmt.Sprintf("\"options\":[%s]", strings.Join(s, ","))
+}
+
+// ndpOptionsString returns a minimal encoding of the NDP options we know
+// about, each one preceded by a space.
+func ndpOptionsString(opts []NdpOption) string {
+	var s string
+	for _, o := range opts {
+		switch {
+		case o.LinkAddr != nil:
+			s += " lladdr " + o.LinkAddr.String()
+		case o.Prefix != nil:
+			s += " prefix " + o.Prefix.Prefix.String()
+		case o.Type == NdpOptMTU:
+			s += fmt.Sprintf(" mtu %d", o.MTU)
+		}
+	}
+	return s
+}
+
+// jsonField returns a JSON encoding of q as a "quote" field preceded by a
+// comma, or nothing if q is nil.
+func (q *IcmpQuote) jsonField() string {
+	if q == nil {
+		return ""
+	}
+	return fmt.Sprintf(",\"quote\":{\"saddr\":\"%s\",\"daddr\":\"%s\",\"protocol\":%d,\"source\":%d,\"dest\":%d}",
+		q.SrcAddr.String(),
+		q.DstAddr.String(),
+		q.Protocol,
+		q.Source,
+		q.Dest)
+}
+
+// stringSuffix returns a minimal encoding of q for the end of a message, or
+// nothing if q is nil.
+func (q *IcmpQuote) stringSuffix() string {
+	if q == nil {
+		return ""
+	}
+	return " for " + q.String()
+}
@@ -5,25 +5,257 @@
 package pkt
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net"
+	"strings"
 )
 
+// These are the option types of the IP options we decode, including the
+// copied flag and the option class.
+const (
+	IpOptEOL         = uint8(0)   // end of option list
+	IpOptNOP         = uint8(1)   // no operation
+	IpOptRecordRoute = uint8(7)   // record route
+	IpOptTimestamp   = uint8(68)  // internet timestamp
+	IpOptLSRR        = uint8(131) // loose source and record route
+	IpOptSSRR        = uint8(137) // strict source and record route
+	IpOptRouterAlert = uint8(148) // router alert (RFC 2113)
+)
+
+// IpFields are the rest of the fields of the iphdr struct in <netinet/ip.h>.
+type IpFields struct {
+	Tos           uint8      // type of service: DSCP and ECN
+	Id            uint16     // identification
+	DontFragment  bool       // don't fragment flag (DF)
+	MoreFragments bool       // more fragments flag (MF)
+	FragOffset    uint16     // fragment offset (bytes)
+	Ttl           uint8      // time to live
+	Check         uint16     // header checksum
+	Options       []IpOption // options, if the header has any
+}
+
+// An IpOption is an option of an IPv4 header.  Route is set for the record
+// route and source route options, Timestamps for the timestamp option and
+// RouterAlert for the router alert option.  Data holds the option after its
+// type and length.
+type IpOption struct {
+	Type        uint8
+	Data        []byte
+	Route       []net.IP      // the addresses recorded so far
+	Timestamps  []IpTimestamp // the timestamps recorded so far
+	RouterAlert uint16        // the router alert value, 0 for "examine packet"
+}
+
+// An IpTimestamp is an entry of a timestamp option.  Addr is nil unless the
+// option records addresses.
+type IpTimestamp struct {
+	Addr net.IP
+	Time uint32 // milliseconds since midnight UT
+}
+
+// The IpHdr struct is the iphdr struct in <netinet/ip.h>.
+type IpHdr struct {
+	Ihl        uint8  // header length (32bit words)
+	Version    uint8  // version
+	SrcAddr    net.IP // source address
+	DstAddr    net.IP // dest address
+	Protocol   uint8  // protocol
+	TotLen     uint16 // total length (bytes)
+	PayloadLen uint16 // payload length (bytes)
+	IpFields          // the rest of the header
+	raw        []byte // the header and the rest of the capture
+}
+
+// NewIpHdr returns the IPv4 header at the start of b along with the bytes
+// that follow it.  The header length and the total length have to be
+// consistent with each other, but the total length may go past the end of
+// the capture (e.g. a short snaplen).
+func NewIpHdr(b []byte) (*IpHdr, []byte, error) {
+	if len(b) < ipHdrLen {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "iphdr", Value: ipHdrLen, Caplen: len(b)}
+	}
+	iphdr := &IpHdr{
+		Ihl:     b[0] & 0x0F,
+		Version: b[0] >> 4,
+	}
+	n := int(iphdr.Ihl) * 4
+	if iphdr.Ihl < 5 {
+		return nil, nil, &DecodeError{Reason: DecodeBadHeaderLength, Hdr: "iphdr", Field: "ihl", Value: int(iphdr.Ihl), Caplen: len(b)}
+	}
+	if n > len(b) {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "iphdr", Value: n, Caplen: len(b)}
+	}
+	iphdr.SrcAddr = net.IP(append([]byte(nil), b[ipSrcOff:ipSrcOff+4]...))
+	iphdr.DstAddr = net.IP(append([]byte(nil), b[ipDstOff:ipDstOff+4]...))
+	iphdr.Protocol = b[ipProtocolOff]
+	iphdr.TotLen = binary.BigEndian.Uint16(b[ipTotLenOff:])
+	if int(iphdr.TotLen) < n {
+		return nil, nil, &DecodeError{Reason: DecodeBadHeaderLength, Hdr: "iphdr", Field: "tot_len", Value: int(iphdr.TotLen), Caplen: len(b)}
+	}
+	iphdr.PayloadLen = iphdr.TotLen - uint16(n)
+	iphdr.IpFields = newIpFields(b[:n])
+	iphdr.raw = b
+	return iphdr, b[n:], nil
+}
+
+// newIpFields decodes the IpFields of the IPv4 header b, which has already
+// been checked to be as long as its IHL says.
+func newIpFields(b []byte) IpFields {
+	fragOff := binary.BigEndian.Uint16(b[ipFragOffOff:])
+	return IpFields{
+		Tos:           b[ipTosOff],
+		Id:            binary.BigEndian.Uint16(b[ipIdOff:]),
+		DontFragment:  fragOff&0x4000 != 0,
+		MoreFragments: fragOff&0x2000 != 0,
+		FragOffset:    (fragOff & 0x1FFF) * 8,
+		Ttl:           b[ipTtlOff],
+		Check:         binary.BigEndian.Uint16(b[ipCheckOff:]),
+		Options:       newIpOptions(b[ipHdrLen:]),
+	}
+}
+
+// newIpOptions decodes the options in b.  Decoding stops at the end of the
+// option list, or at an option whose length does not fit.
+func newIpOptions(b []byte) []IpOption {
+	var opts []IpOption
+	for len(b) > 0 && b[0] != IpOptEOL {
+		if b[0] == IpOptNOP {
+			b = b[1:]
+			continue
+		}
+		if len(b) < 2 || b[1] < 2 || int(b[1]) > len(b) {
+			break
+		}
+		n := int(b[1])
+		o := IpOption{
+			Type: b[0],
+			Data: append([]byte(nil), b[2:n]...),
+		}
+		switch o.Type {
+		case IpOptRecordRoute, IpOptLSRR, IpOptSSRR:
+			// The pointer is one past the recorded addresses,
+			// counting from the start of the option.
+			if len(o.Data) > 0 {
+				for i := 1; i+4 <= int(o.Data[0])-3 && i+4 <= len(o.Data); i += 4 {
+					o.Route = append(o.Route, net.IP(o.Data[i:i+4]))
+				}
+			}
+		case IpOptTimestamp:
+			if len(o.Data) > 1 {
+				size := 4
+				if o.Data[1]&0x0F != 0 {
+					size = 8 // address and timestamp
+				}
+				for i := 2; i+size <= int(o.Data[0])-3 && i+size <= len(o.Data); i += size {
+					var ts IpTimestamp
+					if size == 8 {
+						ts.Addr = net.IP(o.Data[i : i+4])
+					}
+					ts.Time = binary.BigEndian.Uint32(o.Data[i+size-4:])
+					o.Timestamps = append(o.Timestamps, ts)
+				}
+			}
+		case IpOptRouterAlert:
+			if len(o.Data) == 2 {
+				o.RouterAlert = binary.BigEndian.Uint16(o.Data)
+			}
+		}
+		opts = append(opts, o)
+		b = b[n:]
+	}
+	return opts
+}
+
+// DSCP returns the differentiated services code point of the Tos field.
+func (f *IpFields) DSCP() uint8 {
+	return f.Tos >> 2
+}
+
+// ECN returns the explicit congestion notification bits of the Tos field.
+func (f *IpFields) ECN() uint8 {
+	return f.Tos & 0x03
+}
+
+// String returns a minimal encoding of the IpOption struct.
+func (o *IpOption) String() string {
+	switch o.Type {
+	case IpOptRecordRoute, IpOptLSRR, IpOptSSRR:
+		s := make([]string, len(o.Route))
+		for i := range o.Route {
+			s[i] = o.Route[i].String()
+		}
+		name := "RR"
+		if o.Type != IpOptRecordRoute {
+			name = "SRR"
+		}
+		return fmt.Sprintf("%s{%s}", name, strings.Join(s, " "))
+	case IpOptTimestamp:
+		s := make([]string, len(o.Timestamps))
+		for i, ts := range o.Timestamps {
+			s[i] = fmt.Sprint(ts.Time)
+			if ts.Addr != nil {
+				s[i] = fmt.Sprintf("%s@%d", ts.Addr.String(), ts.Time)
+			}
+		}
+		return fmt.Sprintf("TS{%s}", strings.Join(s, " "))
+	case IpOptRouterAlert:
+		return fmt.Sprintf("RA{%d}", o.RouterAlert)
+	}
+	return fmt.Sprintf("opt-%d{%d}", o.Type, len(o.Data))
+}
+
+// optionsString returns the minimal encodings of the IP options separated by
+// spaces.
+func (f *IpFields) optionsString() string {
+	s := make([]string, len(f.Options))
+	for i := range f.Options {
+		s[i] = f.Options[i].String()
+	}
+	return strings.Join(s, " ")
+}
+
 // JsonElement returns a JSON encoding of the IpHdr struct.
 func (h *IpHdr) JsonElement() string {
-	return fmt.Sprintf("\"iphdr\":{\"saddr\":\"%s\",\"daddr\":\"%s\",\"protocol\":%d}",
+	opts := make([]string, len(h.Options))
+	for i := range h.Options {
+		opts[i] = fmt.Sprintf("{\"type\":%d,\"len\":%d,\"value\":\"%s\"}",
+			h.Options[i].Type,
+			len(h.Options[i].Data)+2,
+			h.Options[i].String())
+	}
+	return fmt.Sprintf("\"iphdr\":{\"saddr\":\"%s\",\"daddr\":\"%s\",\"protocol\":%d,\"tos\":%d,\"dscp\":%d,\"ecn\":%d,\"id\":%d,\"df\":%t,\"mf\":%t,\"frag_off\":%d,\"ttl\":%d,\"check\":%d,\"options\":[%s]}",
 		h.SrcAddr.String(),
 		h.DstAddr.String(),
-		h.Protocol)
+		h.Protocol,
+		h.Tos,
+		h.DSCP(),
+		h.ECN(),
+		h.Id,
+		h.DontFragment,
+		h.MoreFragments,
+		h.FragOffset,
+		h.Ttl,
+		h.Check,
+		strings.Join(opts, ","))
 }
 
 // CsvElement returns a CSV encoding of the IpHdr struct.
-// The string "IP4" signifies the beginning of the IpHdr.
+// The string "IP4" signifies the beginning of the IpHdr.  The options come
+// last, as a single field.
 func (h *IpHdr) CsvElement() string {
-	return fmt.Sprintf("\"IP4\",\"%s\",\"%s\",%d",
+	return fmt.Sprintf("\"IP4\",\"%s\",\"%s\",%d,%d,%d,%t,%t,%d,%d,%d,\"%s\"",
 		h.SrcAddr.String(),
 		h.DstAddr.String(),
-		h.Protocol)
+		h.Protocol,
+		h.Tos,
+		h.Id,
+		h.DontFragment,
+		h.MoreFragments,
+		h.FragOffset,
+		h.Ttl,
+		h.Check,
+		h.optionsString())
 }
 
 // String returns a minimal encoding of the IpHdr struct.
@@ -5,10 +5,60 @@
 package pkt
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net"
 )
 
+// The Ip6Hdr struct is the ip6_hdr struct in <netinet/ip6.h>.
+type Ip6Hdr struct {
+	SrcAddr    net.IP // the sender's ip6 address
+	DstAddr    net.IP // the receiver's ipv6 address
+	NextHeader uint8  // next header
+	HopLimit   uint8  // hop limit
+	Class      uint8  // traffic class: DSCP and ECN
+	FlowLabel  uint32 // flow label (20 bits)
+	Protocol   uint8  // upper-layer protocol, after any extension headers
+	PayloadLen uint16 // upper-layer payload length
+	ext        []Hdr
+	raw        []byte // the header and the rest of the capture
+}
+
+// NewIp6Hdr returns the IPv6 header at the start of b along with the bytes
+// that follow it.  Any extension headers are decoded too, and the bytes
+// returned start at the upper-layer header that follows them.
+func NewIp6Hdr(b []byte) (*Ip6Hdr, []byte, error) {
+	if len(b) < IPV6_HEADER_LEN {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "ip6hdr", Value: IPV6_HEADER_LEN, Caplen: len(b)}
+	}
+	flow := binary.BigEndian.Uint32(b)
+	ip6Hdr := &Ip6Hdr{
+		SrcAddr:    net.IP(append([]byte(nil), b[ip6SrcOff:ip6SrcOff+16]...)),
+		DstAddr:    net.IP(append([]byte(nil), b[ip6DstOff:ip6DstOff+16]...)),
+		NextHeader: b[ip6NextHdrOff],
+		HopLimit:   b[ip6HopLimitOff],
+		Class:      uint8(flow >> 20),
+		FlowLabel:  flow & 0xFFFFF,
+		PayloadLen: binary.BigEndian.Uint16(b[ip6PlenOff:]),
+		raw:        b,
+	}
+
+	ext, proto, extLen, err := newIp6ExtHdrs(ip6Hdr.NextHeader, b[IPV6_HEADER_LEN:])
+	if err != nil {
+		return nil, nil, err
+	}
+	// A payload length of 0 means a jumbogram, whose real length is in a
+	// hop-by-hop option we do not look at.
+	if ip6Hdr.PayloadLen != 0 && extLen > int(ip6Hdr.PayloadLen) {
+		return nil, nil, &DecodeError{Reason: DecodeBadHeaderLength, Hdr: "ip6hdr", Field: "plen", Value: int(ip6Hdr.PayloadLen), Caplen: len(b)}
+	}
+	if ip6Hdr.PayloadLen != 0 {
+		ip6Hdr.PayloadLen -= uint16(extLen)
+	}
+	ip6Hdr.Protocol, ip6Hdr.ext = proto, ext
+	return ip6Hdr, b[IPV6_HEADER_LEN+extLen:], nil
+}
+
 // JsonElement returns a JSON encoding of the Ip6Hdr struct.
 func (h *Ip6Hdr) JsonElement() string {
 	return fmt.Sprintf("\"ip6hdr\":{\"ip6_src\":\"%s\",\"ip6_dst\":\"%s\",\"next_header\":%d}",
@@ -44,9 +94,26 @@ func (h *Ip6Hdr) Dst() net.IP {
 	return h.DstAddr
 }
 
-// Proto returns the IP protocol number.
+// Proto returns the IP protocol number of the upper-layer header, which
+// follows any extension headers.
 func (h *Ip6Hdr) Proto() uint8 {
-	return h.NextHeader
+	return h.Protocol
+}
+
+// Extensions returns the extension headers between the Ip6Hdr and the
+// upper-layer header, in the order they appear on the wire.
+func (h *Ip6Hdr) Extensions() []Hdr {
+	return h.ext
+}
+
+// Fragment returns the fragment header of a fragmented packet, or nil.
+func (h *Ip6Hdr) Fragment() *Ip6FragHdr {
+	for _, e := range h.ext {
+		if f, ok := e.(*Ip6FragHdr); ok {
+			return f
+		}
+	}
+	return nil
 }
 
 // PL returns the Payload length.
@@ -0,0 +1,258 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// The Ip6OptsHdr struct is a hop-by-hop options or a destination options
+// header, the ip6_hbh and ip6_dest structs in <netinet/ip6.h>.  Which one it
+// is is kept in Type.
+type Ip6OptsHdr struct {
+	Type       uint8  // IpProtoHopOpts or IpProtoDstOpts
+	NextHeader uint8  // next header
+	Options    []byte // the options, still TLV encoded
+}
+
+// The Ip6RoutingHdr struct is the ip6_rthdr struct in <netinet/ip6.h>.
+type Ip6RoutingHdr struct {
+	NextHeader   uint8  // next header
+	RoutingType  uint8  // routing type
+	SegmentsLeft uint8  // segments left
+	Data         []byte // type specific data
+}
+
+// The Ip6FragHdr struct is the ip6_frag struct in <netinet/ip6.h>.
+type Ip6FragHdr struct {
+	NextHeader    uint8  // next header
+	Offset        uint16 // offset of the fragment in the original payload (bytes)
+	MoreFragments bool   // more fragments follow (M)
+	Id            uint32 // identification
+}
+
+// isIp6Ext reports whether an IPv6 next header value announces one of the
+// extension headers we walk through.
+func isIp6Ext(t uint8) bool {
+	switch t {
+	case IpProtoHopOpts, IpProtoRouting, IpProtoFragment, IpProtoDstOpts:
+		return true
+	}
+	return false
+}
+
+// ip6ExtLen returns the length of the extension header of type t at the
+// start of b, which has to hold at least ip6ExtMinLen bytes.
+func ip6ExtLen(t uint8, b []byte) int {
+	if t == IpProtoFragment {
+		return ip6ExtMinLen
+	}
+	return (int(b[1]) + 1) * 8
+}
+
+// ip6ExtName returns the name we report the extension header of type t under
+// in a DecodeError.
+func ip6ExtName(t uint8) string {
+	switch t {
+	case IpProtoHopOpts:
+		return "ip6_hbh"
+	case IpProtoRouting:
+		return "ip6_rthdr"
+	case IpProtoFragment:
+		return "ip6_frag"
+	}
+	return "ip6_dest"
+}
+
+// ip6ExtBytes returns the extension header of type t at the start of b.
+func ip6ExtBytes(t uint8, b []byte) ([]byte, error) {
+	if len(b) < ip6ExtMinLen {
+		return nil, &DecodeError{Reason: DecodeTruncated, Hdr: ip6ExtName(t), Value: ip6ExtMinLen, Caplen: len(b)}
+	}
+	n := ip6ExtLen(t, b)
+	if len(b) < n {
+		return nil, &DecodeError{Reason: DecodeTruncated, Hdr: ip6ExtName(t), Value: n, Caplen: len(b)}
+	}
+	return b[:n], nil
+}
+
+// NewIp6OptsHdr returns the options header of type t (IpProtoHopOpts or
+// IpProtoDstOpts) at the start of b along with the bytes that follow it.
+func NewIp6OptsHdr(t uint8, b []byte) (*Ip6OptsHdr, []byte, error) {
+	e, err := ip6ExtBytes(t, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	h := &Ip6OptsHdr{
+		Type:       t,
+		NextHeader: e[0],
+		Options:    append([]byte(nil), e[2:]...),
+	}
+	return h, b[len(e):], nil
+}
+
+// NewIp6RoutingHdr returns the routing header at the start of b along with
+// the bytes that follow it.
+func NewIp6RoutingHdr(b []byte) (*Ip6RoutingHdr, []byte, error) {
+	e, err := ip6ExtBytes(IpProtoRouting, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	h := &Ip6RoutingHdr{
+		NextHeader:   e[0],
+		RoutingType:  e[2],
+		SegmentsLeft: e[3],
+		Data:         append([]byte(nil), e[4:]...),
+	}
+	return h, b[len(e):], nil
+}
+
+// finalDst returns the address the packet is bound for once the routing
+// header has been followed, or nil if the routing type is not one we know the
+// data of.  Types 0 and 2 list the addresses after 4 reserved bytes, the last
+// one being the final destination.  The segment routing header (type 4) lists
+// its segments in reverse, after 4 bytes of last entry, flags and tag.
+func (h *Ip6RoutingHdr) finalDst() net.IP {
+	if len(h.Data) < 4+net.IPv6len {
+		return nil
+	}
+	switch h.RoutingType {
+	case 0, 2:
+		return net.IP(h.Data[len(h.Data)-net.IPv6len:])
+	case 4:
+		return net.IP(h.Data[4 : 4+net.IPv6len])
+	}
+	return nil
+}
+
+// NewIp6FragHdr returns the fragment header at the start of b along with the
+// bytes that follow it.
+func NewIp6FragHdr(b []byte) (*Ip6FragHdr, []byte, error) {
+	e, err := ip6ExtBytes(IpProtoFragment, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	offlg := binary.BigEndian.Uint16(e[2:])
+	h := &Ip6FragHdr{
+		NextHeader:    e[0],
+		Offset:        offlg &^ 0x7,
+		MoreFragments: offlg&0x1 != 0,
+		Id:            binary.BigEndian.Uint32(e[4:]),
+	}
+	return h, b[len(e):], nil
+}
+
+// newIp6ExtHdrs decodes the chain of extension headers at the start of b,
+// the first of which is announced by next.  It returns the headers, the
+// protocol of the header that follows them and the length of the chain.
+func newIp6ExtHdrs(next uint8, b []byte) ([]Hdr, uint8, int, error) {
+	var hdrs []Hdr
+	n := 0
+	for isIp6Ext(next) {
+		var h Hdr
+		var rest []byte
+		var err error
+		switch next {
+		case IpProtoRouting:
+			var r *Ip6RoutingHdr
+			if r, rest, err = NewIp6RoutingHdr(b[n:]); err == nil {
+				h, next = r, r.NextHeader
+			}
+		case IpProtoFragment:
+			var f *Ip6FragHdr
+			if f, rest, err = NewIp6FragHdr(b[n:]); err == nil {
+				h, next = f, f.NextHeader
+			}
+		default:
+			var o *Ip6OptsHdr
+			if o, rest, err = NewIp6OptsHdr(next, b[n:]); err == nil {
+				h, next = o, o.NextHeader
+			}
+		}
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		hdrs = append(hdrs, h)
+		n = len(b) - len(rest)
+	}
+	return hdrs, next, n, nil
+}
+
+// JsonElement returns a JSON encoding of the Ip6OptsHdr struct.
+func (h *Ip6OptsHdr) JsonElement() string {
+	return fmt.Sprintf("\"%s\":{\"next_header\":%d,\"len\":%d}",
+		ip6ExtName(h.Type),
+		h.NextHeader,
+		len(h.Options)+2)
+}
+
+// CsvElement returns a CSV encoding of the Ip6OptsHdr struct.
+// The string "IP6OPTS" signifies the beginning of the Ip6OptsHdr.
+func (h *Ip6OptsHdr) CsvElement() string {
+	return fmt.Sprintf("\"IP6OPTS\",%d,%d,%d",
+		h.Type,
+		h.NextHeader,
+		len(h.Options)+2)
+}
+
+// String returns a minimal encoding of the Ip6OptsHdr struct.
+func (h *Ip6OptsHdr) String() string {
+	return fmt.Sprintf("%s %#x", ip6ExtName(h.Type), h.NextHeader)
+}
+
+// JsonElement returns a JSON encoding of the Ip6RoutingHdr struct.
+func (h *Ip6RoutingHdr) JsonElement() string {
+	return fmt.Sprintf("\"ip6_rthdr\":{\"next_header\":%d,\"type\":%d,\"segleft\":%d}",
+		h.NextHeader,
+		h.RoutingType,
+		h.SegmentsLeft)
+}
+
+// CsvElement returns a CSV encoding of the Ip6RoutingHdr struct.
+// The string "IP6RT" signifies the beginning of the Ip6RoutingHdr.
+func (h *Ip6RoutingHdr) CsvElement() string {
+	return fmt.Sprintf("\"IP6RT\",%d,%d,%d",
+		h.NextHeader,
+		h.RoutingType,
+		h.SegmentsLeft)
+}
+
+// String returns a minimal encoding of the Ip6RoutingHdr struct.
+func (h *Ip6RoutingHdr) String() string {
+	return fmt.Sprintf("ip6_rthdr type %d segleft %d %#x",
+		h.RoutingType,
+		h.SegmentsLeft,
+		h.NextHeader)
+}
+
+// JsonElement returns a JSON encoding of the Ip6FragHdr struct.
+func (h *Ip6FragHdr) JsonElement() string {
+	return fmt.Sprintf("\"ip6_frag\":{\"next_header\":%d,\"offset\":%d,\"more\":%t,\"id\":%d}",
+		h.NextHeader,
+		h.Offset,
+		h.MoreFragments,
+		h.Id)
+}
+
+// CsvElement returns a CSV encoding of the Ip6FragHdr struct.
+// The string "IP6FRAG" signifies the beginning of the Ip6FragHdr.
+func (h *Ip6FragHdr) CsvElement() string {
+	return fmt.Sprintf("\"IP6FRAG\",%d,%d,%t,%d",
+		h.NextHeader,
+		h.Offset,
+		h.MoreFragments,
+		h.Id)
+}
+
+// String returns a minimal encoding of the Ip6FragHdr struct.
+func (h *Ip6FragHdr) String() string {
+	return fmt.Sprintf("frag id %d offset %d more %t %#x",
+		h.Id,
+		h.Offset,
+		h.MoreFragments,
+		h.NextHeader)
+}
@@ -0,0 +1,400 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// A Layer names a header that a LayerDecoder fills in.
+type Layer uint8
+
+// These are the layers a LayerDecoder decodes, in the order they can appear.
+const (
+	LayerEthernet Layer = iota // LayerDecoder.Eth
+	LayerVlan                  // LayerDecoder.Vlans, once for each tag kept
+	LayerIPv4                  // LayerDecoder.Ip
+	LayerIPv6                  // LayerDecoder.Ip6
+	LayerTCP                   // LayerDecoder.Tcp
+	LayerUDP                   // LayerDecoder.Udp
+	LayerICMP                  // LayerDecoder.Icmp
+	NumLayers                  // number of Layer values
+)
+
+var layerNames = [NumLayers]string{
+	"ethernet",
+	"vlan",
+	"ipv4",
+	"ipv6",
+	"tcp",
+	"udp",
+	"icmp",
+}
+
+// String returns the name of the Layer.
+func (l Layer) String() string {
+	if l < NumLayers {
+		return layerNames[l]
+	}
+	return fmt.Sprintf("Layer(%d)", l)
+}
+
+// maxLayers is the most layers one packet can have: Ethernet, two VLAN tags,
+// IP and a transport header.
+const maxLayers = 5
+
+// A LayerDecoder decodes packets of any protocol into headers it owns, so
+// that once it is made, decoding takes no allocations.  Each packet fills in
+// the headers of the layers it has, which Layers lists; the other headers are
+// left as the previous packets had them.  Like TcpPacket, the headers and the
+// Payload are only good until the next packet is decoded, and the Payload
+// is mapped into the capture buffer.
+//
+// Only the fixed part of each header is decoded.  IP options, IPv6 extension
+// headers, TCP options and the datagram quoted by an ICMP error are skipped
+// over and left out of the headers; TcpOptions decodes the TCP options when
+// they are needed.
+type LayerDecoder struct {
+	Eth       EthHdr
+	Vlans     [2]Dot1QHdr // the outermost and innermost 802.1Q tags
+	Ip        IpHdr
+	Ip6       Ip6Hdr
+	Tcp       TcpHdr
+	Udp       UdpHdr
+	Icmp      IcmpHdr
+	Payload   []byte    // what follows the last decoded header
+	Timestamp time.Time // set by NewPacketLayers
+
+	layers     [maxLayers]Layer
+	n          int                   // the number of layers decoded
+	ipAddrs    [2 * net.IPv4len]byte // backs Ip.SrcAddr and Ip.DstAddr
+	ip6Addrs   [2 * net.IPv6len]byte // backs Ip6.SrcAddr and Ip6.DstAddr
+	gateway    [net.IPv4len]byte     // backs Icmp.Gateway
+	tcpOptions []byte
+	err        DecodeError // why the last decode failed
+}
+
+// Layers returns the layers of the last packet decoded, outermost first.  The
+// slice is only good until the next packet is decoded.
+func (d *LayerDecoder) Layers() []Layer {
+	return d.layers[:d.n]
+}
+
+// Has reports whether the last packet decoded has the layer l.
+func (d *LayerDecoder) Has(l Layer) bool {
+	for _, x := range d.layers[:d.n] {
+		if x == l {
+			return true
+		}
+	}
+	return false
+}
+
+// TcpOptions decodes the options of the TCP header of the last packet, or
+// returns nil if it has none.
+func (d *LayerDecoder) TcpOptions() *TcpOptions {
+	if !d.Has(LayerTCP) || len(d.tcpOptions) == 0 {
+		return nil
+	}
+	return NewTcpOptions(d.tcpOptions)
+}
+
+// add records that the layer l was decoded.
+func (d *LayerDecoder) add(l Layer) {
+	d.layers[d.n] = l
+	d.n++
+}
+
+// fail records e in the LayerDecoder and returns it as an error.
+func (d *LayerDecoder) fail(e DecodeError) error {
+	d.err = e
+	return &d.err
+}
+
+// Decode decodes the captured bytes b of a packet of the link-layer header
+// type linkType.  Decoding stops without an error at the first EtherType or
+// IP protocol the LayerDecoder has no header for, or at an IP fragment that
+// is not the first, with the Payload holding the bytes from there on.  An
+// MPLS label stack is skipped over.  Headers that do not fit in b, or whose
+// lengths do not add up, stop decoding with a *DecodeError, which is kept in
+// d so that failures do not allocate either; the layers decoded before it
+// are still reported by Layers.
+func (d *LayerDecoder) Decode(b []byte, linkType int32) error {
+	var etherType uint16
+
+	d.n = 0
+	d.Payload = nil
+	d.tcpOptions = nil
+
+	switch linkType {
+	case LinkTypeEthernet:
+		if len(b) < ethHdrLen {
+			return d.fail(DecodeError{Reason: DecodeTruncated, Hdr: "ether_header", Value: ethHdrLen, Caplen: len(b)})
+		}
+		etherType = binary.BigEndian.Uint16(b[ethTypeOff:])
+		copy(d.Eth.addrs[:], b)
+		d.Eth.DstAddr = net.HardwareAddr(d.Eth.addrs[:macLen:macLen])
+		d.Eth.SrcAddr = net.HardwareAddr(d.Eth.addrs[macLen:])
+		d.Eth.EtherType = etherType
+		d.add(LayerEthernet)
+		b = b[ethHdrLen:]
+	case LinkTypeLinuxSLL:
+		if len(b) < sllHdrLen {
+			return d.fail(DecodeError{Reason: DecodeTruncated, Hdr: "sll_header", Value: sllHdrLen, Caplen: len(b)})
+		}
+		etherType = binary.BigEndian.Uint16(b[sllProtocolOff:])
+		b = b[sllHdrLen:]
+	case LinkTypeLinuxSLL2:
+		if len(b) < sll2HdrLen {
+			return d.fail(DecodeError{Reason: DecodeTruncated, Hdr: "sll2_header", Value: sll2HdrLen, Caplen: len(b)})
+		}
+		etherType = binary.BigEndian.Uint16(b)
+		b = b[sll2HdrLen:]
+	case LinkTypeNull, LinkTypeLoop:
+		if len(b) < bsdLoHdrLen {
+			return d.fail(DecodeError{Reason: DecodeTruncated, Hdr: "bsd_loopback", Value: bsdLoHdrLen, Caplen: len(b)})
+		}
+		etherType = loEtherType(bsdLoFamily(b))
+		b = b[bsdLoHdrLen:]
+	case LinkTypeRaw, LinkTypeIPv4, LinkTypeIPv6:
+		if etherType = rawEtherType(linkType, b); etherType == 0 {
+			return d.fail(rawError(b))
+		}
+	default:
+		return d.fail(DecodeError{Reason: DecodeUnsupportedLink, Value: int(linkType)})
+	}
+
+	// keep the outermost and innermost 802.1Q tags
+	for tags := 0; isVlanTag(etherType); tags++ {
+		if len(b) < dot1QHdrLen {
+			return d.fail(DecodeError{Reason: DecodeTruncated, Hdr: "vlan_tag", Value: dot1QHdrLen, Caplen: len(b)})
+		}
+		tci := binary.BigEndian.Uint16(b)
+		etherType = binary.BigEndian.Uint16(b[2:])
+		i := 0
+		if tags > 0 {
+			i = 1
+		}
+		d.Vlans[i] = Dot1QHdr{
+			Priority:  uint8(tci >> 13),
+			DEI:       tci&0x1000 != 0,
+			VLANID:    tci & 0x0FFF,
+			EtherType: etherType,
+		}
+		if tags < 2 {
+			d.add(LayerVlan)
+		}
+		b = b[dot1QHdrLen:]
+	}
+
+	// skip an MPLS label stack, guessing the IP version of the payload
+	if isMpls(etherType) {
+		n := mplsStackLen(b)
+		if n < 0 {
+			return d.fail(DecodeError{Reason: DecodeTruncated, Hdr: "mpls", Value: len(b)/mplsLabelLen*mplsLabelLen + mplsLabelLen, Caplen: len(b)})
+		}
+		b = b[n:]
+		etherType = rawEtherType(LinkTypeRaw, b)
+	}
+
+	var proto uint8
+	var paylen int
+	switch etherType {
+	case EtherTypeIPv4:
+		if len(b) < ipHdrLen {
+			return d.fail(DecodeError{Reason: DecodeTruncated, Hdr: "iphdr", Value: ipHdrLen, Caplen: len(b)})
+		}
+		if b[0]>>4 != 4 {
+			return d.fail(DecodeError{Reason: DecodeBadVersion, Hdr: "iphdr", Value: int(b[0] >> 4)})
+		}
+		n := int(b[0]&0x0F) * 4
+		if n < ipHdrLen {
+			return d.fail(DecodeError{Reason: DecodeBadHeaderLength, Hdr: "iphdr", Field: "ihl", Value: n / 4, Caplen: len(b)})
+		}
+		if n > len(b) {
+			return d.fail(DecodeError{Reason: DecodeTruncated, Hdr: "iphdr", Value: n, Caplen: len(b)})
+		}
+		totlen := binary.BigEndian.Uint16(b[ipTotLenOff:])
+		if int(totlen) < n {
+			return d.fail(DecodeError{Reason: DecodeBadHeaderLength, Hdr: "iphdr", Field: "tot_len", Value: int(totlen), Caplen: len(b)})
+		}
+		fragOff := binary.BigEndian.Uint16(b[ipFragOffOff:])
+		copy(d.ipAddrs[:], b[ipSrcOff:ipDstOff+net.IPv4len])
+		d.Ip = IpHdr{
+			Ihl:        b[0] & 0x0F,
+			Version:    4,
+			SrcAddr:    net.IP(d.ipAddrs[:net.IPv4len:net.IPv4len]),
+			DstAddr:    net.IP(d.ipAddrs[net.IPv4len:]),
+			Protocol:   b[ipProtocolOff],
+			TotLen:     totlen,
+			PayloadLen: totlen - uint16(n),
+			IpFields: IpFields{
+				Tos:           b[ipTosOff],
+				Id:            binary.BigEndian.Uint16(b[ipIdOff:]),
+				DontFragment:  fragOff&0x4000 != 0,
+				MoreFragments: fragOff&0x2000 != 0,
+				FragOffset:    (fragOff & 0x1FFF) * 8,
+				Ttl:           b[ipTtlOff],
+				Check:         binary.BigEndian.Uint16(b[ipCheckOff:]),
+			},
+			raw: b,
+		}
+		d.add(LayerIPv4)
+		proto, paylen = d.Ip.Protocol, int(d.Ip.PayloadLen)
+		b = b[n:]
+		if d.Ip.FragOffset != 0 {
+			d.Payload = capped(b, paylen)
+			return nil
+		}
+	case EtherTypeIPv6:
+		if len(b) < IPV6_HEADER_LEN {
+			return d.fail(DecodeError{Reason: DecodeTruncated, Hdr: "ip6hdr", Value: IPV6_HEADER_LEN, Caplen: len(b)})
+		}
+		if b[0]>>4 != 6 {
+			return d.fail(DecodeError{Reason: DecodeBadVersion, Hdr: "ip6hdr", Value: int(b[0] >> 4)})
+		}
+		flow := binary.BigEndian.Uint32(b)
+		copy(d.ip6Addrs[:], b[ip6SrcOff:ip6DstOff+net.IPv6len])
+		d.Ip6 = Ip6Hdr{
+			SrcAddr:    net.IP(d.ip6Addrs[:net.IPv6len:net.IPv6len]),
+			DstAddr:    net.IP(d.ip6Addrs[net.IPv6len:]),
+			NextHeader: b[ip6NextHdrOff],
+			HopLimit:   b[ip6HopLimitOff],
+			Class:      uint8(flow >> 20),
+			FlowLabel:  flow & 0xFFFFF,
+			PayloadLen: binary.BigEndian.Uint16(b[ip6PlenOff:]),
+			raw:        b,
+		}
+		d.add(LayerIPv6)
+		proto, paylen = d.Ip6.NextHeader, int(d.Ip6.PayloadLen)
+		b = b[IPV6_HEADER_LEN:]
+		// A payload length of 0 means a jumbogram, whose real length
+		// is in a hop-by-hop option we do not look at.
+		jumbo := paylen == 0
+		if jumbo {
+			paylen = len(b)
+		}
+
+		// walk the extension headers to the upper-layer header
+		var fragment bool
+		for isIp6Ext(proto) {
+			if len(b) < ip6ExtMinLen {
+				return d.fail(DecodeError{Reason: DecodeTruncated, Hdr: ip6ExtName(proto), Value: ip6ExtMinLen, Caplen: len(b)})
+			}
+			n := ip6ExtLen(proto, b)
+			if n > len(b) {
+				return d.fail(DecodeError{Reason: DecodeTruncated, Hdr: ip6ExtName(proto), Value: n, Caplen: len(b)})
+			}
+			if n > paylen {
+				return d.fail(DecodeError{Reason: DecodeBadHeaderLength, Hdr: "ip6hdr", Field: "plen", Value: int(d.Ip6.PayloadLen), Caplen: len(b)})
+			}
+			if proto == IpProtoFragment && binary.BigEndian.Uint16(b[2:])&^0x7 != 0 {
+				fragment = true
+			}
+			proto = b[0]
+			paylen -= n
+			b = b[n:]
+		}
+		d.Ip6.Protocol = proto
+		if !jumbo {
+			d.Ip6.PayloadLen = uint16(paylen)
+		}
+		if fragment {
+			d.Payload = capped(b, paylen)
+			return nil
+		}
+	default:
+		d.Payload = b
+		return nil
+	}
+
+	switch {
+	case proto == IpProtoTCP:
+		if len(b) < tcpHdrLen {
+			return d.fail(DecodeError{Reason: DecodeTruncated, Hdr: "tcphdr", Value: tcpHdrLen, Caplen: len(b)})
+		}
+		flags := binary.BigEndian.Uint16(b[tcpFlagsOff:])
+		n := int(flags>>12) * 4
+		if n < tcpHdrLen || n > paylen {
+			return d.fail(DecodeError{Reason: DecodeBadHeaderLength, Hdr: "tcphdr", Field: "doff", Value: n / 4, Caplen: len(b)})
+		}
+		if n > len(b) {
+			return d.fail(DecodeError{Reason: DecodeTruncated, Hdr: "tcphdr", Value: n, Caplen: len(b)})
+		}
+		d.Tcp = TcpHdr{
+			Source: binary.BigEndian.Uint16(b[tcpSourceOff:]),
+			Dest:   binary.BigEndian.Uint16(b[tcpDestOff:]),
+			Seq:    binary.BigEndian.Uint32(b[tcpSeqOff:]),
+			AckSeq: binary.BigEndian.Uint32(b[tcpAckSeqOff:]),
+			Doff:   uint8(n / 4),
+			Flags:  flags & uint16(0x01FF),
+			Window: binary.BigEndian.Uint16(b[tcpWindowOff:]),
+			Check:  binary.BigEndian.Uint16(b[tcpCheckOff:]),
+			UrgPtr: binary.BigEndian.Uint16(b[tcpUrgPtrOff:]),
+			raw:    b,
+		}
+		d.add(LayerTCP)
+		d.tcpOptions = b[tcpHdrLen:n:n]
+		d.Payload = capped(b[n:], paylen-n)
+	case proto == IpProtoUDP:
+		if len(b) < udpHdrLen {
+			return d.fail(DecodeError{Reason: DecodeTruncated, Hdr: "udphdr", Value: udpHdrLen, Caplen: len(b)})
+		}
+		d.Udp = UdpHdr{
+			Source: binary.BigEndian.Uint16(b),
+			Dest:   binary.BigEndian.Uint16(b[2:]),
+			Len:    binary.BigEndian.Uint16(b[4:]),
+			Check:  binary.BigEndian.Uint16(b[6:]),
+			raw:    b,
+		}
+		if d.Udp.Len < udpHdrLen {
+			return d.fail(DecodeError{Reason: DecodeBadHeaderLength, Hdr: "udphdr", Field: "len", Value: int(d.Udp.Len), Caplen: len(b)})
+		}
+		d.add(LayerUDP)
+		d.Payload = capped(b[udpHdrLen:], paylen-udpHdrLen)
+	case proto == IpProtoICMP && d.Has(LayerIPv4):
+		if len(b) < icmpHdrLen {
+			return d.fail(DecodeError{Reason: DecodeTruncated, Hdr: "icmphdr", Value: icmpHdrLen, Caplen: len(b)})
+		}
+		d.Icmp = IcmpHdr{
+			Type:     b[0],
+			Code:     b[1],
+			Checksum: binary.BigEndian.Uint16(b[2:]),
+		}
+		switch d.Icmp.Type {
+		case IcmpEchoRequest, IcmpEchoReply:
+			d.Icmp.Id = binary.BigEndian.Uint16(b[4:])
+			d.Icmp.Sequence = binary.BigEndian.Uint16(b[6:])
+		case IcmpDestUnreachable:
+			if d.Icmp.Code == IcmpFragNeeded {
+				d.Icmp.MTU = binary.BigEndian.Uint16(b[6:])
+			}
+		case IcmpRedirect:
+			copy(d.gateway[:], b[4:8])
+			d.Icmp.Gateway = net.IP(d.gateway[:])
+		}
+		d.add(LayerICMP)
+		d.Payload = capped(b[icmpHdrLen:], paylen-icmpHdrLen)
+		d.Icmp.body = d.Payload
+	default:
+		d.Payload = capped(b, paylen)
+	}
+	return nil
+}
+
+// capped returns b cut to at most n bytes, so that the Ethernet padding of
+// small frames is left out of payloads.
+func capped(b []byte, n int) []byte {
+	if n < 0 {
+		n = 0
+	}
+	if n < len(b) {
+		return b[:n:n]
+	}
+	return b
+}
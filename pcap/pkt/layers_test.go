@@ -0,0 +1,186 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !appengine
+
+package pkt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+)
+
+// Make sure each packet fills in the headers of the layers it has, and says
+// which those are.
+func TestLayerDecoder(t *testing.T) {
+	udp := tunnelFrame(t, []byte("query"), outerEth, outerIp, &UdpHdr{Source: 5353, Dest: 53})
+	ipFrag := patch(tcp4EthFrame, ethHdrLen+ipFragOffOff, 0x00, 0x02)
+	var d LayerDecoder
+	for name, c := range map[string]struct {
+		frame    []byte
+		linkType int32
+		layers   string
+		payload  string
+	}{
+		"tcp4":      {tcp4EthFrame, LinkTypeEthernet, "[ethernet ipv4 tcp]", "hello!"},
+		"tcp6 sll":  {tcp6SllFrame, LinkTypeLinuxSLL, "[ipv6 tcp]", "ping"},
+		"qinq":      {tagged(tcp4EthFrame, EtherTypeQinQ, 0x0064, EtherTypeDot1Q, 0x00c8, EtherTypeDot1Q, 0x012c), LinkTypeEthernet, "[ethernet vlan vlan ipv4 tcp]", "hello!"},
+		"mpls":      {withMpls(tcp6EthFrame, 16, 17), LinkTypeEthernet, "[ethernet ipv6 tcp]", ""},
+		"ip6 ext":   {withIp6Ext(tcp6SllFrame, sllHdrLen, hopByHop, dstOpts), LinkTypeLinuxSLL, "[ipv6 tcp]", "ping"},
+		"udp":       {udp, LinkTypeEthernet, "[ethernet ipv4 udp]", "query"},
+		"icmp":      {fragNeededFrame, LinkTypeEthernet, "[ethernet ipv4 icmp]", string(tcp4EthFrame[ethHdrLen : ethHdrLen+ipHdrLen+8])},
+		"arp":       {arpEthFrame, LinkTypeEthernet, "[ethernet]", string(arpEthFrame[ethHdrLen:])},
+		"fragment":  {ipFrag, LinkTypeEthernet, "[ethernet ipv4]", string(ipFrag[ethHdrLen+ipHdrLen:])},
+		"padded":    {append(append([]byte{}, tcp4EthFrame...), 0, 0, 0, 0), LinkTypeEthernet, "[ethernet ipv4 tcp]", "hello!"},
+		"raw":       {tcp4EthFrame[ethHdrLen:], LinkTypeRaw, "[ipv4 tcp]", "hello!"},
+		"null":      {bsdLoFrame(binary.LittleEndian), LinkTypeNull, "[ipv4 tcp]", "hello!"},
+		"snaplen":   {tcp4EthFrame[:len(tcp4EthFrame)-2], LinkTypeEthernet, "[ethernet ipv4 tcp]", "hell"},
+		"ip6 proto": {patch(tcp6SllFrame, sllHdrLen+ip6NextHdrOff, IpProtoGRE), LinkTypeLinuxSLL, "[ipv6]", string(tcp6SllFrame[sllHdrLen+IPV6_HEADER_LEN:])},
+	} {
+		b, done := guarded(t, c.frame)
+		if err := d.Decode(b, c.linkType); err != nil {
+			t.Errorf("%s: d.Decode: %v", name, err)
+		} else if s := fmt.Sprint(d.Layers()); s != c.layers {
+			t.Errorf("%s: d.Layers() = %s, want %s", name, s, c.layers)
+		} else if c.payload != "" && string(d.Payload) != c.payload {
+			t.Errorf("%s: d.Payload = %q, want %q", name, d.Payload, c.payload)
+		}
+		done()
+	}
+}
+
+// Make sure the headers hold the same values that the allocating decoders
+// give, and that reusing the LayerDecoder leaves nothing of the last packet
+// in the layers it reports.
+func TestLayerDecoderHeaders(t *testing.T) {
+	var d LayerDecoder
+	frame := tagged(tcp4EthFrame, EtherTypeDot1Q, 0x2064)
+	if err := d.Decode(frame, LinkTypeEthernet); err != nil {
+		t.Fatalf("d.Decode: %v", err)
+	}
+	eth, _, _ := NewEthHdr(frame)
+	if d.Eth.String() != eth.String() {
+		t.Errorf("d.Eth = %s, want %s", &d.Eth, eth)
+	}
+	if v := d.Vlans[0]; v.VLANID != 100 || v.Priority != 1 || v.EtherType != EtherTypeIPv4 {
+		t.Errorf("d.Vlans[0] = %s, want vlan 100 p1 0x800", &v)
+	}
+	ip, _, _ := NewIpHdr(tcp4EthFrame[ethHdrLen:])
+	if d.Ip.JsonElement() != ip.JsonElement() || d.Ip.DontFragment != ip.DontFragment || d.Ip.Id != ip.Id {
+		t.Errorf("d.Ip = %s, want %s", d.Ip.JsonElement(), ip.JsonElement())
+	}
+	tcp, _, _ := NewTcpHdr(tcp4EthFrame[ethHdrLen+ipHdrLen:])
+	if d.Tcp.JsonElement() != tcp.JsonElement() || d.Tcp.Window != tcp.Window || d.Tcp.Doff != tcp.Doff {
+		t.Errorf("d.Tcp = %s, want %s", d.Tcp.JsonElement(), tcp.JsonElement())
+	}
+	if d.TcpOptions() != nil {
+		t.Errorf("d.TcpOptions() = %v, want none", d.TcpOptions())
+	}
+
+	if err := d.Decode(tcp6SllFrame, LinkTypeLinuxSLL); err != nil {
+		t.Fatalf("d.Decode: %v", err)
+	}
+	if d.Has(LayerEthernet) || d.Has(LayerIPv4) {
+		t.Errorf("d.Layers() = %v after reuse", d.Layers())
+	}
+	if !d.Ip6.SrcAddr.Equal(net.ParseIP("2001:db8::1")) || !d.Ip6.DstAddr.Equal(net.ParseIP("2001:db8::2")) || d.Ip6.HopLimit != 64 {
+		t.Errorf("d.Ip6 = %s", &d.Ip6)
+	}
+	if d.Tcp.Source != 80 || d.Tcp.Flags != TCP_SYN|TCP_ACK {
+		t.Errorf("d.Tcp = %s, want 80->54321 SYN|ACK", &d.Tcp)
+	}
+	if o := d.TcpOptions(); o == nil || o.MSS != 1460 {
+		t.Errorf("d.TcpOptions() = %v, want MSS 1460", o)
+	}
+	if !bytes.Equal(d.Tcp.GetPayloadBytes(d.Ip6.PL()), []byte("ping")) {
+		t.Errorf("d.Tcp.GetPayloadBytes = %q, want \"ping\"", d.Tcp.GetPayloadBytes(d.Ip6.PL()))
+	}
+
+	if err := d.Decode(fragNeededFrame, LinkTypeEthernet); err != nil {
+		t.Fatalf("d.Decode: %v", err)
+	}
+	if d.Icmp.Type != IcmpDestUnreachable || d.Icmp.MTU != 1400 || d.Icmp.Quote != nil {
+		t.Errorf("d.Icmp = %+v, want fragmentation needed with mtu 1400", d.Icmp)
+	}
+}
+
+// Make sure headers we can't decode stop decoding for the right reason, with
+// the layers in front of them still reported.
+func TestLayerDecoderReject(t *testing.T) {
+	var d LayerDecoder
+	for name, c := range map[string]struct {
+		frame    []byte
+		linkType int32
+		reason   DecodeReason
+		hdr      string
+		layers   string
+	}{
+		"ppp":       {tcp4EthFrame, 9, DecodeUnsupportedLink, "", "[]"},
+		"empty":     {nil, LinkTypeEthernet, DecodeTruncated, "ether_header", "[]"},
+		"vlan":      {tagged(tcp4EthFrame, EtherTypeDot1Q, 0x0064)[:ethHdrLen+2], LinkTypeEthernet, DecodeTruncated, "vlan_tag", "[ethernet]"},
+		"version":   {patch(tcp4EthFrame, ethHdrLen, 0x65), LinkTypeEthernet, DecodeBadVersion, "iphdr", "[ethernet]"},
+		"ihl":       {patch(tcp4EthFrame, ethHdrLen, 0x44), LinkTypeEthernet, DecodeBadHeaderLength, "iphdr", "[ethernet]"},
+		"doff":      {patch(tcp4EthFrame, ethHdrLen+ipHdrLen+tcpFlagsOff, 0x40), LinkTypeEthernet, DecodeBadHeaderLength, "tcphdr", "[ethernet ipv4]"},
+		"tcp short": {tcp4EthFrame[:ethHdrLen+ipHdrLen+10], LinkTypeEthernet, DecodeTruncated, "tcphdr", "[ethernet ipv4]"},
+		"udp len":   {patch(tunnelFrame(t, nil, outerEth, outerIp, &UdpHdr{Dest: 53}), ethHdrLen+ipHdrLen+4, 0, 4), LinkTypeEthernet, DecodeBadHeaderLength, "udphdr", "[ethernet ipv4]"},
+		"ip6 ext":   {withIp6Ext(tcp6SllFrame, sllHdrLen, hopByHop)[:sllHdrLen+IPV6_HEADER_LEN+4], LinkTypeLinuxSLL, DecodeTruncated, "ip6_hbh", "[ipv6]"},
+	} {
+		b, done := guarded(t, c.frame)
+		err := d.Decode(b, c.linkType)
+		done()
+		if e, ok := err.(*DecodeError); !ok || e.Reason != c.reason || e.Hdr != c.hdr {
+			t.Errorf("%s: d.Decode = %v, want %s in %q", name, err, c.reason, c.hdr)
+		}
+		if s := fmt.Sprint(d.Layers()); s != c.layers {
+			t.Errorf("%s: d.Layers() = %s, want %s", name, s, c.layers)
+		}
+	}
+}
+
+// Make sure decoding stays free of heap allocations, failures included.
+func TestLayerDecoderAllocs(t *testing.T) {
+	var d LayerDecoder
+	udp := patch(tcp4EthFrame, ethHdrLen+ipProtocolOff, IpProtoUDP)
+	qinq := tagged(tcp6EthFrame, EtherTypeQinQ, 0x0064, EtherTypeDot1Q, 0x00c8)
+	allocs := testing.AllocsPerRun(100, func() {
+		d.Decode(tcp4EthFrame, LinkTypeEthernet)
+		d.Decode(tcp6SllFrame, LinkTypeLinuxSLL)
+		d.Decode(qinq, LinkTypeEthernet)
+		d.Decode(udp, LinkTypeEthernet)
+		d.Decode(fragNeededFrame, LinkTypeEthernet)
+		d.Decode(arpEthFrame, LinkTypeEthernet)
+		d.Decode(nil, LinkTypeEthernet)
+	})
+	if allocs != 0 {
+		t.Errorf("d.Decode allocs = %v, want 0", allocs)
+	}
+}
+
+func FuzzLayerDecoder(f *testing.F) {
+	f.Add(tcp4EthFrame, LinkTypeEthernet)
+	f.Add(tcp6SllFrame, LinkTypeLinuxSLL)
+	f.Add(fragNeededFrame, LinkTypeEthernet)
+	f.Add(arpEthFrame, LinkTypeEthernet)
+	f.Add(tagged(tcp6EthFrame, EtherTypeQinQ, 0x0064, EtherTypeDot1Q, 0x00c8), LinkTypeEthernet)
+	f.Add(withIp6Ext(tcp6SllFrame, sllHdrLen, hopByHop, firstFrag), LinkTypeLinuxSLL)
+	f.Add(withMpls(tcp4EthFrame, 16, 17), LinkTypeEthernet)
+	for _, m := range malformedFrames {
+		f.Add(m.frame, LinkTypeEthernet)
+	}
+	var d LayerDecoder
+	f.Fuzz(func(t *testing.T, frame []byte, linkType int32) {
+		b, done := guarded(t, frame)
+		defer done()
+		if d.Decode(b, linkType) == nil {
+			var sum byte
+			for _, c := range d.Payload {
+				sum += c
+			}
+			_ = sum
+		}
+	})
+}
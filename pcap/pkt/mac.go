@@ -0,0 +1,111 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ouiTxt is a sample of a few dozen common vendors from the IEEE MA-L
+// registry, in the format of its oui.txt.  It is not the registry, which
+// lists tens of thousands of vendors; see LoadOUITable.
+//
+//go:embed oui.txt
+var ouiTxt string
+
+// The OUI table maps the first three bytes of a MAC address to the vendor
+// they were assigned to.
+var (
+	ouiOnce  sync.Once
+	ouiMu    sync.RWMutex
+	ouiTable = make(map[[3]byte]string)
+)
+
+// loadEmbeddedOUIs fills the OUI table from ouiTxt the first time it is needed.
+func loadEmbeddedOUIs() {
+	ouiOnce.Do(func() {
+		loadOUIs(strings.NewReader(ouiTxt))
+	})
+}
+
+// LoadOUITable adds the vendors in r, which is in the format of the IEEE
+// registry file oui.txt (http://standards-oui.ieee.org/oui/oui.txt), to the
+// table Vendor looks in.  The built in table is only a sample of common
+// vendors, so programs that want most addresses named must load the registry
+// themselves, for example from a copy of oui.txt shipped alongside them.
+// Lines other than the "(hex)" ones are skipped.
+func LoadOUITable(r io.Reader) error {
+	loadEmbeddedOUIs()
+	return loadOUIs(r)
+}
+
+// loadOUIs does the work of LoadOUITable.
+func loadOUIs(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		i := strings.Index(line, "(hex)")
+		if i < 0 {
+			continue
+		}
+		b, err := hex.DecodeString(strings.Replace(strings.TrimSpace(line[:i]), "-", "", -1))
+		if err != nil || len(b) != 3 {
+			continue
+		}
+		var oui [3]byte
+		copy(oui[:], b)
+		ouiMu.Lock()
+		ouiTable[oui] = strings.TrimSpace(line[i+len("(hex)"):])
+		ouiMu.Unlock()
+	}
+	return s.Err()
+}
+
+// Vendor returns the name of the vendor the OUI of addr was assigned to, or
+// "" if it is not in the table.  Until LoadOUITable is called the table holds
+// only a sample of common vendors, so most addresses give "".  Locally
+// administered and group addresses are not assigned by the IEEE, so they have
+// no vendor.
+func Vendor(addr net.HardwareAddr) string {
+	if len(addr) < 3 || IsLocallyAdministered(addr) || IsMulticast(addr) {
+		return ""
+	}
+	loadEmbeddedOUIs()
+	ouiMu.RLock()
+	defer ouiMu.RUnlock()
+	return ouiTable[[3]byte{addr[0], addr[1], addr[2]}]
+}
+
+// IsBroadcast reports whether addr is the broadcast address ff:ff:ff:ff:ff:ff.
+func IsBroadcast(addr net.HardwareAddr) bool {
+	if len(addr) == 0 {
+		return false
+	}
+	for _, b := range addr {
+		if b != 0xFF {
+			return false
+		}
+	}
+	return true
+}
+
+// IsMulticast reports whether addr is a group address, which the broadcast
+// address is too.
+func IsMulticast(addr net.HardwareAddr) bool {
+	return len(addr) > 0 && addr[0]&0x01 != 0
+}
+
+// IsLocallyAdministered reports whether addr was set locally, for instance
+// for a virtual machine or a randomized Wi-Fi address, rather than assigned by
+// the vendor.
+func IsLocallyAdministered(addr net.HardwareAddr) bool {
+	return len(addr) > 0 && addr[0]&0x02 != 0
+}
@@ -0,0 +1,72 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// Make sure the addresses of an Ethernet header are decoded without an
+// allocation of their own.
+func TestNewEthHdrAddrs(t *testing.T) {
+	h, _, err := NewEthHdr(tcp4EthFrame)
+	if err != nil {
+		t.Fatalf("NewEthHdr: %v", err)
+	}
+	if s := h.DstAddr.String(); s != "00:11:22:33:44:55" {
+		t.Errorf("h.DstAddr = %s, want 00:11:22:33:44:55", s)
+	}
+	if s := h.SrcAddr.String(); s != "66:77:88:99:aa:bb" {
+		t.Errorf("h.SrcAddr = %s, want 66:77:88:99:aa:bb", s)
+	}
+	if n := testing.AllocsPerRun(10, func() { NewEthHdr(tcp4EthFrame) }); n != 1 {
+		t.Errorf("NewEthHdr allocates %v times, want 1", n)
+	}
+}
+
+func TestVendor(t *testing.T) {
+	for addr, want := range map[string]string{
+		"00:50:56:c0:00:08": "VMware, Inc.",
+		"b8:27:eb:12:34:56": "Raspberry Pi Foundation",
+		"02:50:56:c0:00:08": "", // locally administered
+		"01:00:5e:00:00:fb": "", // multicast
+		"00:11:22:33:44:55": "",
+	} {
+		mac, _ := net.ParseMAC(addr)
+		if v := Vendor(mac); v != want {
+			t.Errorf("Vendor(%s) = %q, want %q", addr, v, want)
+		}
+	}
+
+	err := LoadOUITable(strings.NewReader("00-11-22   (hex)\t\tCIMSYS Inc\n001122     (base 16)\t\tCIMSYS Inc\n"))
+	if err != nil {
+		t.Fatalf("LoadOUITable: %v", err)
+	}
+	defer func() {
+		ouiMu.Lock()
+		delete(ouiTable, [3]byte{0x00, 0x11, 0x22})
+		ouiMu.Unlock()
+	}()
+	if v := Vendor(net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}); v != "CIMSYS Inc" {
+		t.Errorf("Vendor after LoadOUITable = %q, want %q", v, "CIMSYS Inc")
+	}
+}
+
+func TestMacKinds(t *testing.T) {
+	for addr, want := range map[string][3]bool{ // broadcast, multicast, local
+		"ff:ff:ff:ff:ff:ff": {true, true, true},
+		"01:00:5e:00:00:fb": {false, true, false},
+		"33:33:00:00:00:01": {false, true, true},
+		"52:54:00:12:34:56": {false, false, true},
+		"00:50:56:c0:00:08": {false, false, false},
+	} {
+		mac, _ := net.ParseMAC(addr)
+		if got := [3]bool{IsBroadcast(mac), IsMulticast(mac), IsLocallyAdministered(mac)}; got != want {
+			t.Errorf("%s: broadcast, multicast, local = %v, want %v", addr, got, want)
+		}
+	}
+}
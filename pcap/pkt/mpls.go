@@ -0,0 +1,101 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// An MplsLabel is an entry of an MPLS label stack (RFC 3032).
+type MplsLabel struct {
+	Label uint32 // label value (20 bits)
+	TC    uint8  // traffic class (3 bits)
+	S     bool   // bottom of stack
+	TTL   uint8  // time to live
+}
+
+// The MplsHdr struct is an MPLS label stack.  The EtherType of the header in
+// front of it (0x8847, or 0x8848 for multicast) says that it is there.  MPLS
+// does not say what it carries, so EtherType is a guess made from the
+// version field of what follows the stack.
+type MplsHdr struct {
+	Labels    []MplsLabel // from the top of the stack down
+	EtherType uint16      // EtherTypeIPv4 or EtherTypeIPv6, or 0 if neither
+}
+
+// mplsStackLen returns the length of the label stack at the start of b, or
+// -1 if the bottom of the stack was not captured.
+func mplsStackLen(b []byte) int {
+	for n := 0; n+mplsLabelLen <= len(b); n += mplsLabelLen {
+		if b[n+2]&0x01 != 0 {
+			return n + mplsLabelLen
+		}
+	}
+	return -1
+}
+
+// NewMplsHdr returns the MPLS label stack at the start of b along with the
+// bytes that follow it.
+func NewMplsHdr(b []byte) (*MplsHdr, []byte, error) {
+	n := mplsStackLen(b)
+	if n < 0 {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "mpls", Value: len(b)/mplsLabelLen*mplsLabelLen + mplsLabelLen, Caplen: len(b)}
+	}
+	h := &MplsHdr{
+		Labels:    make([]MplsLabel, 0, n/mplsLabelLen),
+		EtherType: rawEtherType(LinkTypeRaw, b[n:]),
+	}
+	for i := 0; i < n; i += mplsLabelLen {
+		e := binary.BigEndian.Uint32(b[i:])
+		h.Labels = append(h.Labels, MplsLabel{
+			Label: e >> 12,
+			TC:    uint8(e>>9) & 0x07,
+			S:     e&0x100 != 0,
+			TTL:   uint8(e),
+		})
+	}
+	return h, b[n:], nil
+}
+
+// isMpls reports whether an EtherType announces an MPLS label stack.
+func isMpls(etherType uint16) bool {
+	return etherType == EtherTypeMPLS || etherType == EtherTypeMPLSMulticast
+}
+
+// JsonElement returns a JSON encoding of the MplsHdr struct.
+func (h *MplsHdr) JsonElement() string {
+	s := make([]string, len(h.Labels))
+	for i, l := range h.Labels {
+		s[i] = fmt.Sprintf("{\"label\":%d,\"tc\":%d,\"s\":%t,\"ttl\":%d}", l.Label, l.TC, l.S, l.TTL)
+	}
+	return fmt.Sprintf("\"mpls\":{\"labels\":[%s],\"ether_type\":%d}",
+		strings.Join(s, ","),
+		h.EtherType)
+}
+
+// CsvElement returns a CSV encoding of the MplsHdr struct.
+// The string "MPLS" signifies the beginning of the MplsHdr, which is followed
+// by the number of labels, the label, TC and TTL of each one and the
+// EtherType.
+func (h *MplsHdr) CsvElement() string {
+	s := []string{"\"MPLS\"", fmt.Sprint(len(h.Labels))}
+	for _, l := range h.Labels {
+		s = append(s, fmt.Sprintf("%d,%d,%d", l.Label, l.TC, l.TTL))
+	}
+	return strings.Join(append(s, fmt.Sprint(h.EtherType)), ",")
+}
+
+// String returns a minimal encoding of the MplsHdr struct.
+func (h *MplsHdr) String() string {
+	s := make([]string, len(h.Labels))
+	for i, l := range h.Labels {
+		s[i] = fmt.Sprint(l.Label)
+	}
+	return fmt.Sprintf("mpls %s %#x",
+		strings.Join(s, "/"),
+		h.EtherType)
+}
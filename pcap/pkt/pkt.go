@@ -7,34 +7,155 @@
 package pkt
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"strings"
+	"time"
 )
 
 // These indices can be used with the []Hdr generated by NewPacket to access
 // common headers.
 const (
-	LinkLayer      = 0 // Index for OSI Data Link Layer in Pkt.Headers
-	NetworkLayer   = 1 // Index for OSI Network Layer in Pkt.Headers
-	TransportLayer = 2 // Index for OSI Transport Layer in Pkt.Headers
+	LinkLayer        = 0 // Index for OSI Data Link Layer in Pkt.Headers
+	NetworkLayer     = 1 // Index for OSI Network Layer in Pkt.Headers
+	TransportLayer   = 2 // Index for OSI Transport Layer in Pkt.Headers
+	ApplicationLayer = 3 // Index for OSI Application Layer in Pkt.Headers
 )
 
+// Headers that do not take one of the slots above, such as 802.1Q tags, are
+// appended to Pkt.Headers from this index on, in the order they appear on the
+// wire.  Pkt.Headers only grows as far as the headers decoded, so the
+// ApplicationLayer slot is only there once it or an extra header is.
+const ExtraHeaders = 4
+
 // These two-octet constants can be compared with the captured value to indicate
 // which protocol is encapsulated in the payload of an Ethernet Frame.
 const (
-	EtherTypeIPv4 = uint16(0x0800) // Internet Protocol version 4
-	EtherTypeIPv6 = uint16(0x86DD) // Internet Protocol version 6
-	EtherTypeARP  = uint16(0x0806) // Address Resolution Protocol
+	EtherTypeIPv4  = uint16(0x0800) // Internet Protocol version 4
+	EtherTypeIPv6  = uint16(0x86DD) // Internet Protocol version 6
+	EtherTypeARP   = uint16(0x0806) // Address Resolution Protocol
+	EtherTypeDot1Q = uint16(0x8100) // IEEE 802.1Q VLAN tag
+	EtherTypeQinQ  = uint16(0x88A8) // IEEE 802.1ad service VLAN tag (QinQ)
+
+	EtherTypeMPLS          = uint16(0x8847) // MPLS unicast
+	EtherTypeMPLSMulticast = uint16(0x8848) // MPLS multicast
+
+	EtherTypeTEB = uint16(0x6558) // Transparent Ethernet Bridging, in tunnels
 )
 
 // These IP protocol numbers are used in the Protocol field of the IPv4 header
 // and the Next Header field of IPv6 header.
 const (
-	IpProtoTCP = uint8(0x06) // Transmission Control Protocol (TCP)
-	IpProtoUDP = uint8(0x11) // User Datagram Protocol (UDP)
+	IpProtoHopOpts  = uint8(0x00) // IPv6 Hop-by-Hop Options
+	IpProtoICMP     = uint8(0x01) // Internet Control Message Protocol (ICMP)
+	IpProtoIPIP     = uint8(0x04) // IPv4 encapsulation (IP-in-IP)
+	IpProtoTCP      = uint8(0x06) // Transmission Control Protocol (TCP)
+	IpProtoUDP      = uint8(0x11) // User Datagram Protocol (UDP)
+	IpProtoIPv6     = uint8(0x29) // IPv6 encapsulation
+	IpProtoRouting  = uint8(0x2B) // IPv6 Routing Header
+	IpProtoFragment = uint8(0x2C) // IPv6 Fragment Header
+	IpProtoGRE      = uint8(0x2F) // Generic Routing Encapsulation (GRE)
+	IpProtoICMPv6   = uint8(0x3A) // ICMP for IPv6
+	IpProtoDstOpts  = uint8(0x3C) // IPv6 Destination Options
+)
+
+// These link-layer header types are the DLT_ values from <pcap/bpf.h> that can
+// be compared with the result of pcap_datalink().
+const (
+	LinkTypeNull      = int32(0)   // BSD loopback encapsulation
+	LinkTypeEthernet  = int32(1)   // Ethernet (10Mb and up)
+	LinkTypeRaw       = int32(12)  // raw IP, either version
+	LinkTypeLoop      = int32(108) // OpenBSD loopback encapsulation
+	LinkTypeLinuxSLL  = int32(113) // Linux "cooked" capture encapsulation
+	LinkTypeIPv4      = int32(228) // raw IPv4
+	LinkTypeIPv6      = int32(229) // raw IPv6
+	LinkTypeLinuxSLL2 = int32(276) // Linux "cooked" capture encapsulation v2
 )
 
+// Lengths and field offsets of the fixed part of the headers we decode
+// straight from the wire.  All multi-byte fields are in network byte order.
+const (
+	ethHdrLen  = 14 // ether_header
+	ethTypeOff = 12
+	macLen     = 6 // an Ethernet address
+
+	sllHdrLen      = 16 // sll_header
+	sllProtocolOff = 14
+
+	sll2HdrLen = 20 // sll2_header, protocol first
+
+	bsdLoHdrLen = 4 // DLT_NULL (host byte order) or DLT_LOOP address family
+
+	dot1QHdrLen = 4 // 802.1Q tag following the TPID
+
+	mplsLabelLen = 4 // MPLS label stack entry
+
+	arpHdrLen = 8 // arphdr, without the addresses
+
+	ipHdrLen      = 20 // iphdr without options
+	ipTosOff      = 1
+	ipTotLenOff   = 2
+	ipIdOff       = 4
+	ipFragOffOff  = 6 // flags and fragment offset
+	ipTtlOff      = 8
+	ipProtocolOff = 9
+	ipCheckOff    = 10
+	ipSrcOff      = 12
+	ipDstOff      = 16
+
+	ip6PlenOff     = 4
+	ip6NextHdrOff  = 6
+	ip6HopLimitOff = 7
+	ip6SrcOff      = 8
+	ip6DstOff      = 24
+
+	ip6ExtMinLen = 8 // every extension header, and all of ip6_frag
+
+	udpHdrLen = 8 // udphdr
+
+	greHdrLen    = 4 // GRE header without the optional fields
+	vxlanHdrLen  = 8 // VXLAN header
+	geneveHdrLen = 8 // Geneve header without options
+
+	icmpHdrLen  = 8 // icmphdr
+	icmp6HdrLen = 8 // icmp6_hdr
+
+	tcpHdrLen    = 20 // tcphdr without options
+	tcpSourceOff = 0
+	tcpDestOff   = 2
+	tcpSeqOff    = 4
+	tcpAckSeqOff = 8
+	tcpFlagsOff  = 12 // data offset, reserved bits and flags
+	tcpWindowOff = 14
+	tcpCheckOff  = 16
+	tcpUrgPtrOff = 18
+)
+
+// These values of the 4-byte DLT_NULL header identify the address family of
+// the encapsulated packet.  IPv6 differs from one BSD to the next.
+const BSD_LO_IPV4 = 2
+const BSD_LO_IPV6 = 24
+const FBSD_LO_IPV6 = 28
+const OSX_LO_IPV6 = 30
+const IPV6_HEADER_LEN = 40 // fixed, unlike IPv4's
+
+// The Packet struct holds a captured packet and its decoded headers.
+type Packet struct {
+	Time     time.Time // time stamp from the nic
+	Caplen   uint32    // length of portion present
+	Len      uint32    // length this packet (off wire)
+	Headers  []Hdr     // the decoded headers, see LinkLayer and ExtraHeaders
+	LinkType int32     // link-layer header type of the packet data
+	Raw      []byte    // captured bytes kept by Keep, nil otherwise
+	data     []byte    // packet data, not copied
+	err      error     // why decoding stopped early, if it did
+	outer    []int     // where the extra headers of each tunnel's carrier end
+}
+
+// ErrNoRaw is returned by Redecode for a Packet that kept no captured bytes.
+var ErrNoRaw = errors.New("No raw packet bytes to decode")
+
 // The Hdr interface allows us to deal with an array of headers.
 type Hdr interface {
 	JsonElement() string
@@ -42,6 +163,69 @@ type Hdr interface {
 	String() string
 }
 
+// A DecodeReason says why a packet could not be decoded, or why decoding
+// stopped before reaching the transport layer.
+type DecodeReason uint8
+
+// These are the values of DecodeError.Reason.  The comment of each one says
+// what DecodeError.Value holds.
+const (
+	DecodeUnsupportedLink      DecodeReason = iota // link-layer type (DLT_)
+	DecodeUnsupportedEtherType                     // EtherType or address family
+	DecodeNotTCP                                   // IP protocol number
+	DecodeTruncated                                // bytes needed by the header
+	DecodeBadHeaderLength                          // value of the bad length field
+	DecodeBadVersion                               // IP version
+	DecodeFragment                                 // fragment offset (bytes)
+	DecodeNotUDP                                   // IP protocol number
+	NumDecodeReasons                               // number of DecodeReason values
+)
+
+var decodeReasons = [NumDecodeReasons]string{
+	"unsupported link type",
+	"unsupported ether_type",
+	"not tcp",
+	"truncated",
+	"bad header length",
+	"bad version",
+	"fragment",
+	"not udp",
+}
+
+// String returns a short description of the DecodeReason.
+func (r DecodeReason) String() string {
+	if r < NumDecodeReasons {
+		return decodeReasons[r]
+	}
+	return fmt.Sprintf("DecodeReason(%d)", r)
+}
+
+// A DecodeError reports why a packet could not be decoded.  No decoder ever
+// reads past the end of the capture, so a header that does not fit in the
+// captured bytes, or whose length fields are out of range, is reported as a
+// DecodeError too.
+type DecodeError struct {
+	Reason DecodeReason // why decoding failed
+	Hdr    string       // the header being decoded, e.g. "tcphdr"
+	Field  string       // the bad field for DecodeBadHeaderLength, e.g. "doff"
+	Value  int          // the offending value, see DecodeReason
+	Caplen int          // bytes left in the capture at the start of Hdr
+}
+
+func (e *DecodeError) Error() string {
+	switch e.Reason {
+	case DecodeUnsupportedLink:
+		return fmt.Sprintf("%s %d", e.Reason, e.Value)
+	case DecodeUnsupportedEtherType:
+		return fmt.Sprintf("%s: %s %#04x", e.Hdr, e.Reason, e.Value)
+	case DecodeTruncated:
+		return fmt.Sprintf("%s: %s, need %d bytes, have %d", e.Hdr, e.Reason, e.Value, e.Caplen)
+	case DecodeBadHeaderLength:
+		return fmt.Sprintf("%s: bad %s %d (caplen %d)", e.Hdr, e.Field, e.Value, e.Caplen)
+	}
+	return fmt.Sprintf("%s: %s %d", e.Hdr, e.Reason, e.Value)
+}
+
 // The InetProtoHdr interface allows us to deal with IPv4 and IPv6 headers
 // in aggregate.
 type InetProtoHdr interface {
@@ -51,40 +235,158 @@ type InetProtoHdr interface {
 	PL() uint16
 }
 
-// JsonString  returns a JSON encoding of the Packet struct.
+// Err returns the *DecodeError that stopped the decoding of the Packet headers,
+// or nil if the headers were decoded down to the transport layer (or as far as
+// this package knows how to).  The headers decoded before the error are still
+// available.
+func (p *Packet) Err() error {
+	return p.err
+}
+
+// Keep copies up to max of the captured bytes of the Packet into Raw, where
+// they outlive the capture buffer and are archived along with the headers.  A
+// negative max keeps all of them.
+func (p *Packet) Keep(max int) {
+	b := p.data
+	if max >= 0 && len(b) > max {
+		b = b[:max]
+	}
+	p.Raw = append([]byte(nil), b...)
+}
+
+// Redecode decodes the headers of the Packet again from Raw, as DecodePacket
+// would with the bytes and LinkType.  This brings a Packet read back from an
+// archive up to date with the decoders of this package and those registered
+// with it.  Headers added by hand are dropped.  The result is the same as
+// Err.
+func (p *Packet) Redecode() error {
+	if p.Raw == nil {
+		return ErrNoRaw
+	}
+	p.Headers = make([]Hdr, 3)
+	p.data = p.Raw
+	p.decode()
+	return p.err
+}
+
+// payloadBytes returns the l payload bytes that follow the n-byte header at
+// the start of raw, or as many of them as were captured.
+func payloadBytes(raw []byte, n, l int) []byte {
+	if l > len(raw)-n {
+		l = len(raw) - n
+	}
+	if l <= 0 {
+		return []byte{}
+	}
+	return raw[n : n+l : n+l]
+}
+
+// setHeader puts h in the Packet headers at index i, growing them to fit.
+func (p *Packet) setHeader(i int, h Hdr) {
+	for len(p.Headers) <= i {
+		p.Headers = append(p.Headers, nil)
+	}
+	p.Headers[i] = h
+}
+
+// addHeader appends h to the Packet headers past ExtraHeaders.
+func (p *Packet) addHeader(h Hdr) {
+	for len(p.Headers) < ExtraHeaders {
+		p.Headers = append(p.Headers, nil)
+	}
+	p.Headers = append(p.Headers, h)
+}
+
+// stacks splits the Packet headers by the packet they belong to.  The first
+// stack is the innermost packet, in the order of the layer slots followed by
+// its extra headers.  The packets that carried it through tunnels follow,
+// from the innermost tunnel out, each with its headers in wire order.
+func (p *Packet) stacks() [][]Hdr {
+	if len(p.Headers) <= ExtraHeaders {
+		return [][]Hdr{p.Headers}
+	}
+	extra := p.Headers[ExtraHeaders:]
+	end := 0
+	if n := len(p.outer); n > 0 {
+		end = p.outer[n-1]
+	}
+	inner := append(p.Headers[:ExtraHeaders:ExtraHeaders], extra[end:]...)
+	s := [][]Hdr{inner}
+	for i := len(p.outer) - 1; i >= 0; i-- {
+		start := 0
+		if i > 0 {
+			start = p.outer[i-1]
+		}
+		s = append(s, extra[start:p.outer[i]])
+	}
+	return s
+}
+
+// JsonString  returns a JSON encoding of the Packet struct.  The 802.1Q tags
+// of a QinQ frame would share a key, so the tags are listed, outer tag first,
+// in a "vlan_tags" array.  The headers of the packet that carried this one
+// through a tunnel are encoded the same way under "outer", and so on for
+// each tunnel, from the innermost out.
 func (p *Packet) JsonString() string {
-	s := make([]string, len(p.Headers))
-	for i := range p.Headers {
-		if p.Headers[i] == nil {
-			break
+	return fmt.Sprintf("{\"time\":%d%s}", p.Time.UnixNano(), jsonStacks(p.stacks()))
+}
+
+// jsonStacks returns the JSON members for the headers of the first of stacks,
+// each with a leading comma, followed by the others under "outer".
+func jsonStacks(stacks [][]Hdr) string {
+	var s, tags []string
+	for _, h := range stacks[0] {
+		switch h := h.(type) {
+		case nil:
+		case *Dot1QHdr:
+			tags = append(tags, h.jsonValue())
+		default:
+			s = append(s, h.JsonElement())
 		}
-		s[i] = p.Headers[i].JsonElement()
 	}
-	return fmt.Sprintf("{\"time\":%d,%s}", p.Time.UnixNano(), strings.Join(s, ","))
+	if tags != nil {
+		s = append(s, fmt.Sprintf("\"vlan_tags\":[%s]", strings.Join(tags, ",")))
+	}
+	if len(stacks) > 1 {
+		outer := jsonStacks(stacks[1:])
+		if outer != "" {
+			outer = outer[1:]
+		}
+		s = append(s, fmt.Sprintf("\"outer\":{%s}", outer))
+	}
+	if len(s) == 0 {
+		return ""
+	}
+	return "," + strings.Join(s, ",")
 }
 
 // CsvString  returns a CSV encoding of the Packet struct.
 // Each header type has a unique string that marks the beginning of the CSV
-// fields for that particular header.
+// fields for that particular header.  The string "OUTER" marks the beginning
+// of the headers of the packet that carried the ones before it through a
+// tunnel.
 func (p *Packet) CsvString() string {
-	s := make([]string, len(p.Headers))
-	for i := range p.Headers {
-		if p.Headers[i] == nil {
-			break
+	s := make([]string, 0, len(p.Headers)+1)
+	for i, stack := range p.stacks() {
+		if i > 0 {
+			s = append(s, "\"OUTER\"")
+		}
+		for _, h := range stack {
+			if h != nil {
+				s = append(s, h.CsvElement())
+			}
 		}
-		s[i] = p.Headers[i].CsvElement()
 	}
 	return fmt.Sprintf("%d,%s", p.Time.UnixNano(), strings.Join(s, ","))
 }
 
 // String returns a minimal encoding of the Packet struct.
 func (p *Packet) String() string {
-	s := make([]string, len(p.Headers))
+	s := make([]string, 0, len(p.Headers))
 	for i := range p.Headers {
-		if p.Headers[i] == nil {
-			break
+		if p.Headers[i] != nil {
+			s = append(s, p.Headers[i].String())
 		}
-		s[i] = p.Headers[i].String()
 	}
 	return fmt.Sprintf("%s %s", p.Time, strings.Join(s, " "))
 }
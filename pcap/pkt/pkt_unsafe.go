@@ -8,64 +8,6 @@ package pkt
 
 /*
 #include "../pcap.h"
-#include "../pcap/bpf.h"
-#include "../pcap/sll.h"
-#include <sys/socket.h>
-#include <netinet/in.h>
-#include <netinet/if_ether.h>
-#include <netinet/tcp.h>
-
-// linux cooked header
-struct gen_sll {
-	u_int16_t pkt_type;
-	u_int16_t addr_type;
-	u_int16_t addr_len;
-	u_int8_t addr[8];
-	u_int16_t protocol;
-};
-
-// generic little-endian tcphdr
-struct gen_tcphdr {
-	u_int16_t source;
-	u_int16_t dest;
-	u_int32_t seq;
-	u_int32_t ack_seq;
-	u_int16_t flags;
-	u_int16_t window;
-	u_int16_t check;
-	u_int16_t urg_ptr;
-};
-
-// generic little-endian iphdr
-struct gen_iphdr {
-	u_int8_t misc;
-	u_int8_t tos;
-	u_int16_t len;
-	u_int16_t id;
-	u_int16_t off;
-	u_int8_t ttl;
-	u_int8_t protocol;
-	u_int16_t checksum;
-	u_int32_t src_addr;
-	u_int32_t dst_addr;
-};
-
-// generic little-endian ip6hdr
-struct gen_ip6hdr {
-	u_int32_t misc; // 4 bits version, 8 traffic class, 20 flow label
-	u_int16_t len;
-	u_int8_t next_hdr;
-	u_int8_t ttl;
-	u_int32_t src_addr0;
-	u_int32_t src_addr1;
-	u_int32_t src_addr2;
-	u_int32_t src_addr3;
-	u_int32_t dst_addr0;
-	u_int32_t dst_addr1;
-	u_int32_t dst_addr2;
-	u_int32_t dst_addr3;
-};
-
 */
 import "C"
 import (
@@ -76,103 +18,24 @@ import (
 	"unsafe"
 )
 
-// The Packet struct is a wrapper for the pcap_pkthdr struct in <pcap.h>.
-type Packet struct {
-	Time    time.Time      // time stamp from the nic
-	Caplen  uint32         // length of portion present
-	Len     uint32         // length this packet (off wire)
-	Headers []Hdr          // Go wrappers for C pkt headers
-	buf     unsafe.Pointer // packet data (*C.u_char)
-}
-
 // NewPacket returns a parsed and decoded Packet.
 // pkthdr_ptr should be a *C.struct_pcap_pkthdr
 // buf_ptr should be a *C.u_char
+// The packet is taken to be an Ethernet frame; see NewPacketDatalink for the
+// other link-layer types.
 func NewPacket(pkthdr_ptr unsafe.Pointer, buf_ptr unsafe.Pointer) *Packet {
-	pkthdr := *(*C.struct_pcap_pkthdr)(pkthdr_ptr)
-
-	p := &Packet{
-		Time:    time.Unix(int64(pkthdr.ts.tv_sec), int64(pkthdr.ts.tv_usec)*1000),
-		Caplen:  uint32(pkthdr.caplen),
-		Len:     uint32(pkthdr.len),
-		Headers: make([]Hdr, 3),
-		buf:     buf_ptr,
-	}
-	p.decode()
-	return p
-}
-
-// Decode decodes the headers of a Packet.
-func (p *Packet) decode() {
-	ethHdr, buf := NewEthHdr(p.buf)
-	p.Headers[LinkLayer] = ethHdr
-
-	switch ethHdr.EtherType {
-	case C.ETHERTYPE_IP, 0:
-		p.Headers[NetworkLayer], buf = NewIpHdr(buf)
-	case C.ETHERTYPE_IPV6:
-		p.Headers[NetworkLayer], buf = NewIp6Hdr(buf)
-	case C.ETHERTYPE_ARP:
-		//TODO(gavaletz) ARP
-		return
-	default:
-		return
-	}
-
-	switch p.Headers[NetworkLayer].(InetProtoHdr).Proto() {
-	case C.IPPROTO_TCP:
-		p.Headers[TransportLayer], _ = NewTcpHdr(buf)
-	case C.IPPROTO_UDP:
-		p.Headers[TransportLayer], _ = NewUdpHdr(buf)
-		return
-	case C.IPPROTO_ICMP:
-		//TODO(gavaletz) ICMP
-		return
-	default:
-		return
-	}
+	return NewPacketDatalink(pkthdr_ptr, buf_ptr, LinkTypeEthernet)
 }
 
-type TcpPacket struct {
-	DstAddr0  uint32 // IPv4 uses only this one, others are 0
-	DstAddr1  uint32
-	DstAddr2  uint32
-	DstAddr3  uint32
-	SrcAddr0  uint32 // IPv4 uses only this one, others are 0
-	SrcAddr1  uint32
-	SrcAddr2  uint32
-	SrcAddr3  uint32
-	AckSeq    uint32
-	Seq       uint32
-	Source    uint16
-	Dest      uint16
-	Flags     uint16
-	Payload   []byte
-	Timestamp time.Time
-	IsRequest bool
-	Saved     bool
-}
-
-func (this *TcpPacket) IsIPv4() bool {
-	return this.DstAddr1 == 0 && this.DstAddr2 == 0 && this.DstAddr3 == 0
-}
-
-func (this *TcpPacket) Save() {
-	if !this.Saved {
-		var dcopy = make([]byte, len(this.Payload))
-		copy(dcopy, this.Payload)
-		this.Payload = dcopy
-		this.Saved = true
-	}
-}
+// NewPacketDatalink is NewPacket for a packet of the link-layer header type
+// datalinkType, as pcap_datalink() gives it.
+func NewPacketDatalink(pkthdr_ptr unsafe.Pointer, buf_ptr unsafe.Pointer, datalinkType int32) *Packet {
+	pkthdr := *(*C.struct_pcap_pkthdr)(pkthdr_ptr)
 
-func (this *TcpPacket) Clone() *TcpPacket {
-	dupe := *this
-	if !this.Saved {
-		dupe.Payload = append([]byte{}, this.Payload...)
-		dupe.Saved = true
-	}
-	return &dupe
+	p := DecodePacket(cBytes(buf_ptr, int(pkthdr.caplen)), datalinkType)
+	p.Time = time.Unix(int64(pkthdr.ts.tv_sec), int64(pkthdr.ts.tv_usec)*1000)
+	p.Len = uint32(pkthdr.len)
+	return p
 }
 
 // debugging aid
@@ -192,147 +55,90 @@ func dumpBuf(pbuf unsafe.Pointer, maxlen int) string {
 	return fmt.Sprintf("[%d] [%s] [ %s]", maxlen, plA, plH)
 }
 
-// FIXME: we are assuming little endian arch... everywhere
-const ETHERTYPE_IP = C.ETHERTYPE_IP>>8 | C.ETHERTYPE_IP&0xFF<<8
-const ETHERTYPE_IPV6 = C.ETHERTYPE_IPV6>>8 | C.ETHERTYPE_IPV6&0xFF<<8
-const BSD_LO_IPV4 = C.AF_INET
-const BSD_LO_IPV6 = 24
-const FBSD_LO_IPV6 = 28
-const OSX_LO_IPV6 = 30
-const IPV6_HEADER_LEN = 40 // fixed, unlike IPv4's
-
 // NewPacket2 takes a libpcap buffer and extracts a TCP/IPv{4,6} packet into
 // a new TcpPacket without creating additional data in the heap.
 // If the recipient of this packet needs to keep it after returning to sniffer,
 // it should call func Save() so the packet's payload becomes private instead
 // of mapped into sniffer's buffers.
-// Returns TcpPacket or nil if error.
-func NewPacket2(pkthdr_ptr unsafe.Pointer, buf_ptr unsafe.Pointer, datalinkType int32) *TcpPacket {
+// Returns nil and a *DecodeError if the packet can't be decoded.
+func NewPacket2(pkthdr_ptr unsafe.Pointer, buf_ptr unsafe.Pointer, datalinkType int32) (*TcpPacket, error) {
 	var packet TcpPacket
-	if NewPacketAllocless(pkthdr_ptr, buf_ptr, datalinkType, &packet) {
-		return &packet
+	if err := NewPacketAllocless(pkthdr_ptr, buf_ptr, datalinkType, &packet); err != nil {
+		return nil, err
 	}
-	return nil
+	return &packet, nil
 }
 
 // NewPacketAllocless takes a libpcap buffer and extracts a TCP/IPv{4,6} packet into
 // an existing TcpPacket. Payload isn't copied, it's mapped, use func Clone()/Save()
 // to get a non-volatile copy.
-// Returns false if error.
-func NewPacketAllocless(pkthdr_ptr unsafe.Pointer, buf_ptr unsafe.Pointer, datalinkType int32, packet *TcpPacket) bool {
-	pkthdr := *(*C.struct_pcap_pkthdr)(pkthdr_ptr)
+// Returns a *DecodeError if the packet can't be decoded.  To keep the failures
+// free of allocations too, the *DecodeError is kept in packet and is only good
+// until packet is used again.
+func NewPacketAllocless(pkthdr_ptr unsafe.Pointer, buf_ptr unsafe.Pointer, datalinkType int32, packet *TcpPacket) error {
+	pkthdr := (*C.struct_pcap_pkthdr)(pkthdr_ptr)
 
 	if pkthdr.caplen != pkthdr.len {
-		return false // Errorf("incomplete packet")
+		return packet.fail(DecodeError{Reason: DecodeTruncated, Hdr: "pcap_pkthdr", Value: int(pkthdr.len), Caplen: int(pkthdr.caplen)})
 	}
 
 	packet.Timestamp = time.Unix(int64(pkthdr.ts.tv_sec), int64(pkthdr.ts.tv_usec)*1000)
+	return decodeTcpPacket(cBytes(buf_ptr, int(pkthdr.caplen)), datalinkType, packet)
+}
 
-	var ipv6 bool
+// NewUdpPacketAllocless is NewPacketAllocless for UDP/IPv{4,6} packets.
+func NewUdpPacketAllocless(pkthdr_ptr unsafe.Pointer, buf_ptr unsafe.Pointer, datalinkType int32, packet *UdpPacket) error {
+	pkthdr := (*C.struct_pcap_pkthdr)(pkthdr_ptr)
 
-	if datalinkType == C.DLT_LINUX_SLL {
-		// unwrap cooked packet
-		switch (*C.struct_gen_sll)(buf_ptr).protocol {
-		case ETHERTYPE_IP:
-		case ETHERTYPE_IPV6:
-			ipv6 = true
-		default:
-			return false // Errorf("unsupported sll_type=%d", (*C.struct_gen_sll)(buf_ptr).protocol)
-		}
-		buf_ptr = unsafe.Pointer(uintptr(buf_ptr) + uintptr(C.SLL_HDR_LEN))
-	} else if datalinkType == C.DLT_EN10MB {
-		// unwrap ethernet packet
-		switch (*C.struct_ether_header)(buf_ptr).ether_type {
-		case 0: // The "cooked" headers have an extra two bytes.
-			buf_ptr = unsafe.Pointer(uintptr(buf_ptr) + uintptr(C.ETHER_HDR_LEN) + uintptr(2))
-		case ETHERTYPE_IP:
-			buf_ptr = unsafe.Pointer(uintptr(buf_ptr) + uintptr(C.ETHER_HDR_LEN))
-		case ETHERTYPE_IPV6:
-			ipv6 = true
-			buf_ptr = unsafe.Pointer(uintptr(buf_ptr) + uintptr(C.ETHER_HDR_LEN))
-		default:
-			return false // Errorf("unsupported ether_type=%d", (*C.struct_ether_header)(buf_ptr).ether_type)
-		}
-	} else if datalinkType == C.DLT_NULL { // BSD Loopback
-		switch *(*uint32)(buf_ptr) {
-		case BSD_LO_IPV4:
-		case BSD_LO_IPV6, FBSD_LO_IPV6, OSX_LO_IPV6:
-			ipv6 = true
-		default:
-			return false // Errorf("unsupported bsdlo_type=%d", *(*uint32)(buf_ptr))
-		}
-		buf_ptr = unsafe.Pointer(uintptr(buf_ptr) + uintptr(4))
-	} else {
-		return false // Errorf("unsupported packet format %d", datalinkType)
+	if pkthdr.caplen != pkthdr.len {
+		return packet.fail(DecodeError{Reason: DecodeTruncated, Hdr: "pcap_pkthdr", Value: int(pkthdr.len), Caplen: int(pkthdr.caplen)})
 	}
 
-	var dataoffset, paylen, flags uint16
-
-	if ipv6 {
-		// unwrap IPv6 packet
-		var iphdr = (*C.struct_gen_ip6hdr)(buf_ptr)
-		// verify version and protocol
-		if iphdr.misc&0xF0 != 6<<4 || iphdr.next_hdr != C.IPPROTO_TCP {
-			return false
-		}
-
-		// leave address re-ordering/whatever to consumer
-		packet.SrcAddr0 = uint32(iphdr.src_addr0)
-		packet.SrcAddr1 = uint32(iphdr.src_addr1)
-		packet.SrcAddr2 = uint32(iphdr.src_addr2)
-		packet.SrcAddr3 = uint32(iphdr.src_addr3)
-		packet.DstAddr0 = uint32(iphdr.dst_addr0)
-		packet.DstAddr1 = uint32(iphdr.dst_addr1)
-		packet.DstAddr2 = uint32(iphdr.dst_addr2)
-		packet.DstAddr3 = uint32(iphdr.dst_addr3)
-
-		// unwrap tcp packet
-		buf_ptr = unsafe.Pointer(uintptr(buf_ptr) + IPV6_HEADER_LEN)
-		var tcphdr = (*C.struct_gen_tcphdr)(buf_ptr)
-
-		packet.AckSeq = uint32(tcphdr.ack_seq)
-		packet.Seq = uint32(tcphdr.seq)
-		packet.Source = uint16(tcphdr.source)
-		packet.Dest = uint16(tcphdr.dest)
-
-		flags = uint16(tcphdr.flags)
-		dataoffset = uint16(flags>>2) & 0x3C
-
-		paylen = uint16(iphdr.len<<8) | uint16(iphdr.len>>8) - dataoffset
-	} else {
-		// unwrap ip packet
-		var iphdr = (*C.struct_gen_iphdr)(buf_ptr)
-		// verify protocol
-		if iphdr.protocol != C.IPPROTO_TCP {
-			return false // Errorf("unsupported packet proto=%d", iphdr.protocol)
-		}
-
-		packet.DstAddr0 = uint32(iphdr.dst_addr)
-		packet.SrcAddr0 = uint32(iphdr.src_addr)
-
-		// unwrap tcp packet
-		iphdrlen := uint16(iphdr.misc&0x0F) * 4
-		buf_ptr = unsafe.Pointer(uintptr(buf_ptr) + uintptr(iphdrlen))
-		var tcphdr = (*C.struct_gen_tcphdr)(buf_ptr)
-
-		packet.AckSeq = uint32(tcphdr.ack_seq)
-		packet.Seq = uint32(tcphdr.seq)
-		packet.Source = uint16(tcphdr.source)
-		packet.Dest = uint16(tcphdr.dest)
-
-		flags = uint16(tcphdr.flags)
-		dataoffset = uint16(flags>>2) & 0x3C
+	packet.Timestamp = time.Unix(int64(pkthdr.ts.tv_sec), int64(pkthdr.ts.tv_usec)*1000)
+	return decodeUdpPacket(cBytes(buf_ptr, int(pkthdr.caplen)), datalinkType, packet)
+}
 
-		paylen = uint16(iphdr.len<<8) | uint16(iphdr.len>>8) - iphdrlen - dataoffset
+// NewTransportAllocless is NewPacketAllocless for TCP and UDP packets alike.
+// A TCP packet is extracted into tcp and a UDP packet into udp, and the IP
+// protocol says which one it was.  Packets of other protocols, and those
+// whose TcpPacket or UdpPacket is nil, are turned down.  At least one of tcp
+// and udp must not be nil; the *DecodeError is kept in the packet it is
+// about, or in tcp if it is not about either.
+func NewTransportAllocless(pkthdr_ptr unsafe.Pointer, buf_ptr unsafe.Pointer, datalinkType int32, tcp *TcpPacket, udp *UdpPacket) (uint8, error) {
+	pkthdr := (*C.struct_pcap_pkthdr)(pkthdr_ptr)
+
+	ts := time.Unix(int64(pkthdr.ts.tv_sec), int64(pkthdr.ts.tv_usec)*1000)
+	if tcp != nil {
+		tcp.Timestamp = ts
+	}
+	if udp != nil {
+		udp.Timestamp = ts
+	}
+	if pkthdr.caplen != pkthdr.len {
+		return 0, failTransport(tcp, udp, DecodeError{Reason: DecodeTruncated, Hdr: "pcap_pkthdr", Value: int(pkthdr.len), Caplen: int(pkthdr.caplen)})
 	}
+	return decodeTransport(cBytes(buf_ptr, int(pkthdr.caplen)), datalinkType, tcp, udp)
+}
 
-	*((*reflect.SliceHeader)(unsafe.Pointer(&packet.Payload))) = reflect.SliceHeader{Data: uintptr(unsafe.Pointer(uintptr(buf_ptr) + uintptr(dataoffset))), Len: int(paylen), Cap: int(paylen)}
+// NewPacketLayers takes a libpcap buffer and decodes the packet into the
+// headers of an existing LayerDecoder, see LayerDecoder.Decode.  Packets cut
+// short by the snaplen are decoded as far as they go, so the Payload may hold
+// less than the headers say.  Nothing is copied or allocated; the headers and
+// the Payload are only good until d is used again.
+func NewPacketLayers(pkthdr_ptr unsafe.Pointer, buf_ptr unsafe.Pointer, datalinkType int32, d *LayerDecoder) error {
+	pkthdr := (*C.struct_pcap_pkthdr)(pkthdr_ptr)
 
-	packet.Flags = (flags>>8 | flags<<8) & uint16(0x01FF)
-	packet.Dest = packet.Dest>>8 | packet.Dest<<8
-	packet.Source = packet.Source>>8 | packet.Source<<8
-	packet.Seq = packet.Seq>>24 | packet.Seq&uint32(0x00ff0000)>>8 | packet.Seq&uint32(0x0000ff00)<<8 | packet.Seq<<24
-	packet.AckSeq = packet.AckSeq>>24 | packet.AckSeq&uint32(0x00ff0000)>>8 | packet.AckSeq&uint32(0x0000ff00)<<8 | packet.AckSeq<<24
+	d.Timestamp = time.Unix(int64(pkthdr.ts.tv_sec), int64(pkthdr.ts.tv_usec)*1000)
+	return d.Decode(cBytes(buf_ptr, int(pkthdr.caplen)), datalinkType)
+}
 
-	return true
+// cBytes returns a Go slice backed by the n bytes of C memory at p.  No copy is
+// made, so the slice is only good for as long as libpcap keeps the buffer.
+func cBytes(p unsafe.Pointer, n int) []byte {
+	var b []byte
+	sh := (*reflect.SliceHeader)(unsafe.Pointer(&b))
+	sh.Cap = n
+	sh.Len = n
+	sh.Data = uintptr(p)
+	return b
 }
@@ -0,0 +1,203 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"bytes"
+	"sync"
+)
+
+// A DecodeFunc decodes the header at the start of b and returns it along with
+// the bytes that follow it.  It must not keep b past the life of the Packet,
+// and must not read past its end; a header that does not fit is reported with
+// a *DecodeError.  An application layer DecodeFunc returns a nil Hdr and a
+// nil error for a payload that is not its protocol, so that the next one can
+// have a go.
+type DecodeFunc func(b []byte) (Hdr, []byte, error)
+
+// A LinkHdr is a link-layer header that says which EtherType follows it.  The
+// header returned by a DecodeFunc given to RegisterLinkType has to be one for
+// decoding to go on past the link layer.
+type LinkHdr interface {
+	Hdr
+	NextEtherType() uint16
+}
+
+// A PortHdr is a transport layer header with ports, whose payload is handed
+// to the decoders given to RegisterPort and RegisterHeuristic.
+type PortHdr interface {
+	Hdr
+	Ports() (src, dst uint16)
+}
+
+// The registry holds the decoders added by the Register functions.
+var registry struct {
+	sync.RWMutex
+	linkTypes  map[int32]DecodeFunc
+	etherTypes map[uint16]DecodeFunc
+	ipProtos   map[uint8]DecodeFunc
+	ports      map[uint16]DecodeFunc
+	heuristics []DecodeFunc
+}
+
+func init() {
+	RegisterPort(80, decodeHttp)
+}
+
+// RegisterLinkType adds a decoder for a link-layer header type that this
+// package does not decode itself.  The header goes in Headers[LinkLayer].
+// Registering a nil DecodeFunc removes the decoder.
+func RegisterLinkType(linkType int32, d DecodeFunc) {
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.linkTypes == nil {
+		registry.linkTypes = make(map[int32]DecodeFunc)
+	}
+	if d == nil {
+		delete(registry.linkTypes, linkType)
+		return
+	}
+	registry.linkTypes[linkType] = d
+}
+
+// RegisterEtherType adds a decoder for an EtherType that this package does
+// not decode itself.  The header goes in Headers[NetworkLayer], and if it is
+// an InetProtoHdr its Proto is decoded next like that of an IP header.
+// Registering a nil DecodeFunc removes the decoder.
+func RegisterEtherType(etherType uint16, d DecodeFunc) {
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.etherTypes == nil {
+		registry.etherTypes = make(map[uint16]DecodeFunc)
+	}
+	if d == nil {
+		delete(registry.etherTypes, etherType)
+		return
+	}
+	registry.etherTypes[etherType] = d
+}
+
+// RegisterIPProto adds a decoder for an IP protocol that this package does
+// not decode itself.  The header goes in Headers[TransportLayer], and if it
+// is a PortHdr the application layer is decoded from the bytes that follow.
+// Registering a nil DecodeFunc removes the decoder.
+func RegisterIPProto(proto uint8, d DecodeFunc) {
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.ipProtos == nil {
+		registry.ipProtos = make(map[uint8]DecodeFunc)
+	}
+	if d == nil {
+		delete(registry.ipProtos, proto)
+		return
+	}
+	registry.ipProtos[proto] = d
+}
+
+// RegisterPort adds a decoder for the application layer protocol on a TCP or
+// UDP port, or that of any other PortHdr.  It is tried on the payload of the
+// packets with that destination port, then on those with that source port,
+// and the header goes in Headers[ApplicationLayer].  HttpHdr is registered
+// for port 80 from the start.  Registering a nil DecodeFunc removes the
+// decoder.
+func RegisterPort(port uint16, d DecodeFunc) {
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.ports == nil {
+		registry.ports = make(map[uint16]DecodeFunc)
+	}
+	if d == nil {
+		delete(registry.ports, port)
+		return
+	}
+	registry.ports[port] = d
+}
+
+// RegisterHeuristic adds a decoder for an application layer protocol that is
+// not tied to a port.  The heuristic decoders are tried, in the order they
+// were registered, on the payloads that no port decoder took, so they should
+// turn down what is not theirs quickly.
+func RegisterHeuristic(d DecodeFunc) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.heuristics = append(registry.heuristics, d)
+}
+
+// linkTypeDecoder returns the decoder registered for linkType, or nil.
+func linkTypeDecoder(linkType int32) DecodeFunc {
+	registry.RLock()
+	defer registry.RUnlock()
+	return registry.linkTypes[linkType]
+}
+
+// etherTypeDecoder returns the decoder registered for etherType, or nil.
+func etherTypeDecoder(etherType uint16) DecodeFunc {
+	registry.RLock()
+	defer registry.RUnlock()
+	return registry.etherTypes[etherType]
+}
+
+// ipProtoDecoder returns the decoder registered for proto, or nil.
+func ipProtoDecoder(proto uint8) DecodeFunc {
+	registry.RLock()
+	defer registry.RUnlock()
+	return registry.ipProtos[proto]
+}
+
+// decodeRegistered decodes the header at the start of b with d, if it is not
+// nil.  The header is nil if there is no decoder or it turned b down.
+func decodeRegistered(d DecodeFunc, b []byte) (Hdr, []byte, error) {
+	if d == nil {
+		return nil, nil, nil
+	}
+	h, next, err := d(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, next, nil
+}
+
+// decodeApplication decodes the application layer header in the payload b of
+// the transport header h, if one of the registered decoders takes it.
+func (p *Packet) decodeApplication(h PortHdr, b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	src, dst := h.Ports()
+	registry.RLock()
+	ports := [2]DecodeFunc{registry.ports[dst], registry.ports[src]}
+	heuristics := registry.heuristics
+	registry.RUnlock()
+	for _, ds := range [][]DecodeFunc{ports[:], heuristics} {
+		for _, d := range ds {
+			if d == nil {
+				continue
+			}
+			a, _, err := d(b)
+			if err != nil {
+				return err
+			}
+			if a != nil {
+				p.setHeader(ApplicationLayer, a)
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// decodeHttp is the DecodeFunc of HttpHdr, which only looks at the first line
+// of b.
+func decodeHttp(b []byte) (Hdr, []byte, error) {
+	i := bytes.Index(b, []byte("\r\n"))
+	if i < 0 {
+		return nil, b, nil
+	}
+	h := NewHttpHdr(b[:i+2])
+	if h == nil {
+		return nil, b, nil
+	}
+	return h, b, nil
+}
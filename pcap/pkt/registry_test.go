@@ -0,0 +1,161 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !appengine
+
+package pkt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// A testHdr is a header of a made-up protocol: two bytes of "next" value
+// (an EtherType, IP protocol or destination port), then a name of four bytes.
+type testHdr struct {
+	Next uint16
+	Name string
+}
+
+func decodeTestHdr(b []byte) (Hdr, []byte, error) {
+	if len(b) < 6 {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "test", Value: 6, Caplen: len(b)}
+	}
+	return &testHdr{binary.BigEndian.Uint16(b), string(b[2:6])}, b[6:], nil
+}
+
+func (h *testHdr) JsonElement() string { return "\"test\":{}" }
+func (h *testHdr) CsvElement() string  { return "\"TEST\"" }
+func (h *testHdr) String() string      { return h.Name }
+
+// testLinkHdr is a testHdr that makes a link-layer header.
+type testLinkHdr struct{ testHdr }
+
+func (h *testLinkHdr) NextEtherType() uint16 { return h.Next }
+
+// testPortHdr is a testHdr that makes a transport header, sent to port Next.
+type testPortHdr struct{ testHdr }
+
+func (h *testPortHdr) Ports() (src, dst uint16) { return 1, h.Next }
+
+// Make sure registered decoders are used for the link types, EtherTypes, IP
+// protocols and ports that the package does not decode itself.
+func TestRegistry(t *testing.T) {
+	const (
+		linkType  = 147    // DLT_USER0
+		etherType = 0x88B5 // local experimental EtherType
+		proto     = 253    // for experimentation
+		port      = 7777
+	)
+	RegisterLinkType(linkType, func(b []byte) (Hdr, []byte, error) {
+		h, next, err := decodeTestHdr(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &testLinkHdr{*h.(*testHdr)}, next, nil
+	})
+	RegisterEtherType(etherType, decodeTestHdr)
+	RegisterIPProto(proto, func(b []byte) (Hdr, []byte, error) {
+		h, next, err := decodeTestHdr(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &testPortHdr{*h.(*testHdr)}, next, nil
+	})
+	RegisterPort(port, func(b []byte) (Hdr, []byte, error) {
+		if !bytes.HasPrefix(b, []byte{0, 0}) {
+			return nil, b, nil
+		}
+		return decodeTestHdr(b)
+	})
+	defer func() {
+		RegisterLinkType(linkType, nil)
+		RegisterEtherType(etherType, nil)
+		RegisterIPProto(proto, nil)
+		RegisterPort(port, nil)
+	}()
+
+	ip, err := Serialize([]byte{0x1e, 0x61, 't', 'r', 'a', 'n', 0, 0, 'a', 'p', 'p', '!'},
+		&IpHdr{SrcAddr: net.IPv4(192, 168, 1, 2), DstAddr: net.IPv4(10, 0, 0, 1), Protocol: proto, IpFields: IpFields{Ttl: 64}})
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	frame := append([]byte{0x08, 0x00, 'l', 'i', 'n', 'k'}, ip...)
+	p := decodeGuardedLink(t, frame, linkType)
+	if p.Err() != nil {
+		t.Fatalf("p.Err() = %v", p.Err())
+	}
+	if len(p.Headers) != ExtraHeaders {
+		t.Errorf("len(p.Headers) = %d, want %d", len(p.Headers), ExtraHeaders)
+	}
+	for i, want := range []string{"link", "", "tran", "app!"} {
+		if i == NetworkLayer {
+			if _, ok := p.Headers[i].(*IpHdr); !ok {
+				t.Errorf("p.Headers[%d] = %v, want the IpHdr", i, p.Headers[i])
+			}
+			continue
+		}
+		if h := p.Headers[i]; h == nil || h.String() != want {
+			t.Errorf("p.Headers[%d] = %v, want %s", i, h, want)
+		}
+	}
+
+	p = decodeGuarded(t, tagged(append(patch(tcp4EthFrame[:ethHdrLen], ethTypeOff, 0x88, 0xB5), 0, 0, 'n', 'e', 't', '!'), EtherTypeDot1Q, 0x0064))
+	if h, ok := p.Headers[NetworkLayer].(*testHdr); p.Err() != nil || !ok || h.Name != "net!" {
+		t.Errorf("p.Headers[NetworkLayer] = %v, %v, want net!", p.Headers[NetworkLayer], p.Err())
+	}
+	if len(p.Headers) != ExtraHeaders+1 || p.Headers[ApplicationLayer] != nil {
+		t.Errorf("p.Headers = %v, want a tag in the extra headers", p.Headers)
+	}
+
+	// Errors of registered decoders stop decoding like those of the others.
+	p = decodeGuardedLink(t, frame[:4], linkType)
+	if e, ok := p.Err().(*DecodeError); !ok || e.Hdr != "test" {
+		t.Errorf("p.Err() = %v, want a truncated test header", p.Err())
+	}
+}
+
+// Make sure HTTP is decoded on port 80 from the start, and that heuristic
+// decoders get the payloads that no port decoder took.
+func TestRegistryApplication(t *testing.T) {
+	request := []byte("GET /index.html HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	frame, err := Serialize(request,
+		&EthHdr{DstAddr: outerEth.DstAddr, SrcAddr: outerEth.SrcAddr},
+		&IpHdr{SrcAddr: net.IPv4(192, 168, 1, 2), DstAddr: net.IPv4(10, 0, 0, 1), IpFields: IpFields{Ttl: 64}},
+		&TcpHdr{Source: 50000, Dest: 80, Flags: TCP_PSH | TCP_ACK})
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	p := decodeGuarded(t, frame)
+	if h, ok := p.Headers[ApplicationLayer].(*HttpHdr); !ok || h.Method != "GET" || h.RequestURI != "/index.html" {
+		t.Errorf("p.Headers[ApplicationLayer] = %v, want the GET request", p.Headers[ApplicationLayer])
+	}
+
+	p = decodeGuarded(t, tcp4EthFrame)
+	if len(p.Headers) != ExtraHeaders-1 {
+		t.Errorf("len(p.Headers) = %d, want %d", len(p.Headers), ExtraHeaders-1)
+	}
+
+	// The heuristic stays registered, so it only takes what no other test
+	// sends.
+	RegisterHeuristic(func(b []byte) (Hdr, []byte, error) {
+		if !bytes.HasPrefix(b, []byte("\x7fHEUR")) {
+			return nil, b, nil
+		}
+		return &testHdr{Name: string(b[1:])}, nil, nil
+	})
+	frame, err = Serialize([]byte("\x7fHEUR"),
+		&EthHdr{DstAddr: outerEth.DstAddr, SrcAddr: outerEth.SrcAddr},
+		&IpHdr{SrcAddr: net.IPv4(192, 168, 1, 2), DstAddr: net.IPv4(10, 0, 0, 1), IpFields: IpFields{Ttl: 64}},
+		&UdpHdr{Source: 50000, Dest: 9})
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	p = decodeGuarded(t, frame)
+	if h := p.Headers[ApplicationLayer]; h == nil || h.String() != "HEUR" {
+		t.Errorf("p.Headers[ApplicationLayer] = %v, want HEUR", h)
+	}
+}
@@ -0,0 +1,472 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// Serialize errors
+var (
+	ErrSerializeUnsupported = errors.New("Header type cannot be serialized")
+	ErrSerializeTooLong     = errors.New("Header or datagram too long to serialize")
+	ErrSerializeNoIP        = errors.New("Transport header without an IP header in front")
+)
+
+// Serialize returns the wire encoding of hdrs, from the outermost header in,
+// followed by payload.  The supported headers are EthHdr, Dot1QHdr, MplsHdr,
+// ArpHdr, IpHdr, Ip6Hdr, the IPv6 extension headers, TcpHdr, UdpHdr and the
+// tunnel headers GreHdr, VxlanHdr and GeneveHdr.  TCP and UDP headers need an
+// IP header in front of them.
+//
+// Lengths and checksums are worked out from what is serialized, so TotLen,
+// PayloadLen, Ihl, Doff, Len and Check are ignored.  A zero EtherType or IPv4
+// Protocol is filled in from the header that follows, and so is the next
+// header of an IPv6 header; Ip6Hdr.Protocol and the NextHeader fields are only
+// used in front of the bare payload.  So is a zero Protocol of a GreHdr or
+// GeneveHdr, and a GRE checksum is worked out when GreChecksum is set.  The
+// bottom of stack bit of an MplsHdr is set on its last label only.  Everything
+// else is written as given.  The extension headers of an Ip6Hdr are not
+// written out; to send some, put them after the Ip6Hdr in hdrs.
+func Serialize(payload []byte, hdrs ...Hdr) ([]byte, error) {
+	b := payload
+	for i := len(hdrs) - 1; i >= 0; i-- {
+		var next Hdr
+		if i+1 < len(hdrs) {
+			next = hdrs[i+1]
+		}
+		var err error
+		switch h := hdrs[i].(type) {
+		case *EthHdr:
+			b = h.serialize(b, next)
+		case *Dot1QHdr:
+			b = h.serialize(b, next)
+		case *MplsHdr:
+			b = h.serialize(b)
+		case *ArpHdr:
+			b = h.serialize(b)
+		case *IpHdr:
+			b, err = h.serialize(b, next)
+		case *Ip6Hdr:
+			b, err = h.serialize(b, next)
+		case *Ip6OptsHdr:
+			b = h.serialize(b, next)
+		case *Ip6RoutingHdr:
+			b = h.serialize(b, next)
+		case *Ip6FragHdr:
+			b = h.serialize(b, next)
+		case *GreHdr:
+			b = h.serialize(b, next)
+		case *VxlanHdr:
+			b = h.serialize(b)
+		case *GeneveHdr:
+			b = h.serialize(b, next)
+		case *TcpHdr:
+			b, err = h.serialize(b, ipBefore(hdrs[:i]))
+		case *UdpHdr:
+			b, err = h.serialize(b, ipBefore(hdrs[:i]))
+		default:
+			err = ErrSerializeUnsupported
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// ipBefore returns the innermost IP header of hdrs, or nil.
+func ipBefore(hdrs []Hdr) InetProtoHdr {
+	for i := len(hdrs) - 1; i >= 0; i-- {
+		if ip, ok := hdrs[i].(InetProtoHdr); ok {
+			return ip
+		}
+	}
+	return nil
+}
+
+// etherTypeOf returns the EtherType that announces h, or 0.
+func etherTypeOf(h Hdr) uint16 {
+	switch h.(type) {
+	case *IpHdr:
+		return EtherTypeIPv4
+	case *Ip6Hdr:
+		return EtherTypeIPv6
+	case *ArpHdr:
+		return EtherTypeARP
+	case *Dot1QHdr:
+		return EtherTypeDot1Q
+	case *MplsHdr:
+		return EtherTypeMPLS
+	}
+	return 0
+}
+
+// tunnelEtherTypeOf returns the EtherType that announces h in a tunnel,
+// where an Ethernet frame can be carried too, or 0.
+func tunnelEtherTypeOf(h Hdr) uint16 {
+	if _, ok := h.(*EthHdr); ok {
+		return EtherTypeTEB
+	}
+	return etherTypeOf(h)
+}
+
+// ipProtoOf returns the IP protocol number that announces h, and whether
+// there is one.
+func ipProtoOf(h Hdr) (uint8, bool) {
+	switch h := h.(type) {
+	case *TcpHdr:
+		return IpProtoTCP, true
+	case *UdpHdr:
+		return IpProtoUDP, true
+	case *IcmpHdr:
+		return IpProtoICMP, true
+	case *Icmp6Hdr:
+		return IpProtoICMPv6, true
+	case *Ip6OptsHdr:
+		return h.Type, true
+	case *Ip6RoutingHdr:
+		return IpProtoRouting, true
+	case *Ip6FragHdr:
+		return IpProtoFragment, true
+	case *IpHdr:
+		return IpProtoIPIP, true
+	case *Ip6Hdr:
+		return IpProtoIPv6, true
+	case *GreHdr:
+		return IpProtoGRE, true
+	}
+	return 0, false
+}
+
+// serialize returns the header followed by b.
+func (h *EthHdr) serialize(b []byte, next Hdr) []byte {
+	hdr := make([]byte, ethHdrLen)
+	copy(hdr[0:6], h.DstAddr)
+	copy(hdr[6:12], h.SrcAddr)
+	et := h.EtherType
+	if et == 0 {
+		et = etherTypeOf(next)
+	}
+	binary.BigEndian.PutUint16(hdr[ethTypeOff:], et)
+	return append(hdr, b...)
+}
+
+// serialize returns the tag followed by b.
+func (h *Dot1QHdr) serialize(b []byte, next Hdr) []byte {
+	hdr := make([]byte, dot1QHdrLen)
+	tci := uint16(h.Priority)<<13 | h.VLANID&0x0FFF
+	if h.DEI {
+		tci |= 0x1000
+	}
+	binary.BigEndian.PutUint16(hdr, tci)
+	et := h.EtherType
+	if et == 0 {
+		et = etherTypeOf(next)
+	}
+	binary.BigEndian.PutUint16(hdr[2:], et)
+	return append(hdr, b...)
+}
+
+// serialize returns the label stack followed by b.
+func (h *MplsHdr) serialize(b []byte) []byte {
+	hdr := make([]byte, len(h.Labels)*mplsLabelLen)
+	for i, l := range h.Labels {
+		e := l.Label<<12 | uint32(l.TC&0x07)<<9 | uint32(l.TTL)
+		if i == len(h.Labels)-1 {
+			e |= 0x100
+		}
+		binary.BigEndian.PutUint32(hdr[i*mplsLabelLen:], e)
+	}
+	return append(hdr, b...)
+}
+
+// serialize returns the ARP packet followed by b.  The address lengths are
+// taken from the sender addresses.
+func (h *ArpHdr) serialize(b []byte) []byte {
+	spa, tpa := h.SenderProtoAddr, h.TargetProtoAddr
+	if s4, t4 := spa.To4(), tpa.To4(); s4 != nil && t4 != nil {
+		spa, tpa = s4, t4
+	}
+	hdr := make([]byte, arpHdrLen, arpHdrLen+2*(len(h.SenderHwAddr)+len(spa)))
+	binary.BigEndian.PutUint16(hdr, h.HwType)
+	binary.BigEndian.PutUint16(hdr[2:], h.ProtoType)
+	hdr[4], hdr[5] = uint8(len(h.SenderHwAddr)), uint8(len(spa))
+	binary.BigEndian.PutUint16(hdr[6:], h.Op)
+	hdr = append(hdr, h.SenderHwAddr...)
+	hdr = append(hdr, spa...)
+	hdr = append(hdr, make([]byte, len(h.SenderHwAddr))...)
+	copy(hdr[len(hdr)-len(h.SenderHwAddr):], h.TargetHwAddr)
+	hdr = append(hdr, make([]byte, len(spa))...)
+	copy(hdr[len(hdr)-len(spa):], tpa)
+	return append(hdr, b...)
+}
+
+// serializeIpOptions returns the options padded to a multiple of 4 bytes.
+func serializeIpOptions(opts []IpOption) []byte {
+	var b []byte
+	for _, o := range opts {
+		if o.Type == IpOptEOL || o.Type == IpOptNOP {
+			b = append(b, o.Type)
+			continue
+		}
+		b = append(b, o.Type, uint8(len(o.Data)+2))
+		b = append(b, o.Data...)
+	}
+	for len(b)%4 != 0 {
+		b = append(b, IpOptEOL)
+	}
+	return b
+}
+
+// serialize returns the header followed by the datagram payload b.
+func (h *IpHdr) serialize(b []byte, next Hdr) ([]byte, error) {
+	opts := serializeIpOptions(h.Options)
+	n := ipHdrLen + len(opts)
+	if n > 60 || n+len(b) > 0xFFFF {
+		return nil, ErrSerializeTooLong
+	}
+	hdr := make([]byte, ipHdrLen, n)
+	hdr[0] = 4<<4 | uint8(n/4)
+	hdr[ipTosOff] = h.Tos
+	binary.BigEndian.PutUint16(hdr[ipTotLenOff:], uint16(n+len(b)))
+	binary.BigEndian.PutUint16(hdr[ipIdOff:], h.Id)
+	frag := h.FragOffset / 8
+	if h.DontFragment {
+		frag |= 0x4000
+	}
+	if h.MoreFragments {
+		frag |= 0x2000
+	}
+	binary.BigEndian.PutUint16(hdr[ipFragOffOff:], frag)
+	hdr[ipTtlOff] = h.Ttl
+	hdr[ipProtocolOff] = h.Protocol
+	if p, ok := ipProtoOf(next); ok && h.Protocol == 0 {
+		hdr[ipProtocolOff] = p
+	}
+	copy(hdr[ipSrcOff:ipSrcOff+4], h.SrcAddr.To4())
+	copy(hdr[ipDstOff:ipDstOff+4], h.DstAddr.To4())
+	hdr = append(hdr, opts...)
+	binary.BigEndian.PutUint16(hdr[ipCheckOff:], ^onesSum(hdr))
+	return append(hdr, b...), nil
+}
+
+// serialize returns the header followed by b, which starts with any
+// extension headers.
+func (h *Ip6Hdr) serialize(b []byte, next Hdr) ([]byte, error) {
+	if len(b) > 0xFFFF {
+		return nil, ErrSerializeTooLong
+	}
+	hdr := make([]byte, IPV6_HEADER_LEN)
+	binary.BigEndian.PutUint32(hdr, 6<<28|uint32(h.Class)<<20|h.FlowLabel&0xFFFFF)
+	binary.BigEndian.PutUint16(hdr[ip6PlenOff:], uint16(len(b)))
+	hdr[ip6NextHdrOff] = ip6NextHeader(next, h.Protocol)
+	hdr[ip6HopLimitOff] = h.HopLimit
+	copy(hdr[ip6SrcOff:ip6SrcOff+16], h.SrcAddr.To16())
+	copy(hdr[ip6DstOff:ip6DstOff+16], h.DstAddr.To16())
+	return append(hdr, b...), nil
+}
+
+// ip6NextHeader returns the next header value of an IPv6 header
+// followed by next, falling back to nh.
+func ip6NextHeader(next Hdr, nh uint8) uint8 {
+	if p, ok := ipProtoOf(next); ok {
+		return p
+	}
+	return nh
+}
+
+// serialize returns the options header, padded to a multiple of 8 bytes,
+// followed by b.
+func (h *Ip6OptsHdr) serialize(b []byte, next Hdr) []byte {
+	hdr := append([]byte{ip6NextHeader(next, h.NextHeader), 0}, h.Options...)
+	switch pad := (8 - len(hdr)%8) % 8; pad {
+	case 0:
+	case 1:
+		hdr = append(hdr, 0) // Pad1
+	default:
+		hdr = append(hdr, 1, uint8(pad-2)) // PadN
+		hdr = append(hdr, make([]byte, pad-2)...)
+	}
+	hdr[1] = uint8(len(hdr)/8 - 1)
+	return append(hdr, b...)
+}
+
+// serialize returns the routing header, padded to a multiple of 8 bytes,
+// followed by b.
+func (h *Ip6RoutingHdr) serialize(b []byte, next Hdr) []byte {
+	hdr := append([]byte{ip6NextHeader(next, h.NextHeader), 0, h.RoutingType, h.SegmentsLeft}, h.Data...)
+	hdr = append(hdr, make([]byte, (8-len(hdr)%8)%8)...)
+	hdr[1] = uint8(len(hdr)/8 - 1)
+	return append(hdr, b...)
+}
+
+// serialize returns the fragment header followed by b.
+func (h *Ip6FragHdr) serialize(b []byte, next Hdr) []byte {
+	hdr := make([]byte, ip6ExtMinLen)
+	hdr[0] = ip6NextHeader(next, h.NextHeader)
+	offlg := h.Offset &^ 0x7
+	if h.MoreFragments {
+		offlg |= 1
+	}
+	binary.BigEndian.PutUint16(hdr[2:], offlg)
+	binary.BigEndian.PutUint32(hdr[4:], h.Id)
+	return append(hdr, b...)
+}
+
+// serialize returns the GRE header, with the optional fields its flags ask
+// for, followed by b.
+func (h *GreHdr) serialize(b []byte, next Hdr) []byte {
+	hdr := make([]byte, greHdrLen, greHdrLen+12)
+	binary.BigEndian.PutUint16(hdr, h.Flags)
+	proto := h.Protocol
+	if proto == 0 {
+		proto = tunnelEtherTypeOf(next)
+	}
+	binary.BigEndian.PutUint16(hdr[2:], proto)
+	if h.Flags&GreChecksum != 0 {
+		hdr = append(hdr, 0, 0, 0, 0)
+	}
+	if h.Flags&GreKey != 0 {
+		hdr = binary.BigEndian.AppendUint32(hdr, h.Key)
+	}
+	if h.Flags&GreSeq != 0 {
+		hdr = binary.BigEndian.AppendUint32(hdr, h.Seq)
+	}
+	hdr = append(hdr, b...)
+	if h.Flags&GreChecksum != 0 {
+		binary.BigEndian.PutUint16(hdr[greHdrLen:], ^onesSum(hdr))
+	}
+	return hdr
+}
+
+// serialize returns the VXLAN header followed by b.
+func (h *VxlanHdr) serialize(b []byte) []byte {
+	hdr := make([]byte, vxlanHdrLen)
+	hdr[0] = h.Flags
+	binary.BigEndian.PutUint32(hdr[4:], h.VNI<<8)
+	return append(hdr, b...)
+}
+
+// serialize returns the Geneve header and its options, padded to a multiple
+// of 4 bytes, followed by b.
+func (h *GeneveHdr) serialize(b []byte, next Hdr) []byte {
+	hdr := make([]byte, geneveHdrLen, geneveHdrLen+len(h.Options)+3)
+	hdr = append(hdr, h.Options...)
+	hdr = append(hdr, make([]byte, (4-len(hdr)%4)%4)...)
+	hdr[0] = uint8((len(hdr)-geneveHdrLen)/4) & 0x3F
+	if h.OAM {
+		hdr[1] |= 0x80
+	}
+	if h.Critical {
+		hdr[1] |= 0x40
+	}
+	proto := h.Protocol
+	if proto == 0 {
+		proto = tunnelEtherTypeOf(next)
+	}
+	binary.BigEndian.PutUint16(hdr[2:], proto)
+	binary.BigEndian.PutUint32(hdr[4:], h.VNI<<8)
+	return append(hdr, b...)
+}
+
+// serialize returns the options padded to a multiple of 4 bytes.
+func (o *TcpOptions) serialize() []byte {
+	var b []byte
+	if o.MSS != 0 {
+		b = append(b, TcpOptMSS, 4, uint8(o.MSS>>8), uint8(o.MSS))
+	}
+	if o.SackPermitted {
+		b = append(b, TcpOptSackPermitted, 2)
+	}
+	if o.HasTimestamps {
+		b = append(b, TcpOptTimestamps, 10)
+		b = binary.BigEndian.AppendUint32(b, o.TSval)
+		b = binary.BigEndian.AppendUint32(b, o.TSecr)
+	}
+	if o.HasWScale {
+		b = append(b, TcpOptNop, TcpOptWScale, 3, o.WScale)
+	}
+	if len(o.Sack) > 0 {
+		b = append(b, TcpOptNop, TcpOptNop, TcpOptSack, uint8(2+8*len(o.Sack)))
+		for _, blk := range o.Sack {
+			b = binary.BigEndian.AppendUint32(b, blk.Left)
+			b = binary.BigEndian.AppendUint32(b, blk.Right)
+		}
+	}
+	if o.FastOpen {
+		b = append(b, TcpOptFastOpen, uint8(2+len(o.FastOpenCookie)))
+		b = append(b, o.FastOpenCookie...)
+	}
+	for _, u := range o.Unknown {
+		b = append(b, u.Kind, uint8(2+len(u.Data)))
+		b = append(b, u.Data...)
+	}
+	for len(b)%4 != 0 {
+		b = append(b, TcpOptEnd)
+	}
+	return b
+}
+
+// pseudoAddrs returns the addresses of ip to use in a pseudo-header.
+func pseudoAddrs(ip InetProtoHdr) (net.IP, net.IP) {
+	if _, ok := ip.(*IpHdr); ok {
+		return ip.Src().To4(), ip.Dst().To4()
+	}
+	return ip.Src().To16(), ip.Dst().To16()
+}
+
+// serialize returns the header followed by the payload b.
+func (h *TcpHdr) serialize(b []byte, ip InetProtoHdr) ([]byte, error) {
+	if ip == nil {
+		return nil, ErrSerializeNoIP
+	}
+	var opts []byte
+	if h.Options != nil {
+		opts = h.Options.serialize()
+	}
+	n := tcpHdrLen + len(opts)
+	if n > 60 {
+		return nil, ErrSerializeTooLong
+	}
+	hdr := make([]byte, tcpHdrLen, n+len(b))
+	binary.BigEndian.PutUint16(hdr[tcpSourceOff:], h.Source)
+	binary.BigEndian.PutUint16(hdr[tcpDestOff:], h.Dest)
+	binary.BigEndian.PutUint32(hdr[tcpSeqOff:], h.Seq)
+	binary.BigEndian.PutUint32(hdr[tcpAckSeqOff:], h.AckSeq)
+	binary.BigEndian.PutUint16(hdr[tcpFlagsOff:], uint16(n/4)<<12|h.Flags&0x01FF)
+	binary.BigEndian.PutUint16(hdr[tcpWindowOff:], h.Window)
+	binary.BigEndian.PutUint16(hdr[tcpUrgPtrOff:], h.UrgPtr)
+	seg := append(append(hdr, opts...), b...)
+	src, dst := pseudoAddrs(ip)
+	binary.BigEndian.PutUint16(seg[tcpCheckOff:], ^fold(sum32(seg, pseudoSum(src, dst, IpProtoTCP, len(seg)))))
+	return seg, nil
+}
+
+// serialize returns the header followed by the payload b.  A checksum that
+// comes out as zero is sent as 0xFFFF, since zero means none.
+func (h *UdpHdr) serialize(b []byte, ip InetProtoHdr) ([]byte, error) {
+	if ip == nil {
+		return nil, ErrSerializeNoIP
+	}
+	n := udpHdrLen + len(b)
+	if n > 0xFFFF {
+		return nil, ErrSerializeTooLong
+	}
+	seg := make([]byte, udpHdrLen, n)
+	binary.BigEndian.PutUint16(seg, h.Source)
+	binary.BigEndian.PutUint16(seg[2:], h.Dest)
+	binary.BigEndian.PutUint16(seg[4:], uint16(n))
+	seg = append(seg, b...)
+	src, dst := pseudoAddrs(ip)
+	check := ^fold(sum32(seg, pseudoSum(src, dst, IpProtoUDP, n)))
+	if check == 0 {
+		check = 0xFFFF
+	}
+	binary.BigEndian.PutUint16(seg[6:], check)
+	return seg, nil
+}
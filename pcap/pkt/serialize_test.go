@@ -0,0 +1,105 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !appengine
+
+package pkt
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// Make sure a frame built from Go values comes out byte for byte, with the
+// lengths and checksums filled in.
+func TestSerialize(t *testing.T) {
+	b, err := Serialize([]byte("hello!"),
+		&EthHdr{
+			DstAddr: net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+			SrcAddr: net.HardwareAddr{0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb},
+		},
+		&IpHdr{
+			SrcAddr:  net.IPv4(192, 168, 1, 2),
+			DstAddr:  net.IPv4(10, 0, 0, 1),
+			IpFields: IpFields{Id: 0x1234, DontFragment: true, Ttl: 64},
+		},
+		&TcpHdr{Source: 8080, Dest: 50000, Seq: 424242, AckSeq: 313131, Flags: TCP_PSH | TCP_ACK, Window: 512})
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	want := patch(patch(tcp4EthFrame, ethHdrLen+ipCheckOff, 0x5c, 0xeb), ethHdrLen+ipHdrLen+tcpCheckOff, 0x7a, 0xdf)
+	if !bytes.Equal(b, want) {
+		t.Errorf("Serialize = % x\nwant        % x", b, want)
+	}
+
+	// The bottom of stack bit is set whatever S says.
+	ip := &IpHdr{SrcAddr: net.IPv4(192, 168, 1, 2), DstAddr: net.IPv4(10, 0, 0, 1), IpFields: IpFields{Id: 0x1234, DontFragment: true, Ttl: 64}}
+	tcp := &TcpHdr{Source: 8080, Dest: 50000, Seq: 424242, AckSeq: 313131, Flags: TCP_PSH | TCP_ACK, Window: 512}
+	eth := &EthHdr{DstAddr: net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}, SrcAddr: net.HardwareAddr{0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb}}
+	mpls := &MplsHdr{Labels: []MplsLabel{{Label: 16, TTL: 64}, {Label: 17, TTL: 64}}}
+	b, err = Serialize([]byte("hello!"), eth, mpls, ip, tcp)
+	if err != nil {
+		t.Fatalf("Serialize(mpls): %v", err)
+	}
+	if want := withMpls(want, 16, 17); !bytes.Equal(b, want) {
+		t.Errorf("Serialize(mpls) = % x\nwant              % x", b, want)
+	}
+
+	if _, err := Serialize(nil, &UdpHdr{}); err != ErrSerializeNoIP {
+		t.Errorf("Serialize(udp) = %v, want ErrSerializeNoIP", err)
+	}
+	if _, err := Serialize(nil, &IcmpHdr{}); err != ErrSerializeUnsupported {
+		t.Errorf("Serialize(icmp) = %v, want ErrSerializeUnsupported", err)
+	}
+}
+
+// Make sure decoding and serializing again gives back the same packet, from
+// the network layer down.  The checksums of the test frames are left zero,
+// so they are zeroed in the result before comparing.
+func TestSerializeRoundTrip(t *testing.T) {
+	udp := patch(patch(tcp4EthFrame, ethHdrLen+ipProtocolOff, IpProtoUDP), ethHdrLen+ipHdrLen+4, 0, 26, 0, 0)
+	for name, frame := range map[string][]byte{
+		"ipv4":    tcp4EthFrame,
+		"ipv6":    tcp6EthFrame,
+		"udp":     udp,
+		"ip opts": withIpOpts(tcp4EthFrame, IpOptRouterAlert, 4, 0, 0),
+		"ip6 ext": withIp6Ext(tcp6EthFrame, ethHdrLen, hopByHop, dstOpts, firstFrag),
+		"arp":     arpEthFrame[:ethHdrLen+arpHdrLen+20],
+	} {
+		data, done := guarded(t, frame)
+		p := DecodePacket(data, LinkTypeEthernet)
+		hdrs := []Hdr{p.Headers[NetworkLayer]}
+		if len(p.Headers) > ExtraHeaders {
+			hdrs = append(hdrs, p.Headers[ExtraHeaders:]...)
+		}
+		var payload []byte
+		check := -1 // offset of the transport checksum from the end of its header
+		switch h := p.Headers[TransportLayer].(type) {
+		case *TcpHdr:
+			hdrs = append(hdrs, h)
+			payload = append([]byte(nil), h.GetPayloadBytes(hdrs[0].(InetProtoHdr).PL())...)
+			check = int(h.Doff)*4 - tcpCheckOff
+		case *UdpHdr:
+			hdrs = append(hdrs, h)
+			payload = append([]byte(nil), h.GetPayloadBytes(hdrs[0].(InetProtoHdr).PL())...)
+			check = udpHdrLen - 6
+		}
+		done()
+		b, err := Serialize(payload, hdrs...)
+		if err != nil {
+			t.Errorf("%s: Serialize: %v", name, err)
+			continue
+		}
+		if _, ok := hdrs[0].(*IpHdr); ok {
+			b = patch(b, ipCheckOff, 0, 0)
+		}
+		if check >= 0 {
+			b = patch(b, len(b)-len(payload)-check, 0, 0)
+		}
+		if want := frame[ethHdrLen:]; !bytes.Equal(b, want) {
+			t.Errorf("%s: Serialize = % x\nwant          % x", name, b, want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// These are the values of the packet type of the Linux "cooked" headers, from
+// <linux/if_packet.h>.  They say where the packet was headed.
+const (
+	SllHost      = 0 // to us
+	SllBroadcast = 1 // to all
+	SllMulticast = 2 // to a group
+	SllOtherHost = 3 // to someone else, seen in promiscuous mode
+	SllOutgoing  = 4 // sent by us
+)
+
+var sllPacketTypes = [...]string{
+	"host",
+	"broadcast",
+	"multicast",
+	"otherhost",
+	"outgoing",
+}
+
+// sllPacketType returns the name of a packet type of the cooked headers.
+func sllPacketType(t int) string {
+	if t >= 0 && t < len(sllPacketTypes) {
+		return sllPacketTypes[t]
+	}
+	return fmt.Sprintf("type-%d", t)
+}
+
+// sllAddr returns a copy of the link-layer address of a cooked header, whose
+// address field holds up to 8 bytes.
+func sllAddr(b []byte, n int) net.HardwareAddr {
+	if n > 8 {
+		n = 8
+	}
+	return net.HardwareAddr(append([]byte(nil), b[:n]...))
+}
+
+// The SllHdr struct is the sll_header of the DLT_LINUX_SLL link-layer type,
+// which libpcap uses for captures on the Linux "any" device.
+type SllHdr struct {
+	PacketType uint16           // where the packet was headed, e.g. SllHost
+	ArpHrd     uint16           // ARPHRD_ type of the link-layer address
+	Addr       net.HardwareAddr // link-layer address of the sender
+	Protocol   uint16           // EtherType of the payload
+}
+
+// NewSllHdr returns the DLT_LINUX_SLL header at the start of b along with the
+// bytes that follow it.
+func NewSllHdr(b []byte) (*SllHdr, []byte, error) {
+	if len(b) < sllHdrLen {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "sll_header", Value: sllHdrLen, Caplen: len(b)}
+	}
+	h := &SllHdr{
+		PacketType: binary.BigEndian.Uint16(b),
+		ArpHrd:     binary.BigEndian.Uint16(b[2:]),
+		Addr:       sllAddr(b[6:], int(binary.BigEndian.Uint16(b[4:]))),
+		Protocol:   binary.BigEndian.Uint16(b[sllProtocolOff:]),
+	}
+	return h, b[sllHdrLen:], nil
+}
+
+// JsonElement returns a JSON encoding of the SllHdr struct.
+func (h *SllHdr) JsonElement() string {
+	return fmt.Sprintf("\"sll_header\":{\"sll_pkttype\":%d,\"sll_hatype\":%d,\"sll_addr\":\"%s\",\"sll_protocol\":%d}",
+		h.PacketType,
+		h.ArpHrd,
+		h.Addr.String(),
+		h.Protocol)
+}
+
+// CsvElement returns a CSV encoding of the SllHdr struct.
+// The string "SLL" signifies the beginning of the SllHdr.
+func (h *SllHdr) CsvElement() string {
+	return fmt.Sprintf("\"SLL\",%d,%d,\"%s\",%d",
+		h.PacketType,
+		h.ArpHrd,
+		h.Addr.String(),
+		h.Protocol)
+}
+
+// String returns a minimal encoding of the SllHdr struct.
+func (h *SllHdr) String() string {
+	return fmt.Sprintf("%s %s %#x",
+		sllPacketType(int(h.PacketType)),
+		h.Addr.String(),
+		h.Protocol)
+}
+
+// The Sll2Hdr struct is the sll2_header of the DLT_LINUX_SLL2 link-layer
+// type, which unlike DLT_LINUX_SLL says which interface the packet was
+// captured on.
+type Sll2Hdr struct {
+	Protocol   uint16           // EtherType of the payload
+	IfIndex    uint32           // index of the interface the packet was captured on
+	ArpHrd     uint16           // ARPHRD_ type of the link-layer address
+	PacketType uint8            // where the packet was headed, e.g. SllHost
+	Addr       net.HardwareAddr // link-layer address of the sender
+}
+
+// NewSll2Hdr returns the DLT_LINUX_SLL2 header at the start of b along with
+// the bytes that follow it.
+func NewSll2Hdr(b []byte) (*Sll2Hdr, []byte, error) {
+	if len(b) < sll2HdrLen {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "sll2_header", Value: sll2HdrLen, Caplen: len(b)}
+	}
+	h := &Sll2Hdr{
+		Protocol:   binary.BigEndian.Uint16(b),
+		IfIndex:    binary.BigEndian.Uint32(b[4:]),
+		ArpHrd:     binary.BigEndian.Uint16(b[8:]),
+		PacketType: b[10],
+		Addr:       sllAddr(b[12:], int(b[11])),
+	}
+	return h, b[sll2HdrLen:], nil
+}
+
+// ifNames caches the interface names IfName has looked up, by index.
+var ifNames sync.Map
+
+// IfName returns the name of the interface the packet was captured on, or ""
+// if there is no interface with that index.  The name is looked up on this
+// host, and only once for each index, so it is only right for a live capture
+// on interfaces that have not been renamed since.
+func (h *Sll2Hdr) IfName() string {
+	if name, ok := ifNames.Load(h.IfIndex); ok {
+		return name.(string)
+	}
+	var name string
+	if ifi, err := net.InterfaceByIndex(int(h.IfIndex)); err == nil {
+		name = ifi.Name
+	}
+	ifNames.Store(h.IfIndex, name)
+	return name
+}
+
+// JsonElement returns a JSON encoding of the Sll2Hdr struct.
+func (h *Sll2Hdr) JsonElement() string {
+	return fmt.Sprintf("\"sll2_header\":{\"sll2_protocol\":%d,\"sll2_if_index\":%d,\"sll2_hatype\":%d,\"sll2_pkttype\":%d,\"sll2_addr\":\"%s\"}",
+		h.Protocol,
+		h.IfIndex,
+		h.ArpHrd,
+		h.PacketType,
+		h.Addr.String())
+}
+
+// CsvElement returns a CSV encoding of the Sll2Hdr struct.
+// The string "SLL2" signifies the beginning of the Sll2Hdr.
+func (h *Sll2Hdr) CsvElement() string {
+	return fmt.Sprintf("\"SLL2\",%d,%d,%d,%d,\"%s\"",
+		h.Protocol,
+		h.IfIndex,
+		h.ArpHrd,
+		h.PacketType,
+		h.Addr.String())
+}
+
+// String returns a minimal encoding of the Sll2Hdr struct.
+func (h *Sll2Hdr) String() string {
+	return fmt.Sprintf("if %d %s %s %#x",
+		h.IfIndex,
+		sllPacketType(int(h.PacketType)),
+		h.Addr.String(),
+		h.Protocol)
+}
+
+// Interface returns the index and name of the interface the Packet was
+// captured on, which only the DLT_LINUX_SLL2 link-layer header tells.  ok is
+// false for a Packet of any other link-layer type.  See Sll2Hdr.IfName for
+// where the name comes from.
+func (p *Packet) Interface() (index int, name string, ok bool) {
+	h, ok := p.Headers[LinkLayer].(*Sll2Hdr)
+	if !ok {
+		return 0, "", false
+	}
+	return int(h.IfIndex), h.IfName(), true
+}
@@ -5,6 +5,7 @@
 package pkt
 
 import (
+	"encoding/binary"
 	"fmt"
 )
 
@@ -23,9 +24,72 @@ const (
 	TCP_NS   = uint16(0x0100) // ECN-nonce concealment protection
 )
 
+// The TcpHdr struct is the tcphdr struct in <netinet/tcp.h>.
+type TcpHdr struct {
+	Source  uint16      // source port
+	Dest    uint16      // destination port
+	Seq     uint32      // sequence number
+	AckSeq  uint32      // acknowledgement number
+	Doff    uint8       // The length of the TCP header (data offset) in 32 bit words.
+	Flags   uint16      // TCP flags per RFC 793, September, 1981
+	Window  uint16      // window advertisement
+	Check   uint16      // checksum
+	UrgPtr  uint16      // urgent pointer
+	Options *TcpOptions // options, nil if there are none
+	raw     []byte      // the header and the rest of the capture
+}
+
+// NewTcpHdr returns the TCP header at the start of b along with the bytes
+// that follow it.
+func NewTcpHdr(b []byte) (*TcpHdr, []byte, error) {
+	if len(b) < tcpHdrLen {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "tcphdr", Value: tcpHdrLen, Caplen: len(b)}
+	}
+	flags := binary.BigEndian.Uint16(b[tcpFlagsOff:])
+	tcpHead := &TcpHdr{
+		Doff: uint8(flags >> 12),
+	}
+	n := int(tcpHead.Doff) * 4
+	if tcpHead.Doff < 5 {
+		return nil, nil, &DecodeError{Reason: DecodeBadHeaderLength, Hdr: "tcphdr", Field: "doff", Value: int(tcpHead.Doff), Caplen: len(b)}
+	}
+	if n > len(b) {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "tcphdr", Value: n, Caplen: len(b)}
+	}
+	tcpHead.Source = binary.BigEndian.Uint16(b[tcpSourceOff:])
+	tcpHead.Dest = binary.BigEndian.Uint16(b[tcpDestOff:])
+	tcpHead.Seq = binary.BigEndian.Uint32(b[tcpSeqOff:])
+	tcpHead.AckSeq = binary.BigEndian.Uint32(b[tcpAckSeqOff:])
+	tcpHead.Flags = flags & uint16(0x01FF)
+	tcpHead.Window = binary.BigEndian.Uint16(b[tcpWindowOff:])
+	tcpHead.Check = binary.BigEndian.Uint16(b[tcpCheckOff:])
+	tcpHead.UrgPtr = binary.BigEndian.Uint16(b[tcpUrgPtrOff:])
+	if n > tcpHdrLen {
+		tcpHead.Options = NewTcpOptions(b[tcpHdrLen:n])
+	}
+	tcpHead.raw = b
+	return tcpHead, b[n:], nil
+}
+
+// Ports returns the source and destination ports, which makes a TcpHdr a
+// PortHdr.
+func (h *TcpHdr) Ports() (src, dst uint16) {
+	return h.Source, h.Dest
+}
+
 // PayloadLen returns the length of the TCP packet's payload in bytes.
 func (h *TcpHdr) PayloadLen(pl uint16) uint16 {
-	return pl - uint16(h.Doff*4)
+	if pl < uint16(h.Doff)*4 {
+		return 0
+	}
+	return pl - uint16(h.Doff)*4
+}
+
+// GetPayloadBytes returns the bytes from the packet's payload.  This is a Go
+// slice backed by the captured bytes, which are not copied.  Only the payload
+// bytes that were captured are returned, even when the IP header claims more.
+func (h *TcpHdr) GetPayloadBytes(pl uint16) []byte {
+	return payloadBytes(h.raw, int(h.Doff)*4, int(h.PayloadLen(pl)))
 }
 
 // JsonElement returns a JSON encoding of the TcpHdr struct.
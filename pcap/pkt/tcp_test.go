@@ -2,20 +2,51 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// +build darwin freebsd
-
 package pkt
 
-// See the testHelp.go file for the C data and the Go equivalents.
-
 import (
 	"testing"
 )
 
-// Make sure that we can properly parse a C TCP header.
+// Our test TCP header, as it is on the wire.
+var tcpTestHeader = []byte{
+	0x00, 0x51, // source
+	0x00, 0x52, // dest
+	0x00, 0x06, 0x79, 0x32, // seq
+	0x00, 0x04, 0xc7, 0x2b, // ack_seq
+	0x50, 0x02, // doff and flags
+	0x02, 0x00, // window
+	0x27, 0x0f, // check
+	0x03, 0x09, // urg_ptr
+}
+
+// Test values to match the header above.
+var goTcpTestHeader = &TcpHdr{
+	Source: 81,
+	Dest:   82,
+	Seq:    424242,
+	AckSeq: 313131,
+	Doff:   5,
+	Flags:  2,
+	Window: 512,
+	Check:  9999,
+	UrgPtr: 777,
+}
+
+// Test values that should match the output for the above header.
+const (
+	goTcpJsonString = `"tcphdr":{"source":81,"dest":82,"seq":424242,"ack_seq":313131,"flags":2}`
+	goTcpCsvString  = `"TCP",81,82,424242,313131,2`
+	goTcpString     = "81->82 424242 313131 0x2"
+)
+
+// Make sure that we can properly parse a TCP header.
 func TestNewTcpHdr(t *testing.T) {
 	g := goTcpTestHeader
-	c, _ := NewTcpHdr(cTcpTestHeader)
+	c, _, err := NewTcpHdr(tcpTestHeader)
+	if err != nil {
+		t.Fatalf("NewTcpHdr: %v", err)
+	}
 	if c.Source != g.Source {
 		t.Errorf("c.Source (%d) != g.Source (%d)", c.Source, g.Source)
 	}
@@ -0,0 +1,186 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// TCP option kinds, from the IANA "TCP Option Kind Numbers" registry.
+const (
+	TcpOptEnd           = uint8(0)   // End of Option List
+	TcpOptNop           = uint8(1)   // No-Operation
+	TcpOptMSS           = uint8(2)   // Maximum Segment Size (RFC 9293)
+	TcpOptWScale        = uint8(3)   // Window Scale (RFC 7323)
+	TcpOptSackPermitted = uint8(4)   // SACK Permitted (RFC 2018)
+	TcpOptSack          = uint8(5)   // SACK (RFC 2018)
+	TcpOptTimestamps    = uint8(8)   // Timestamps (RFC 7323)
+	TcpOptFastOpen      = uint8(34)  // TCP Fast Open Cookie (RFC 7413)
+	TcpOptExperimental  = uint8(254) // RFC 6994 experiment, used by early TFO
+)
+
+// tcpOptFastOpenMagic is the experiment ID of TCP Fast Open in a
+// TcpOptExperimental option.
+const tcpOptFastOpenMagic = 0xF989
+
+// A TcpSackBlock is one block of a SACK option: the sequence numbers of the
+// first byte received and of the byte after the last one.
+type TcpSackBlock struct {
+	Left  uint32 // left edge
+	Right uint32 // right edge
+}
+
+// A TcpOption is an option we do not decode, as found on the wire.
+type TcpOption struct {
+	Kind uint8  // option kind
+	Data []byte // the bytes following the kind and length
+}
+
+// TcpOptions holds the options of a TCP header.  An option that is absent
+// leaves its fields zero, hence the flags for those whose zero value means
+// something.
+type TcpOptions struct {
+	MSS            uint16         // maximum segment size, 0 if absent
+	HasWScale      bool           // window scale option present
+	WScale         uint8          // window scale shift count
+	SackPermitted  bool           // SACK permitted option present
+	Sack           []TcpSackBlock // SACK blocks
+	HasTimestamps  bool           // timestamps option present
+	TSval          uint32         // timestamp value
+	TSecr          uint32         // timestamp echo reply
+	FastOpen       bool           // TCP Fast Open option present
+	FastOpenCookie []byte         // TFO cookie, empty on a cookie request
+	Unknown        []TcpOption    // any other option
+	Malformed      bool           // an option had a bad length; decoding stopped there
+}
+
+// NewTcpOptions decodes the options in b, the part of a TCP header past the
+// fixed 20 bytes.  Nothing in b is kept, so b can be C memory.
+func NewTcpOptions(b []byte) *TcpOptions {
+	o := &TcpOptions{}
+	for len(b) > 0 {
+		kind := b[0]
+		if kind == TcpOptEnd {
+			break
+		}
+		if kind == TcpOptNop {
+			b = b[1:]
+			continue
+		}
+		if len(b) < 2 || int(b[1]) < 2 || int(b[1]) > len(b) {
+			o.Malformed = true
+			break
+		}
+		data := b[2:b[1]]
+		b = b[b[1]:]
+		if !o.add(kind, data) {
+			o.Malformed = true
+			break
+		}
+	}
+	return o
+}
+
+// add records the option of the given kind and reports whether its length
+// was right for the kind.
+func (o *TcpOptions) add(kind uint8, data []byte) bool {
+	switch kind {
+	case TcpOptMSS:
+		if len(data) != 2 {
+			return false
+		}
+		o.MSS = binary.BigEndian.Uint16(data)
+	case TcpOptWScale:
+		if len(data) != 1 {
+			return false
+		}
+		o.HasWScale, o.WScale = true, data[0]
+	case TcpOptSackPermitted:
+		if len(data) != 0 {
+			return false
+		}
+		o.SackPermitted = true
+	case TcpOptSack:
+		if len(data) == 0 || len(data)%8 != 0 {
+			return false
+		}
+		for ; len(data) > 0; data = data[8:] {
+			o.Sack = append(o.Sack, TcpSackBlock{
+				Left:  binary.BigEndian.Uint32(data),
+				Right: binary.BigEndian.Uint32(data[4:]),
+			})
+		}
+	case TcpOptTimestamps:
+		if len(data) != 8 {
+			return false
+		}
+		o.HasTimestamps = true
+		o.TSval = binary.BigEndian.Uint32(data)
+		o.TSecr = binary.BigEndian.Uint32(data[4:])
+	case TcpOptFastOpen:
+		o.FastOpen = true
+		o.FastOpenCookie = append([]byte{}, data...)
+	default:
+		if kind == TcpOptExperimental && len(data) >= 2 && binary.BigEndian.Uint16(data) == tcpOptFastOpenMagic {
+			o.FastOpen = true
+			o.FastOpenCookie = append([]byte{}, data[2:]...)
+			break
+		}
+		o.Unknown = append(o.Unknown, TcpOption{Kind: kind, Data: append([]byte(nil), data...)})
+	}
+	return true
+}
+
+// WindowShift returns the shift count to apply to the window field of the
+// segments that follow, or 0 if the option is absent.  Per RFC 7323 it only
+// counts on a SYN, and shifts above 14 are taken as 14.
+func (o *TcpOptions) WindowShift() uint {
+	if !o.HasWScale {
+		return 0
+	}
+	if o.WScale > 14 {
+		return 14
+	}
+	return uint(o.WScale)
+}
+
+// String returns the options the way tcpdump shows them, though in a fixed
+// order rather than the order on the wire.
+func (o *TcpOptions) String() string {
+	var s []string
+	if o.MSS != 0 {
+		s = append(s, fmt.Sprintf("mss %d", o.MSS))
+	}
+	if o.SackPermitted {
+		s = append(s, "sackOK")
+	}
+	if o.HasTimestamps {
+		s = append(s, fmt.Sprintf("TS val %d ecr %d", o.TSval, o.TSecr))
+	}
+	if o.HasWScale {
+		s = append(s, fmt.Sprintf("wscale %d", o.WScale))
+	}
+	if len(o.Sack) > 0 {
+		b := make([]string, len(o.Sack))
+		for i, blk := range o.Sack {
+			b[i] = fmt.Sprintf("{%d:%d}", blk.Left, blk.Right)
+		}
+		s = append(s, fmt.Sprintf("sack %d %s", len(o.Sack), strings.Join(b, "")))
+	}
+	if o.FastOpen && len(o.FastOpenCookie) == 0 {
+		s = append(s, "tfo cookiereq")
+	} else if o.FastOpen {
+		s = append(s, fmt.Sprintf("tfo cookie %x", o.FastOpenCookie))
+	}
+	for _, u := range o.Unknown {
+		s = append(s, fmt.Sprintf("unknown-%d %x", u.Kind, u.Data))
+	}
+	if o.Malformed {
+		s = append(s, "[bad opt]")
+	}
+	return "[" + strings.Join(s, ",") + "]"
+}
@@ -0,0 +1,347 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"time"
+)
+
+type TcpPacket struct {
+	DstAddr0  uint32 // IPv4 uses only this one, others are 0
+	DstAddr1  uint32
+	DstAddr2  uint32
+	DstAddr3  uint32
+	SrcAddr0  uint32 // IPv4 uses only this one, others are 0
+	SrcAddr1  uint32
+	SrcAddr2  uint32
+	SrcAddr3  uint32
+	AckSeq    uint32
+	Seq       uint32
+	Source    uint16
+	Dest      uint16
+	Flags     uint16
+	Window    uint16 // window advertisement
+	TTL       uint8  // IPv4 time to live, or IPv6 hop limit
+	IpId      uint16 // IPv4 identification, 0 for IPv6
+	DSCP      uint8  // differentiated services code point
+	IpHdrLen  uint16 // length of the IP header, IPv6 extension headers included
+	TcpHdrLen uint16 // length of the TCP header, options included
+	OuterVlan uint16 // VLAN ID of the outer 802.1Q tag, 0 if untagged
+	InnerVlan uint16 // VLAN ID of the inner tag of a QinQ frame, 0 if none
+	Payload   []byte
+	Timestamp time.Time
+	IsRequest bool
+	Saved     bool
+	ipv6      bool        // set by the decoders, whatever the address words hold
+	options   []byte      // TCP option bytes, mapped like Payload
+	err       DecodeError // why the last decode into this packet failed
+}
+
+func (this *TcpPacket) IsIPv4() bool {
+	return !this.ipv6 && this.DstAddr1 == 0 && this.DstAddr2 == 0 && this.DstAddr3 == 0
+}
+
+// SrcAddr returns the source address of the packet.
+func (this *TcpPacket) SrcAddr() netip.Addr {
+	return wordsAddr(this.IsIPv4(), this.SrcAddr0, this.SrcAddr1, this.SrcAddr2, this.SrcAddr3)
+}
+
+// DstAddr returns the destination address of the packet.
+func (this *TcpPacket) DstAddr() netip.Addr {
+	return wordsAddr(this.IsIPv4(), this.DstAddr0, this.DstAddr1, this.DstAddr2, this.DstAddr3)
+}
+
+// SrcAddrPort returns the source address and port of the packet.
+func (this *TcpPacket) SrcAddrPort() netip.AddrPort {
+	return netip.AddrPortFrom(this.SrcAddr(), this.Source)
+}
+
+// DstAddrPort returns the destination address and port of the packet.
+func (this *TcpPacket) DstAddrPort() netip.AddrPort {
+	return netip.AddrPortFrom(this.DstAddr(), this.Dest)
+}
+
+// FlowKey returns the FlowKey of the packet.
+func (this *TcpPacket) FlowKey() FlowKey {
+	return FlowKey{Src: this.SrcAddrPort(), Dst: this.DstAddrPort(), Proto: IpProtoTCP}
+}
+
+func (this *TcpPacket) Save() {
+	if !this.Saved {
+		var dcopy = make([]byte, len(this.Payload))
+		copy(dcopy, this.Payload)
+		this.Payload = dcopy
+		this.options = append([]byte(nil), this.options...)
+		this.Saved = true
+	}
+}
+
+func (this *TcpPacket) Clone() *TcpPacket {
+	dupe := *this
+	if !this.Saved {
+		dupe.Payload = append([]byte{}, this.Payload...)
+		dupe.options = append([]byte(nil), this.options...)
+		dupe.Saved = true
+	}
+	return &dupe
+}
+
+// Options decodes the TCP options of the packet.  Decoding is left until it
+// is asked for, so that NewPacketAllocless stays free of allocations.  Like
+// the Payload, the option bytes are mapped into the capture buffer until
+// Save() is called.
+func (this *TcpPacket) Options() *TcpOptions {
+	return NewTcpOptions(this.options)
+}
+
+// fail records e in the TcpPacket and returns it as an error.
+func (this *TcpPacket) fail(e DecodeError) error {
+	this.err = e
+	return &this.err
+}
+
+// inetPacket is what the alloc-less decoders keep of the link-layer and IP
+// headers of a packet.
+type inetPacket struct {
+	dst, src             [4]uint32 // IPv4 uses only the first word
+	outerVlan, innerVlan uint16
+	ipv6                 bool
+	ttl                  uint8  // IPv4 TTL or IPv6 hop limit
+	id                   uint16 // IPv4 identification
+	dscp                 uint8
+	hdrLen               int   // IP header length, extension headers included
+	proto                uint8 // upper-layer protocol
+	paylen               int   // upper-layer length, from the IP header
+}
+
+// decodeInet decodes the link-layer and IP headers of the captured bytes b
+// into h, and returns the bytes that follow them.  Every multi-byte field is
+// read in network byte order through encoding/binary, so the result is the
+// same on big and little-endian hosts.  Every length field is checked against
+// len(b) before it is used.  The DecodeError is returned by value so that
+// failures do not allocate; ok is false if there is one.
+func decodeInet(b []byte, datalinkType int32, h *inetPacket) (rest []byte, e DecodeError, ok bool) {
+	var etherType uint16
+
+	switch datalinkType {
+	case LinkTypeLinuxSLL:
+		// unwrap cooked packet
+		if len(b) < sllHdrLen {
+			return nil, DecodeError{Reason: DecodeTruncated, Hdr: "sll_header", Value: sllHdrLen, Caplen: len(b)}, false
+		}
+		etherType = binary.BigEndian.Uint16(b[sllProtocolOff:])
+		b = b[sllHdrLen:]
+	case LinkTypeLinuxSLL2:
+		if len(b) < sll2HdrLen {
+			return nil, DecodeError{Reason: DecodeTruncated, Hdr: "sll2_header", Value: sll2HdrLen, Caplen: len(b)}, false
+		}
+		etherType = binary.BigEndian.Uint16(b)
+		b = b[sll2HdrLen:]
+	case LinkTypeEthernet:
+		// unwrap ethernet packet
+		if len(b) < ethHdrLen {
+			return nil, DecodeError{Reason: DecodeTruncated, Hdr: "ether_header", Value: ethHdrLen, Caplen: len(b)}, false
+		}
+		etherType = binary.BigEndian.Uint16(b[ethTypeOff:])
+		b = b[ethHdrLen:]
+	case LinkTypeNull, LinkTypeLoop: // BSD Loopback
+		if len(b) < bsdLoHdrLen {
+			return nil, DecodeError{Reason: DecodeTruncated, Hdr: "bsd_loopback", Value: bsdLoHdrLen, Caplen: len(b)}, false
+		}
+		if etherType = loEtherType(bsdLoFamily(b)); etherType == 0 {
+			return nil, DecodeError{Reason: DecodeUnsupportedEtherType, Hdr: "bsd_loopback", Value: int(bsdLoFamily(b))}, false
+		}
+		b = b[bsdLoHdrLen:]
+	case LinkTypeRaw, LinkTypeIPv4, LinkTypeIPv6:
+		if etherType = rawEtherType(datalinkType, b); etherType == 0 {
+			return nil, rawError(b), false
+		}
+	default:
+		return nil, DecodeError{Reason: DecodeUnsupportedLink, Value: int(datalinkType)}, false
+	}
+
+	// skip 802.1Q tags, keeping the outermost and innermost VLAN IDs
+	h.outerVlan = 0
+	h.innerVlan = 0
+	for tags := 0; isVlanTag(etherType); tags++ {
+		if len(b) < dot1QHdrLen {
+			return nil, DecodeError{Reason: DecodeTruncated, Hdr: "vlan_tag", Value: dot1QHdrLen, Caplen: len(b)}, false
+		}
+		vid := binary.BigEndian.Uint16(b) & 0x0FFF
+		if tags == 0 {
+			h.outerVlan = vid
+		} else {
+			h.innerVlan = vid
+		}
+		etherType = binary.BigEndian.Uint16(b[2:])
+		b = b[dot1QHdrLen:]
+	}
+
+	// skip an MPLS label stack, guessing the IP version of the payload
+	if isMpls(etherType) {
+		n := mplsStackLen(b)
+		if n < 0 {
+			return nil, DecodeError{Reason: DecodeTruncated, Hdr: "mpls", Value: len(b)/mplsLabelLen*mplsLabelLen + mplsLabelLen, Caplen: len(b)}, false
+		}
+		b = b[n:]
+		if etherType = rawEtherType(LinkTypeRaw, b); etherType == 0 {
+			e := rawError(b)
+			e.Hdr = "mpls"
+			return nil, e, false
+		}
+	}
+
+	switch etherType {
+	case EtherTypeIPv4:
+		// unwrap ip packet
+		if len(b) < ipHdrLen {
+			return nil, DecodeError{Reason: DecodeTruncated, Hdr: "iphdr", Value: ipHdrLen, Caplen: len(b)}, false
+		}
+		iphdrlen := int(b[0]&0x0F) * 4
+		if iphdrlen < ipHdrLen {
+			return nil, DecodeError{Reason: DecodeBadHeaderLength, Hdr: "iphdr", Field: "ihl", Value: iphdrlen / 4, Caplen: len(b)}, false
+		}
+		if iphdrlen > len(b) {
+			return nil, DecodeError{Reason: DecodeTruncated, Hdr: "iphdr", Value: iphdrlen, Caplen: len(b)}, false
+		}
+		totlen := int(binary.BigEndian.Uint16(b[ipTotLenOff:]))
+		if totlen < iphdrlen {
+			return nil, DecodeError{Reason: DecodeBadHeaderLength, Hdr: "iphdr", Field: "tot_len", Value: totlen, Caplen: len(b)}, false
+		}
+		if off := int(binary.BigEndian.Uint16(b[ipFragOffOff:])&0x1FFF) * 8; off != 0 {
+			return nil, DecodeError{Reason: DecodeFragment, Hdr: "iphdr", Value: off}, false
+		}
+
+		// The address words keep the bytes in network order, exactly as
+		// they are laid out on the wire, leaving any re-ordering to the
+		// consumer.
+		h.ipv6 = false
+		h.dst = [4]uint32{binary.NativeEndian.Uint32(b[ipDstOff:])}
+		h.src = [4]uint32{binary.NativeEndian.Uint32(b[ipSrcOff:])}
+		h.ttl = b[ipTtlOff]
+		h.id = binary.BigEndian.Uint16(b[ipIdOff:])
+		h.dscp = b[ipTosOff] >> 2
+		h.hdrLen = iphdrlen
+		h.proto = b[ipProtocolOff]
+		h.paylen = totlen - iphdrlen
+		return b[iphdrlen:], DecodeError{}, true
+	case EtherTypeIPv6:
+		// unwrap IPv6 packet
+		if len(b) < IPV6_HEADER_LEN {
+			return nil, DecodeError{Reason: DecodeTruncated, Hdr: "ip6hdr", Value: IPV6_HEADER_LEN, Caplen: len(b)}, false
+		}
+		// verify version
+		if b[0]>>4 != 6 {
+			return nil, DecodeError{Reason: DecodeBadVersion, Hdr: "ip6hdr", Value: int(b[0] >> 4)}, false
+		}
+		h.ipv6 = true
+		for i := range h.src {
+			h.src[i] = binary.NativeEndian.Uint32(b[ip6SrcOff+4*i:])
+			h.dst[i] = binary.NativeEndian.Uint32(b[ip6DstOff+4*i:])
+		}
+		h.ttl = b[ip6HopLimitOff]
+		h.id = 0
+		h.dscp = uint8(binary.BigEndian.Uint32(b)>>20) >> 2
+		h.hdrLen = IPV6_HEADER_LEN
+		paylen := int(binary.BigEndian.Uint16(b[ip6PlenOff:]))
+		proto := b[ip6NextHdrOff]
+		b = b[IPV6_HEADER_LEN:]
+
+		// walk the extension headers to the upper-layer header
+		for isIp6Ext(proto) {
+			if len(b) < ip6ExtMinLen {
+				return nil, DecodeError{Reason: DecodeTruncated, Hdr: ip6ExtName(proto), Value: ip6ExtMinLen, Caplen: len(b)}, false
+			}
+			n := ip6ExtLen(proto, b)
+			if n > len(b) {
+				return nil, DecodeError{Reason: DecodeTruncated, Hdr: ip6ExtName(proto), Value: n, Caplen: len(b)}, false
+			}
+			if n > paylen {
+				return nil, DecodeError{Reason: DecodeBadHeaderLength, Hdr: "ip6hdr", Field: "plen", Value: paylen, Caplen: len(b)}, false
+			}
+			if proto == IpProtoFragment {
+				if off := int(binary.BigEndian.Uint16(b[2:]) &^ 0x7); off != 0 {
+					return nil, DecodeError{Reason: DecodeFragment, Hdr: "ip6_frag", Value: off}, false
+				}
+			}
+			proto = b[0]
+			paylen -= n
+			h.hdrLen += n
+			b = b[n:]
+		}
+		h.proto = proto
+		h.paylen = paylen
+		return b, DecodeError{}, true
+	}
+	return nil, DecodeError{Reason: DecodeUnsupportedEtherType, Hdr: "ether_header", Value: int(etherType)}, false
+}
+
+// ipHdrName returns the name of the IP header in front of the upper-layer
+// header of h, for the DecodeErrors about the upper layer.
+func (h *inetPacket) ipHdrName() string {
+	if h.ipv6 {
+		return "ip6hdr"
+	}
+	return "iphdr"
+}
+
+// decodeTcpPacket does the work of NewPacketAllocless on the captured bytes in
+// b, see decodeInet.
+func decodeTcpPacket(b []byte, datalinkType int32, packet *TcpPacket) error {
+	var h inetPacket
+	b, e, ok := decodeInet(b, datalinkType, &h)
+	if !ok {
+		return packet.fail(e)
+	}
+	if h.proto != IpProtoTCP {
+		return packet.fail(DecodeError{Reason: DecodeNotTCP, Hdr: h.ipHdrName(), Value: int(h.proto)})
+	}
+	return packet.decode(b, &h)
+}
+
+// decode fills in packet from the TCP header at the start of b, which the
+// IP header h is in front of.
+func (packet *TcpPacket) decode(b []byte, h *inetPacket) error {
+	paylen := h.paylen
+
+	// unwrap tcp packet
+	if len(b) < tcpHdrLen {
+		return packet.fail(DecodeError{Reason: DecodeTruncated, Hdr: "tcphdr", Value: tcpHdrLen, Caplen: len(b)})
+	}
+	flags := binary.BigEndian.Uint16(b[tcpFlagsOff:])
+	dataoffset := int(flags>>12) * 4
+	if dataoffset < tcpHdrLen {
+		return packet.fail(DecodeError{Reason: DecodeBadHeaderLength, Hdr: "tcphdr", Field: "doff", Value: dataoffset / 4, Caplen: len(b)})
+	}
+	if dataoffset > paylen {
+		return packet.fail(DecodeError{Reason: DecodeBadHeaderLength, Hdr: "tcphdr", Field: "doff", Value: dataoffset / 4, Caplen: len(b)})
+	}
+	// The IP length is allowed to be short of the capture (Ethernet pads
+	// small frames) but not to go past it.
+	if paylen > len(b) {
+		return packet.fail(DecodeError{Reason: DecodeTruncated, Hdr: "tcphdr", Value: paylen, Caplen: len(b)})
+	}
+
+	packet.DstAddr0, packet.DstAddr1, packet.DstAddr2, packet.DstAddr3 = h.dst[0], h.dst[1], h.dst[2], h.dst[3]
+	packet.SrcAddr0, packet.SrcAddr1, packet.SrcAddr2, packet.SrcAddr3 = h.src[0], h.src[1], h.src[2], h.src[3]
+	packet.OuterVlan, packet.InnerVlan = h.outerVlan, h.innerVlan
+	packet.ipv6 = h.ipv6
+	packet.TTL, packet.IpId, packet.DSCP = h.ttl, h.id, h.dscp
+	packet.IpHdrLen = uint16(h.hdrLen)
+	packet.Source = binary.BigEndian.Uint16(b[tcpSourceOff:])
+	packet.Dest = binary.BigEndian.Uint16(b[tcpDestOff:])
+	packet.Seq = binary.BigEndian.Uint32(b[tcpSeqOff:])
+	packet.AckSeq = binary.BigEndian.Uint32(b[tcpAckSeqOff:])
+	packet.Flags = flags & uint16(0x01FF)
+	packet.Window = binary.BigEndian.Uint16(b[tcpWindowOff:])
+	packet.TcpHdrLen = uint16(dataoffset)
+	packet.options = b[tcpHdrLen:dataoffset:dataoffset]
+	packet.Payload = b[dataoffset:paylen:paylen]
+	packet.Saved = false
+
+	return nil
+}
@@ -0,0 +1,294 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// An Ethernet frame with a TCP/IPv4 segment carrying "hello!".
+var tcp4EthFrame = []byte{
+	// ether_header
+	0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb,
+	0x08, 0x00,
+	// iphdr: ihl 5, tot_len 46, id 0x1234, DF, ttl 64, TCP
+	0x45, 0x00, 0x00, 0x2e, 0x12, 0x34, 0x40, 0x00, 0x40, 0x06, 0x00, 0x00,
+	192, 168, 1, 2,
+	10, 0, 0, 1,
+	// tcphdr: 8080->50000 seq 424242 ack 313131 doff 5 PSH|ACK
+	0x1f, 0x90, 0xc3, 0x50, 0x00, 0x06, 0x79, 0x32, 0x00, 0x04, 0xc7, 0x2b,
+	0x50, 0x18, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00,
+	'h', 'e', 'l', 'l', 'o', '!',
+}
+
+// A Linux cooked frame with a TCP/IPv6 segment (with options) carrying "ping".
+var tcp6SllFrame = []byte{
+	// sll_header
+	0x00, 0x00, 0x00, 0x01, 0x00, 0x06, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb,
+	0x00, 0x00, 0x86, 0xdd,
+	// ip6_hdr: plen 32, TCP, hlim 64
+	0x60, 0x00, 0x00, 0x00, 0x00, 0x20, 0x06, 0x40,
+	0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+	0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02,
+	// tcphdr: 80->54321 seq 1 ack 2 doff 7 SYN|ACK
+	0x00, 0x50, 0xd4, 0x31, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02,
+	0x70, 0x12, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00,
+	0x02, 0x04, 0x05, 0xb4, 0x01, 0x03, 0x03, 0x07,
+	'p', 'i', 'n', 'g',
+}
+
+// sll2Frame returns tcp6SllFrame with a DLT_LINUX_SLL2 header instead, for
+// a packet sent on interface 3.
+func sll2Frame() []byte {
+	b := []byte{
+		0x86, 0xdd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x00, 0x01, SllOutgoing, 0x06,
+		0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0x00, 0x00,
+	}
+	return append(b, tcp6SllFrame[sllHdrLen:]...)
+}
+
+// addrBytes turns the address words of a TcpPacket back into wire order.
+func addrBytes(w ...uint32) net.IP {
+	b := make([]byte, 4*len(w))
+	for i := range w {
+		binary.NativeEndian.PutUint32(b[4*i:], w[i])
+	}
+	return net.IP(b)
+}
+
+// bsdLoFrame returns the IPv4 part of tcp4EthFrame behind a DLT_NULL header
+// written in the given byte order.
+func bsdLoFrame(order binary.ByteOrder) []byte {
+	b := make([]byte, bsdLoHdrLen, bsdLoHdrLen+len(tcp4EthFrame)-ethHdrLen)
+	order.PutUint32(b, BSD_LO_IPV4)
+	return append(b, tcp4EthFrame[ethHdrLen:]...)
+}
+
+// Make sure a TCP/IPv4 packet decodes to the same values on any host.
+func TestDecodeTcpPacketIPv4(t *testing.T) {
+	frames := map[string][]byte{
+		"ethernet":      tcp4EthFrame,
+		"null (big)":    bsdLoFrame(binary.BigEndian),
+		"null (little)": bsdLoFrame(binary.LittleEndian),
+		"loop":          bsdLoFrame(binary.BigEndian),
+		"raw":           tcp4EthFrame[ethHdrLen:],
+		"ipv4":          tcp4EthFrame[ethHdrLen:],
+	}
+	linkTypes := map[string]int32{
+		"ethernet":      LinkTypeEthernet,
+		"null (big)":    LinkTypeNull,
+		"null (little)": LinkTypeNull,
+		"loop":          LinkTypeLoop,
+		"raw":           LinkTypeRaw,
+		"ipv4":          LinkTypeIPv4,
+	}
+	for name, frame := range frames {
+		var p TcpPacket
+		if err := decodeTcpPacket(frame, linkTypes[name], &p); err != nil {
+			t.Errorf("%s: decodeTcpPacket: %v", name, err)
+			continue
+		}
+		if !p.IsIPv4() {
+			t.Errorf("%s: p.IsIPv4() == false", name)
+		}
+		if src := addrBytes(p.SrcAddr0); !src.Equal(net.IPv4(192, 168, 1, 2)) {
+			t.Errorf("%s: src (%s) != 192.168.1.2", name, src)
+		}
+		if dst := addrBytes(p.DstAddr0); !dst.Equal(net.IPv4(10, 0, 0, 1)) {
+			t.Errorf("%s: dst (%s) != 10.0.0.1", name, dst)
+		}
+		if p.Source != 8080 || p.Dest != 50000 {
+			t.Errorf("%s: ports %d->%d != 8080->50000", name, p.Source, p.Dest)
+		}
+		if p.Seq != 424242 || p.AckSeq != 313131 {
+			t.Errorf("%s: seq/ack %d/%d != 424242/313131", name, p.Seq, p.AckSeq)
+		}
+		if p.Flags != TCP_PSH|TCP_ACK {
+			t.Errorf("%s: p.Flags (%#x) != %#x", name, p.Flags, TCP_PSH|TCP_ACK)
+		}
+		if !bytes.Equal(p.Payload, []byte("hello!")) {
+			t.Errorf("%s: p.Payload (%q) != \"hello!\"", name, p.Payload)
+		}
+		if p.TTL != 64 || p.IpId != 0x1234 || p.DSCP != 0 || p.Window != 512 {
+			t.Errorf("%s: ttl %d id %#x dscp %d window %d != 64 0x1234 0 512", name, p.TTL, p.IpId, p.DSCP, p.Window)
+		}
+		if p.IpHdrLen != ipHdrLen || p.TcpHdrLen != tcpHdrLen {
+			t.Errorf("%s: header lengths %d/%d != %d/%d", name, p.IpHdrLen, p.TcpHdrLen, ipHdrLen, tcpHdrLen)
+		}
+	}
+}
+
+// Make sure a TCP/IPv6 packet decodes to the same values on any host.
+func TestDecodeTcpPacketIPv6(t *testing.T) {
+	var p TcpPacket
+	if err := decodeTcpPacket(tcp6SllFrame, LinkTypeLinuxSLL, &p); err != nil {
+		t.Fatalf("decodeTcpPacket: %v", err)
+	}
+	if p.IsIPv4() {
+		t.Error("p.IsIPv4() == true")
+	}
+	src := addrBytes(p.SrcAddr0, p.SrcAddr1, p.SrcAddr2, p.SrcAddr3)
+	if !src.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("src (%s) != 2001:db8::1", src)
+	}
+	dst := addrBytes(p.DstAddr0, p.DstAddr1, p.DstAddr2, p.DstAddr3)
+	if !dst.Equal(net.ParseIP("2001:db8::2")) {
+		t.Errorf("dst (%s) != 2001:db8::2", dst)
+	}
+	if p.Source != 80 || p.Dest != 54321 {
+		t.Errorf("ports %d->%d != 80->54321", p.Source, p.Dest)
+	}
+	if p.Seq != 1 || p.AckSeq != 2 {
+		t.Errorf("seq/ack %d/%d != 1/2", p.Seq, p.AckSeq)
+	}
+	if p.Flags != TCP_SYN|TCP_ACK {
+		t.Errorf("p.Flags (%#x) != %#x", p.Flags, TCP_SYN|TCP_ACK)
+	}
+	if !bytes.Equal(p.Payload, []byte("ping")) {
+		t.Errorf("p.Payload (%q) != \"ping\"", p.Payload)
+	}
+
+	if p.TTL != 64 || p.IpId != 0 || p.Window != 0xffff || p.IpHdrLen != IPV6_HEADER_LEN || p.TcpHdrLen != 28 {
+		t.Errorf("ttl %d id %d window %d header lengths %d/%d != 64 0 65535 40/28", p.TTL, p.IpId, p.Window, p.IpHdrLen, p.TcpHdrLen)
+	}
+
+	// The extension headers count in the IP header length, and the
+	// traffic class gives the DSCP.
+	frame := patch(withIp6Ext(tcp6SllFrame, sllHdrLen, hopByHop), sllHdrLen, 0x6b, 0x80)
+	if err := decodeTcpPacket(frame, LinkTypeLinuxSLL, &p); err != nil {
+		t.Fatalf("decodeTcpPacket: %v", err)
+	}
+	if p.IpHdrLen != IPV6_HEADER_LEN+8 || p.DSCP != 46 {
+		t.Errorf("ip header length %d dscp %d != 48 46", p.IpHdrLen, p.DSCP)
+	}
+
+	// Reusing the packet for IPv4 must not leave IPv6 address words behind.
+	if err := decodeTcpPacket(tcp4EthFrame, LinkTypeEthernet, &p); err != nil {
+		t.Fatalf("decodeTcpPacket: %v", err)
+	}
+	if !p.IsIPv4() {
+		t.Error("p.IsIPv4() == false after reuse")
+	}
+}
+
+// Make sure tagged frames decode and keep their VLAN IDs, with or without an
+// MPLS label stack under the tags.
+func TestDecodeTcpPacketVlan(t *testing.T) {
+	for name, c := range map[string]struct {
+		frame        []byte
+		outer, inner uint16
+	}{
+		"untagged": {tcp4EthFrame, 0, 0},
+		"dot1q":    {tagged(tcp4EthFrame, EtherTypeDot1Q, 0x2064), 100, 0},
+		"qinq":     {tagged(tcp6EthFrame, EtherTypeQinQ, 0x0064, EtherTypeDot1Q, 0x00c8), 100, 200},
+		"mpls":     {tagged(withMpls(tcp6EthFrame, 16, 17), EtherTypeDot1Q, 0x2064), 100, 0},
+	} {
+		var p TcpPacket
+		p.OuterVlan, p.InnerVlan = 1, 1 // left over from a previous packet
+		if err := decodeTcpPacket(c.frame, LinkTypeEthernet, &p); err != nil {
+			t.Errorf("%s: decodeTcpPacket: %v", name, err)
+			continue
+		}
+		if p.OuterVlan != c.outer || p.InnerVlan != c.inner {
+			t.Errorf("%s: vlans %d/%d, want %d/%d", name, p.OuterVlan, p.InnerVlan, c.outer, c.inner)
+		}
+		if len(p.Payload) == 0 {
+			t.Errorf("%s: no payload", name)
+		}
+	}
+}
+
+// Make sure packets we can't handle are turned down for the right reason.
+func TestDecodeTcpPacketReject(t *testing.T) {
+	udp := patch(tcp4EthFrame, ethHdrLen+ipProtocolOff, IpProtoUDP)
+	arp := patch(tcp4EthFrame, ethTypeOff, 0x08, 0x06)
+	ip6v4 := patch(tcp6SllFrame, sllHdrLen, 0x40)
+	for name, c := range map[string]struct {
+		frame    []byte
+		linkType int32
+		reason   DecodeReason
+		value    int
+	}{
+		"udp":       {udp, LinkTypeEthernet, DecodeNotTCP, int(IpProtoUDP)},
+		"arp":       {arp, LinkTypeEthernet, DecodeUnsupportedEtherType, int(EtherTypeARP)},
+		"ppp":       {tcp4EthFrame, 9, DecodeUnsupportedLink, 9},
+		"version":   {ip6v4, LinkTypeLinuxSLL, DecodeBadVersion, 4},
+		"truncated": {tcp4EthFrame[:ethHdrLen+ipHdrLen+10], LinkTypeEthernet, DecodeTruncated, tcpHdrLen},
+		"empty":     {nil, LinkTypeEthernet, DecodeTruncated, ethHdrLen},
+		"mpls":      {withMpls(arpEthFrame, 16), LinkTypeEthernet, DecodeBadVersion, 0},
+	} {
+		var p TcpPacket
+		err := decodeTcpPacket(c.frame, c.linkType, &p)
+		e, ok := err.(*DecodeError)
+		if !ok {
+			t.Errorf("%s: decodeTcpPacket = %v, want a *DecodeError", name, err)
+			continue
+		}
+		if e.Reason != c.reason || e.Value != c.value {
+			t.Errorf("%s: decodeTcpPacket = %v, want %s %d", name, e, c.reason, c.value)
+		}
+	}
+}
+
+// Make sure the fast path stays free of heap allocations, failures included.
+func TestDecodeTcpPacketAllocs(t *testing.T) {
+	var p TcpPacket
+	udp := patch(tcp4EthFrame, ethHdrLen+ipProtocolOff, IpProtoUDP)
+	allocs := testing.AllocsPerRun(100, func() {
+		decodeTcpPacket(tcp4EthFrame, LinkTypeEthernet, &p)
+		decodeTcpPacket(tcp6SllFrame, LinkTypeLinuxSLL, &p)
+		decodeTcpPacket(udp, LinkTypeEthernet, &p)
+		decodeTcpPacket(nil, LinkTypeEthernet, &p)
+	})
+	if allocs != 0 {
+		t.Errorf("decodeTcpPacket allocs = %v, want 0", allocs)
+	}
+}
+
+// Make sure the addresses come out as netip values, whatever the address
+// words hold, and that FlowKeys of the two directions of a flow are told
+// apart.
+func TestTcpPacketAddrs(t *testing.T) {
+	var p TcpPacket
+	if err := decodeTcpPacket(tcp4EthFrame, LinkTypeEthernet, &p); err != nil {
+		t.Fatalf("decodeTcpPacket: %v", err)
+	}
+	if p.SrcAddrPort().String() != "192.168.1.2:8080" || p.DstAddrPort().String() != "10.0.0.1:50000" {
+		t.Errorf("addresses %s->%s, want 192.168.1.2:8080->10.0.0.1:50000", p.SrcAddrPort(), p.DstAddrPort())
+	}
+
+	// An IPv6 address whose last 96 bits are 0 is still IPv6.
+	frame := patch(tcp6SllFrame, sllHdrLen+ip6DstOff+15, 0)
+	if err := decodeTcpPacket(frame, LinkTypeLinuxSLL, &p); err != nil {
+		t.Fatalf("decodeTcpPacket: %v", err)
+	}
+	if p.IsIPv4() || p.DstAddr().String() != "2001:db8::" || p.SrcAddr().String() != "2001:db8::1" {
+		t.Errorf("p.IsIPv4() = %t, addresses %s->%s, want 2001:db8::1->2001:db8::", p.IsIPv4(), p.SrcAddr(), p.DstAddr())
+	}
+
+	flows := map[FlowKey]int{}
+	flows[p.FlowKey()]++
+	flows[p.FlowKey()]++
+	flows[p.FlowKey().Reverse()]++
+	if len(flows) != 2 || flows[p.FlowKey()] != 2 {
+		t.Errorf("flows = %v", flows)
+	}
+	if s := p.FlowKey().String(); s != "[2001:db8::1]:80->[2001:db8::]:54321 proto 6" {
+		t.Errorf("p.FlowKey() = %s", s)
+	}
+
+	var k FlowKey
+	allocs := testing.AllocsPerRun(100, func() {
+		k = p.FlowKey()
+	})
+	if allocs != 0 || k != p.FlowKey() {
+		t.Errorf("p.FlowKey allocs = %v, want 0", allocs)
+	}
+}
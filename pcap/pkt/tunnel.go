@@ -0,0 +1,353 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// These are the UDP destination ports that tell a VXLAN or Geneve tunnel
+// apart from other UDP traffic.
+const (
+	VxlanPort  = 4789 // IANA port for VXLAN
+	GenevePort = 6081 // IANA port for Geneve
+)
+
+// maxTunnels is how many tunnels deep a packet is decapsulated.  Anything
+// nested further is left as the payload of the innermost tunnel decoded.
+const maxTunnels = 4
+
+// A TunnelHdr is the header of an encapsulation that carries a whole packet,
+// Ethernet frame or IP datagram.  DecodePacket moves the headers in front of
+// it and the TunnelHdr itself to the extra headers, in the order they appear on
+// the wire, and decodes the packet it carries into the LinkLayer,
+// NetworkLayer and TransportLayer slots.  IP-in-IP has no header of its own,
+// so its outer IP header is moved to the extra headers without a TunnelHdr.
+type TunnelHdr interface {
+	Hdr
+
+	// TunnelKey returns the VNI or key that tells apart the networks
+	// carried by the tunnel, if the header has one.
+	TunnelKey() (key uint32, ok bool)
+}
+
+// Tunnel returns the innermost TunnelHdr of the Packet, or nil if it did not
+// come out of a tunnel with a header of its own.
+func (p *Packet) Tunnel() TunnelHdr {
+	for i := len(p.Headers) - 1; i >= ExtraHeaders; i-- {
+		if h, ok := p.Headers[i].(TunnelHdr); ok {
+			return h
+		}
+	}
+	return nil
+}
+
+// These are the bits of GreHdr.Flags that say which optional fields follow
+// the GRE header.  The lowest three bits hold the version.
+const (
+	GreChecksum = 0x8000 // checksum and reserved field present
+	GreKey      = 0x2000 // key present
+	GreSeq      = 0x1000 // sequence number present
+)
+
+// The GreHdr struct is a GRE header (RFC 2784) with the key and sequence
+// number extensions of RFC 2890.  IP protocol 47 says that it is there.
+type GreHdr struct {
+	Flags    uint16 // GreChecksum, GreKey, GreSeq and the version
+	Protocol uint16 // EtherType of the payload
+	Checksum uint16 // if GreChecksum is set
+	Key      uint32 // if GreKey is set
+	Seq      uint32 // if GreSeq is set
+}
+
+// NewGreHdr returns the GRE header at the start of b along with the bytes
+// that follow it.  Only version 0 is decoded; version 1 is the enhanced GRE
+// of PPTP, which carries PPP.
+func NewGreHdr(b []byte) (*GreHdr, []byte, error) {
+	if len(b) < greHdrLen {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "gre", Value: greHdrLen, Caplen: len(b)}
+	}
+	h := &GreHdr{
+		Flags:    binary.BigEndian.Uint16(b),
+		Protocol: binary.BigEndian.Uint16(b[2:]),
+	}
+	if v := h.Flags & 0x07; v != 0 {
+		return nil, nil, &DecodeError{Reason: DecodeBadVersion, Hdr: "gre", Value: int(v)}
+	}
+	n := greHdrLen
+	for _, f := range []uint16{GreChecksum, GreKey, GreSeq} {
+		if h.Flags&f != 0 {
+			n += 4
+		}
+	}
+	if len(b) < n {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "gre", Value: n, Caplen: len(b)}
+	}
+	o := b[greHdrLen:n]
+	if h.Flags&GreChecksum != 0 {
+		h.Checksum, o = binary.BigEndian.Uint16(o), o[4:]
+	}
+	if h.Flags&GreKey != 0 {
+		h.Key, o = binary.BigEndian.Uint32(o), o[4:]
+	}
+	if h.Flags&GreSeq != 0 {
+		h.Seq = binary.BigEndian.Uint32(o)
+	}
+	return h, b[n:], nil
+}
+
+// TunnelKey returns the GRE key, which NVGRE uses for the virtual subnet ID.
+func (h *GreHdr) TunnelKey() (uint32, bool) {
+	return h.Key, h.Flags&GreKey != 0
+}
+
+// JsonElement returns a JSON encoding of the GreHdr struct.
+func (h *GreHdr) JsonElement() string {
+	return fmt.Sprintf("\"gre\":{\"flags\":%d,\"protocol\":%d,\"checksum\":%d,\"key\":%d,\"seq\":%d}",
+		h.Flags,
+		h.Protocol,
+		h.Checksum,
+		h.Key,
+		h.Seq)
+}
+
+// CsvElement returns a CSV encoding of the GreHdr struct.
+// The string "GRE" signifies the beginning of the GreHdr.
+func (h *GreHdr) CsvElement() string {
+	return fmt.Sprintf("\"GRE\",%d,%d,%d,%d,%d",
+		h.Flags,
+		h.Protocol,
+		h.Checksum,
+		h.Key,
+		h.Seq)
+}
+
+// String returns a minimal encoding of the GreHdr struct.
+func (h *GreHdr) String() string {
+	if h.Flags&GreKey != 0 {
+		return fmt.Sprintf("gre key %d %#x", h.Key, h.Protocol)
+	}
+	return fmt.Sprintf("gre %#x", h.Protocol)
+}
+
+// VxlanVNIValid is the bit of VxlanHdr.Flags that says the VNI is set.
+const VxlanVNIValid = 0x08
+
+// The VxlanHdr struct is a VXLAN header (RFC 7348), which comes after a UDP
+// header with the destination port VxlanPort and carries an Ethernet frame.
+type VxlanHdr struct {
+	Flags uint8  // VxlanVNIValid, the rest are reserved
+	VNI   uint32 // VXLAN network identifier (24 bits)
+}
+
+// NewVxlanHdr returns the VXLAN header at the start of b along with the bytes
+// that follow it.
+func NewVxlanHdr(b []byte) (*VxlanHdr, []byte, error) {
+	if len(b) < vxlanHdrLen {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "vxlan", Value: vxlanHdrLen, Caplen: len(b)}
+	}
+	h := &VxlanHdr{
+		Flags: b[0],
+		VNI:   binary.BigEndian.Uint32(b[4:]) >> 8,
+	}
+	return h, b[vxlanHdrLen:], nil
+}
+
+// TunnelKey returns the VNI.
+func (h *VxlanHdr) TunnelKey() (uint32, bool) {
+	return h.VNI, h.Flags&VxlanVNIValid != 0
+}
+
+// JsonElement returns a JSON encoding of the VxlanHdr struct.
+func (h *VxlanHdr) JsonElement() string {
+	return fmt.Sprintf("\"vxlan\":{\"flags\":%d,\"vni\":%d}",
+		h.Flags,
+		h.VNI)
+}
+
+// CsvElement returns a CSV encoding of the VxlanHdr struct.
+// The string "VXLAN" signifies the beginning of the VxlanHdr.
+func (h *VxlanHdr) CsvElement() string {
+	return fmt.Sprintf("\"VXLAN\",%d,%d",
+		h.Flags,
+		h.VNI)
+}
+
+// String returns a minimal encoding of the VxlanHdr struct.
+func (h *VxlanHdr) String() string {
+	return fmt.Sprintf("vxlan vni %d", h.VNI)
+}
+
+// The GeneveHdr struct is a Geneve header (RFC 8926), which comes after a
+// UDP header with the destination port GenevePort.
+type GeneveHdr struct {
+	Version  uint8  // 0
+	OAM      bool   // control packet rather than data
+	Critical bool   // options that have to be understood are present
+	Protocol uint16 // EtherType of the payload
+	VNI      uint32 // virtual network identifier (24 bits)
+	Options  []byte // the options, still TLV encoded
+}
+
+// NewGeneveHdr returns the Geneve header at the start of b, with its options,
+// along with the bytes that follow it.
+func NewGeneveHdr(b []byte) (*GeneveHdr, []byte, error) {
+	if len(b) < geneveHdrLen {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "geneve", Value: geneveHdrLen, Caplen: len(b)}
+	}
+	h := &GeneveHdr{
+		Version:  b[0] >> 6,
+		OAM:      b[1]&0x80 != 0,
+		Critical: b[1]&0x40 != 0,
+		Protocol: binary.BigEndian.Uint16(b[2:]),
+		VNI:      binary.BigEndian.Uint32(b[4:]) >> 8,
+	}
+	if h.Version != 0 {
+		return nil, nil, &DecodeError{Reason: DecodeBadVersion, Hdr: "geneve", Value: int(h.Version)}
+	}
+	n := geneveHdrLen + int(b[0]&0x3F)*4
+	if len(b) < n {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "geneve", Value: n, Caplen: len(b)}
+	}
+	h.Options = append([]byte(nil), b[geneveHdrLen:n]...)
+	return h, b[n:], nil
+}
+
+// TunnelKey returns the VNI.
+func (h *GeneveHdr) TunnelKey() (uint32, bool) {
+	return h.VNI, true
+}
+
+// JsonElement returns a JSON encoding of the GeneveHdr struct.
+func (h *GeneveHdr) JsonElement() string {
+	return fmt.Sprintf("\"geneve\":{\"version\":%d,\"oam\":%t,\"critical\":%t,\"protocol\":%d,\"vni\":%d,\"opt_len\":%d}",
+		h.Version,
+		h.OAM,
+		h.Critical,
+		h.Protocol,
+		h.VNI,
+		len(h.Options))
+}
+
+// CsvElement returns a CSV encoding of the GeneveHdr struct.
+// The string "GENEVE" signifies the beginning of the GeneveHdr.
+func (h *GeneveHdr) CsvElement() string {
+	return fmt.Sprintf("\"GENEVE\",%d,%t,%t,%d,%d,%d",
+		h.Version,
+		h.OAM,
+		h.Critical,
+		h.Protocol,
+		h.VNI,
+		len(h.Options))
+}
+
+// String returns a minimal encoding of the GeneveHdr struct.
+func (h *GeneveHdr) String() string {
+	return fmt.Sprintf("geneve vni %d %#x", h.VNI, h.Protocol)
+}
+
+// tunnelLinkType returns the link-layer type that stands for the EtherType of
+// the payload of a tunnel, or -1 if it is not one we decode.
+func tunnelLinkType(etherType uint16) int32 {
+	switch etherType {
+	case EtherTypeTEB:
+		return LinkTypeEthernet
+	case EtherTypeIPv4:
+		return LinkTypeIPv4
+	case EtherTypeIPv6:
+		return LinkTypeIPv6
+	}
+	return -1
+}
+
+// decodeTunnel decodes the tunnel header at the start of b, if proto and port
+// say it is one, and then the packet it carries.  linkAt and netAt are where
+// the extra headers of the encapsulating packet that come after its
+// link-layer and network-layer headers start.
+func (p *Packet) decodeTunnel(proto uint8, port uint16, b []byte, linkAt, netAt, depth int) error {
+	if depth >= maxTunnels {
+		return nil
+	}
+	var (
+		tunnel   TunnelHdr
+		linkType int32
+		err      error
+	)
+	switch {
+	case proto == IpProtoIPIP:
+		linkType = LinkTypeIPv4
+	case proto == IpProtoIPv6:
+		linkType = LinkTypeIPv6
+	case proto == IpProtoGRE:
+		var h *GreHdr
+		if h, b, err = NewGreHdr(b); err != nil {
+			return err
+		}
+		tunnel, linkType = h, tunnelLinkType(h.Protocol)
+		if linkType < 0 {
+			p.addHeader(h)
+			return &DecodeError{Reason: DecodeUnsupportedEtherType, Hdr: "gre", Value: int(h.Protocol)}
+		}
+	case proto == IpProtoUDP && port == VxlanPort:
+		var h *VxlanHdr
+		if h, b, err = NewVxlanHdr(b); err != nil {
+			return err
+		}
+		tunnel, linkType = h, LinkTypeEthernet
+	case proto == IpProtoUDP && port == GenevePort:
+		var h *GeneveHdr
+		if h, b, err = NewGeneveHdr(b); err != nil {
+			return err
+		}
+		tunnel, linkType = h, tunnelLinkType(h.Protocol)
+		if linkType < 0 {
+			p.addHeader(h)
+			return &DecodeError{Reason: DecodeUnsupportedEtherType, Hdr: "geneve", Value: int(h.Protocol)}
+		}
+	default:
+		return nil
+	}
+	p.encapsulated(tunnel, linkAt, netAt)
+	return p.decodeLayers(linkType, b, depth+1)
+}
+
+// encapsulated moves the headers decoded so far, which belong to the packet
+// that carries a tunnel, to the extra headers in the order they appear on the
+// wire, followed by tunnel if it is not nil.  linkAt and netAt are as for
+// decodeTunnel.  The layer slots are left empty for the packet inside.
+func (p *Packet) encapsulated(tunnel TunnelHdr, linkAt, netAt int) {
+	for len(p.Headers) < ExtraHeaders {
+		p.Headers = append(p.Headers, nil)
+	}
+	extra := p.Headers[ExtraHeaders:]
+	outer := make([]Hdr, 0, len(extra)+4)
+	outer = append(outer, extra[:linkAt]...)
+	if h := p.Headers[LinkLayer]; h != nil {
+		outer = append(outer, h)
+	}
+	outer = append(outer, extra[linkAt:netAt]...)
+	outer = append(outer, p.Headers[NetworkLayer])
+	outer = append(outer, extra[netAt:]...)
+	if h := p.Headers[TransportLayer]; h != nil {
+		outer = append(outer, h)
+	}
+	if tunnel != nil {
+		outer = append(outer, tunnel)
+	}
+	p.Headers = append(p.Headers[:ExtraHeaders], outer...)
+	for i := 0; i < ExtraHeaders; i++ {
+		p.Headers[i] = nil
+	}
+	p.outer = append(p.outer, p.extraLen())
+}
+
+// extraLen returns the number of extra headers of the Packet.
+func (p *Packet) extraLen() int {
+	if len(p.Headers) < ExtraHeaders {
+		return 0
+	}
+	return len(p.Headers) - ExtraHeaders
+}
@@ -0,0 +1,196 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !appengine
+
+package pkt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// outerEth and outerIp are the outer headers of the test tunnels.
+var (
+	outerEth = &EthHdr{
+		DstAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02},
+		SrcAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01},
+	}
+	outerIp  = &IpHdr{SrcAddr: net.IPv4(172, 16, 0, 1), DstAddr: net.IPv4(172, 16, 0, 2), IpFields: IpFields{Ttl: 64}}
+	outerIp6 = &Ip6Hdr{SrcAddr: net.ParseIP("2001:db8::1"), DstAddr: net.ParseIP("2001:db8::2"), HopLimit: 64}
+)
+
+// tunnelFrame returns the Ethernet frame of hdrs followed by payload.
+func tunnelFrame(t testing.TB, payload []byte, hdrs ...Hdr) []byte {
+	b, err := Serialize(payload, hdrs...)
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	return b
+}
+
+// hdrTypes returns the types of hdrs, without the package name.
+func hdrTypes(hdrs []Hdr) string {
+	s := make([]string, len(hdrs))
+	for i, h := range hdrs {
+		s[i] = strings.TrimPrefix(fmt.Sprintf("%T", h), "*pkt.")
+	}
+	return strings.Join(s, " ")
+}
+
+// Make sure the packet inside a tunnel takes the layer slots, with the outer
+// headers kept in the extra headers in wire order.
+func TestDecodeTunnel(t *testing.T) {
+	ipip := &IpHdr{SrcAddr: outerIp.SrcAddr, DstAddr: outerIp.DstAddr, IpFields: IpFields{Ttl: 64}}
+	for name, c := range map[string]struct {
+		frame  []byte
+		outer  string // the types of the extra headers
+		port   uint16 // the source port of the inner TcpHdr
+		key    uint32
+		hasKey bool
+	}{
+		"vxlan": {
+			tunnelFrame(t, tcp4EthFrame, outerEth, outerIp, &UdpHdr{Source: 50123, Dest: VxlanPort}, &VxlanHdr{Flags: VxlanVNIValid, VNI: 4242}),
+			"EthHdr IpHdr UdpHdr VxlanHdr", 8080, 4242, true,
+		},
+		"vxlan vlans": {
+			tagged(tunnelFrame(t, tagged(tcp4EthFrame, EtherTypeDot1Q, 0x0064), outerEth, outerIp, &UdpHdr{Source: 50123, Dest: VxlanPort}, &VxlanHdr{Flags: VxlanVNIValid, VNI: 0xFFFFFF}), EtherTypeDot1Q, 0x00c8),
+			"EthHdr Dot1QHdr IpHdr UdpHdr VxlanHdr Dot1QHdr", 8080, 0xFFFFFF, true,
+		},
+		"geneve": {
+			tunnelFrame(t, tcp6EthFrame[ethHdrLen:], outerEth, outerIp6, &UdpHdr{Source: 50123, Dest: GenevePort}, &GeneveHdr{Protocol: EtherTypeIPv6, VNI: 77, Options: []byte{0x01, 0x02, 0x03, 0x01, 0xaa, 0xbb, 0xcc, 0xdd}}),
+			"EthHdr Ip6Hdr UdpHdr GeneveHdr", 80, 77, true,
+		},
+		"gre": {
+			tunnelFrame(t, tcp4EthFrame[ethHdrLen:], outerEth, outerIp, &GreHdr{Flags: GreChecksum | GreKey | GreSeq, Protocol: EtherTypeIPv4, Key: 99, Seq: 7}),
+			"EthHdr IpHdr GreHdr", 8080, 99, true,
+		},
+		"gre teb": {
+			tunnelFrame(t, tcp6EthFrame, outerEth, outerIp6, &GreHdr{Protocol: EtherTypeTEB}),
+			"EthHdr Ip6Hdr GreHdr", 80, 0, false,
+		},
+		"ipip": {
+			tunnelFrame(t, tcp4EthFrame[ethHdrLen:], outerEth, &IpHdr{SrcAddr: outerIp.SrcAddr, DstAddr: outerIp.DstAddr, Protocol: IpProtoIPIP, IpFields: IpFields{Ttl: 64}}),
+			"EthHdr IpHdr", 8080, 0, false,
+		},
+		"6in4": {
+			tunnelFrame(t, tcp6EthFrame[ethHdrLen:], outerEth, &IpHdr{SrcAddr: outerIp.SrcAddr, DstAddr: outerIp.DstAddr, Protocol: IpProtoIPv6, IpFields: IpFields{Ttl: 64}}),
+			"EthHdr IpHdr", 80, 0, false,
+		},
+		"gre in ipip": {
+			tunnelFrame(t, tcp4EthFrame[ethHdrLen:], outerEth, ipip, outerIp, &GreHdr{Flags: GreKey, Key: 5, Protocol: EtherTypeIPv4}),
+			"EthHdr IpHdr IpHdr GreHdr", 8080, 5, true,
+		},
+	} {
+		p := decodeGuarded(t, c.frame)
+		if p.Err() != nil {
+			t.Errorf("%s: p.Err() = %v", name, p.Err())
+			continue
+		}
+		if s := hdrTypes(p.Headers[ExtraHeaders:]); s != c.outer {
+			t.Errorf("%s: extra headers %s, want %s", name, s, c.outer)
+		}
+		if h, ok := p.Headers[TransportLayer].(*TcpHdr); !ok || h.Source != c.port {
+			t.Errorf("%s: p.Headers[TransportLayer] = %v, want the inner TcpHdr", name, p.Headers[TransportLayer])
+		}
+		var key uint32
+		var hasKey bool
+		if h := p.Tunnel(); h != nil {
+			key, hasKey = h.TunnelKey()
+		}
+		if key != c.key || hasKey != c.hasKey {
+			t.Errorf("%s: tunnel key %d %t, want %d %t", name, key, hasKey, c.key, c.hasKey)
+		}
+	}
+}
+
+// Make sure the headers of the packets that carry a tunnel are encoded apart
+// from the ones of the packet inside, so that no JSON key is repeated.
+func TestTunnelJson(t *testing.T) {
+	type ipJson struct {
+		Saddr string `json:"saddr"`
+	}
+	var v struct {
+		Ip    ipJson `json:"iphdr"`
+		Outer struct {
+			Ip    ipJson `json:"iphdr"`
+			Outer struct {
+				Ip  ipJson          `json:"iphdr"`
+				Eth json.RawMessage `json:"ether_header"`
+			} `json:"outer"`
+		} `json:"outer"`
+	}
+	ipip := &IpHdr{SrcAddr: net.IPv4(10, 9, 9, 9), DstAddr: outerIp.DstAddr, Protocol: IpProtoIPIP, IpFields: IpFields{Ttl: 64}}
+	p := decodeGuarded(t, tunnelFrame(t, tcp4EthFrame[ethHdrLen:], outerEth, ipip, outerIp, &GreHdr{Protocol: EtherTypeIPv4}))
+	if err := json.Unmarshal([]byte(p.JsonString()), &v); err != nil {
+		t.Fatalf("json.Unmarshal(%s): %v", p.JsonString(), err)
+	}
+	if v.Ip.Saddr != "192.168.1.2" || v.Outer.Ip.Saddr != "172.16.0.1" || v.Outer.Outer.Ip.Saddr != "10.9.9.9" || v.Outer.Outer.Eth == nil {
+		t.Errorf("p.JsonString() = %s", p.JsonString())
+	}
+	if s := p.CsvString(); strings.Count(s, "\"OUTER\"") != 2 {
+		t.Errorf("p.CsvString() = %s, want two \"OUTER\" markers", s)
+	}
+}
+
+// Make sure tunnels we can't decode keep the outer packet in the layer slots,
+// or say why the packet inside could not be decoded.
+func TestDecodeTunnelMalformed(t *testing.T) {
+	vxlan := tunnelFrame(t, tcp4EthFrame, outerEth, outerIp, &UdpHdr{Source: 50123, Dest: VxlanPort}, &VxlanHdr{Flags: VxlanVNIValid, VNI: 1})
+	geneve := tunnelFrame(t, tcp4EthFrame, outerEth, outerIp, &UdpHdr{Source: 50123, Dest: GenevePort}, &GeneveHdr{VNI: 1})
+	for name, c := range map[string]struct {
+		frame  []byte
+		reason DecodeReason
+		hdr    string
+	}{
+		"gre ppp":        {tunnelFrame(t, []byte{0xff, 0x03}, outerEth, outerIp, &GreHdr{Protocol: 0x880b}), DecodeUnsupportedEtherType, "gre"},
+		"gre version":    {tunnelFrame(t, tcp4EthFrame[ethHdrLen:], outerEth, outerIp, &GreHdr{Flags: 1, Protocol: EtherTypeIPv4}), DecodeBadVersion, "gre"},
+		"gre short":      {tunnelFrame(t, nil, outerEth, outerIp, &GreHdr{Flags: GreKey, Protocol: EtherTypeIPv4})[:ethHdrLen+ipHdrLen+6], DecodeTruncated, "gre"},
+		"vxlan short":    {vxlan[:ethHdrLen+ipHdrLen+udpHdrLen+4], DecodeTruncated, "vxlan"},
+		"vxlan inner":    {vxlan[:ethHdrLen+ipHdrLen+udpHdrLen+vxlanHdrLen+ethHdrLen+ipHdrLen+10], DecodeTruncated, "tcphdr"},
+		"geneve version": {patch(geneve, ethHdrLen+ipHdrLen+udpHdrLen, 0x40), DecodeBadVersion, "geneve"},
+		"geneve options": {patch(geneve, ethHdrLen+ipHdrLen+udpHdrLen, 0x3F)[:len(geneve)-1], DecodeTruncated, "geneve"},
+	} {
+		p := decodeGuarded(t, c.frame)
+		if e, ok := p.Err().(*DecodeError); !ok || e.Reason != c.reason || e.Hdr != c.hdr {
+			t.Errorf("%s: p.Err() = %v, want %s in %s", name, p.Err(), c.reason, c.hdr)
+		}
+	}
+
+	p := decodeGuarded(t, tunnelFrame(t, []byte{0xff, 0x03}, outerEth, outerIp, &GreHdr{Protocol: 0x880b}))
+	if _, ok := p.Headers[NetworkLayer].(*IpHdr); !ok {
+		t.Errorf("gre ppp: p.Headers[NetworkLayer] = %v, want the outer IpHdr", p.Headers[NetworkLayer])
+	}
+	if p.Tunnel() == nil {
+		t.Error("gre ppp: no GreHdr")
+	}
+}
+
+// Make sure a fragment of a tunneled packet is left whole for the
+// Defragmenter, and that tunnels are only decoded so deep.
+func TestDecodeTunnelLimits(t *testing.T) {
+	frag := &IpHdr{SrcAddr: outerIp.SrcAddr, DstAddr: outerIp.DstAddr, IpFields: IpFields{Ttl: 64, MoreFragments: true}}
+	p := decodeGuarded(t, tunnelFrame(t, tcp4EthFrame, outerEth, frag, &UdpHdr{Dest: VxlanPort}, &VxlanHdr{Flags: VxlanVNIValid}))
+	if _, ok := p.Headers[TransportLayer].(*UdpHdr); !ok || p.Tunnel() != nil {
+		t.Errorf("fragment: headers %s %s, want the outer UdpHdr and no tunnel", p.Headers[TransportLayer], hdrTypes(p.Headers[ExtraHeaders:]))
+	}
+
+	hdrs := []Hdr{outerEth}
+	for i := 0; i <= maxTunnels; i++ {
+		hdrs = append(hdrs, &IpHdr{SrcAddr: outerIp.SrcAddr, DstAddr: outerIp.DstAddr, Protocol: IpProtoIPIP, IpFields: IpFields{Ttl: 64}})
+	}
+	p = decodeGuarded(t, tunnelFrame(t, tcp4EthFrame[ethHdrLen:], hdrs...))
+	if p.Err() != nil {
+		t.Fatalf("p.Err() = %v", p.Err())
+	}
+	if ip, ok := p.Headers[NetworkLayer].(*IpHdr); !ok || ip.Protocol != IpProtoIPIP {
+		t.Errorf("nested: p.Headers[NetworkLayer] = %v, want the IpHdr of the last tunnel", p.Headers[NetworkLayer])
+	}
+	if n := len(p.Headers) - ExtraHeaders; n != maxTunnels+1 {
+		t.Errorf("nested: %d extra headers, want %d", n, maxTunnels+1)
+	}
+}
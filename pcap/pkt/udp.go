@@ -5,9 +5,59 @@
 package pkt
 
 import (
+	"encoding/binary"
 	"fmt"
 )
 
+// The UdpHdr struct is the udphdr struct in <netinet/udp.h>.
+type UdpHdr struct {
+	Source uint16 // source port
+	Dest   uint16 // destination port
+	Len    uint16 // datagram length (header + payload) in bytes
+	Check  uint16 // checksum
+	raw    []byte // the header and the rest of the capture
+}
+
+// NewUdpHdr returns the UDP header at the start of b along with the bytes
+// that follow it.
+func NewUdpHdr(b []byte) (*UdpHdr, []byte, error) {
+	if len(b) < udpHdrLen {
+		return nil, nil, &DecodeError{Reason: DecodeTruncated, Hdr: "udphdr", Value: udpHdrLen, Caplen: len(b)}
+	}
+	udpHead := &UdpHdr{
+		Source: binary.BigEndian.Uint16(b),
+		Dest:   binary.BigEndian.Uint16(b[2:]),
+		Len:    binary.BigEndian.Uint16(b[4:]),
+		Check:  binary.BigEndian.Uint16(b[6:]),
+		raw:    b,
+	}
+	if udpHead.Len < udpHdrLen {
+		return nil, nil, &DecodeError{Reason: DecodeBadHeaderLength, Hdr: "udphdr", Field: "len", Value: int(udpHead.Len), Caplen: len(b)}
+	}
+	return udpHead, b[udpHdrLen:], nil
+}
+
+// Ports returns the source and destination ports, which makes a UdpHdr a
+// PortHdr.
+func (h *UdpHdr) Ports() (src, dst uint16) {
+	return h.Source, h.Dest
+}
+
+// PayloadLen returns the length of the UDP packet's payload in bytes.
+func (h *UdpHdr) PayloadLen(pl uint16) uint16 {
+	if pl < udpHdrLen {
+		return 0
+	}
+	return pl - udpHdrLen
+}
+
+// GetPayloadBytes returns the bytes from the packet's payload.  This is a Go
+// slice backed by the captured bytes, which are not copied.  Only the payload
+// bytes that were captured are returned, even when the IP header claims more.
+func (h *UdpHdr) GetPayloadBytes(pl uint16) []byte {
+	return payloadBytes(h.raw, udpHdrLen, int(h.PayloadLen(pl)))
+}
+
 // JsonElement returns a JSON encoding of the UdpHdr struct.
 func (h *UdpHdr) JsonElement() string {
 	return fmt.Sprintf("\"udphdr\":{\"source\":%d,\"dest\":%d,\"len\":%d,\"check\":%d}",
@@ -20,7 +70,7 @@ func (h *UdpHdr) JsonElement() string {
 // CsvElement returns a CSV encoding of the UdpHdr struct.
 // The string "UDP" signifies the beginning of the UdpHdr.
 func (h *UdpHdr) CsvElement() string {
-	return fmt.Sprintf("\"UDP\",%d,%d,%d,%d,%d",
+	return fmt.Sprintf("\"UDP\",%d,%d,%d,%d",
 		h.Source,
 		h.Dest,
 		h.Len,
@@ -0,0 +1,157 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"time"
+)
+
+// UdpPacket is the UDP counterpart of TcpPacket: the alloc-less decoders fill
+// it in from a UDP/IPv{4,6} packet without creating data in the heap.
+type UdpPacket struct {
+	DstAddr0  uint32 // IPv4 uses only this one, others are 0
+	DstAddr1  uint32
+	DstAddr2  uint32
+	DstAddr3  uint32
+	SrcAddr0  uint32 // IPv4 uses only this one, others are 0
+	SrcAddr1  uint32
+	SrcAddr2  uint32
+	SrcAddr3  uint32
+	Source    uint16
+	Dest      uint16
+	Length    uint16 // datagram length (header + payload) in bytes
+	OuterVlan uint16 // VLAN ID of the outer 802.1Q tag, 0 if untagged
+	InnerVlan uint16 // VLAN ID of the inner tag of a QinQ frame, 0 if none
+	Payload   []byte
+	Timestamp time.Time
+	Saved     bool
+	ipv6      bool        // set by the decoders, whatever the address words hold
+	err       DecodeError // why the last decode into this packet failed
+}
+
+func (this *UdpPacket) IsIPv4() bool {
+	return !this.ipv6 && this.DstAddr1 == 0 && this.DstAddr2 == 0 && this.DstAddr3 == 0
+}
+
+// SrcAddr returns the source address of the packet.
+func (this *UdpPacket) SrcAddr() netip.Addr {
+	return wordsAddr(this.IsIPv4(), this.SrcAddr0, this.SrcAddr1, this.SrcAddr2, this.SrcAddr3)
+}
+
+// DstAddr returns the destination address of the packet.
+func (this *UdpPacket) DstAddr() netip.Addr {
+	return wordsAddr(this.IsIPv4(), this.DstAddr0, this.DstAddr1, this.DstAddr2, this.DstAddr3)
+}
+
+// SrcAddrPort returns the source address and port of the packet.
+func (this *UdpPacket) SrcAddrPort() netip.AddrPort {
+	return netip.AddrPortFrom(this.SrcAddr(), this.Source)
+}
+
+// DstAddrPort returns the destination address and port of the packet.
+func (this *UdpPacket) DstAddrPort() netip.AddrPort {
+	return netip.AddrPortFrom(this.DstAddr(), this.Dest)
+}
+
+// FlowKey returns the FlowKey of the packet.
+func (this *UdpPacket) FlowKey() FlowKey {
+	return FlowKey{Src: this.SrcAddrPort(), Dst: this.DstAddrPort(), Proto: IpProtoUDP}
+}
+
+func (this *UdpPacket) Save() {
+	if !this.Saved {
+		this.Payload = append([]byte{}, this.Payload...)
+		this.Saved = true
+	}
+}
+
+func (this *UdpPacket) Clone() *UdpPacket {
+	dupe := *this
+	if !this.Saved {
+		dupe.Payload = append([]byte{}, this.Payload...)
+		dupe.Saved = true
+	}
+	return &dupe
+}
+
+// fail records e in the UdpPacket and returns it as an error.
+func (this *UdpPacket) fail(e DecodeError) error {
+	this.err = e
+	return &this.err
+}
+
+// decodeUdpPacket does the work of NewUdpPacketAllocless on the captured bytes
+// in b, see decodeInet.
+func decodeUdpPacket(b []byte, datalinkType int32, packet *UdpPacket) error {
+	var h inetPacket
+	b, e, ok := decodeInet(b, datalinkType, &h)
+	if !ok {
+		return packet.fail(e)
+	}
+	if h.proto != IpProtoUDP {
+		return packet.fail(DecodeError{Reason: DecodeNotUDP, Hdr: h.ipHdrName(), Value: int(h.proto)})
+	}
+	return packet.decode(b, &h)
+}
+
+// decodeTransport does the work of NewTransportAllocless on the captured bytes
+// in b.  It decodes a TCP packet into tcp or a UDP packet into udp, and turns
+// down the other protocols, and those whose packet is nil.  At least one of
+// tcp and udp must not be nil.
+func decodeTransport(b []byte, datalinkType int32, tcp *TcpPacket, udp *UdpPacket) (uint8, error) {
+	var h inetPacket
+	b, e, ok := decodeInet(b, datalinkType, &h)
+	if !ok {
+		return 0, failTransport(tcp, udp, e)
+	}
+	switch {
+	case h.proto == IpProtoTCP && tcp != nil:
+		return h.proto, tcp.decode(b, &h)
+	case h.proto == IpProtoUDP && udp != nil:
+		return h.proto, udp.decode(b, &h)
+	case tcp != nil:
+		return h.proto, tcp.fail(DecodeError{Reason: DecodeNotTCP, Hdr: h.ipHdrName(), Value: int(h.proto)})
+	}
+	return h.proto, udp.fail(DecodeError{Reason: DecodeNotUDP, Hdr: h.ipHdrName(), Value: int(h.proto)})
+}
+
+// failTransport records e in tcp, or in udp if tcp is nil, and returns it as
+// an error.
+func failTransport(tcp *TcpPacket, udp *UdpPacket, e DecodeError) error {
+	if tcp != nil {
+		return tcp.fail(e)
+	}
+	return udp.fail(e)
+}
+
+// decode fills in packet from the UDP header at the start of b, which the
+// IP header h is in front of.  The payload is cut to the UDP length, which
+// has to fit in both the IP payload and the capture.
+func (packet *UdpPacket) decode(b []byte, h *inetPacket) error {
+	if len(b) < udpHdrLen {
+		return packet.fail(DecodeError{Reason: DecodeTruncated, Hdr: "udphdr", Value: udpHdrLen, Caplen: len(b)})
+	}
+	length := int(binary.BigEndian.Uint16(b[4:]))
+	if length < udpHdrLen || length > h.paylen {
+		return packet.fail(DecodeError{Reason: DecodeBadHeaderLength, Hdr: "udphdr", Field: "len", Value: length, Caplen: len(b)})
+	}
+	if length > len(b) {
+		return packet.fail(DecodeError{Reason: DecodeTruncated, Hdr: "udphdr", Value: length, Caplen: len(b)})
+	}
+
+	packet.DstAddr0, packet.DstAddr1, packet.DstAddr2, packet.DstAddr3 = h.dst[0], h.dst[1], h.dst[2], h.dst[3]
+	packet.SrcAddr0, packet.SrcAddr1, packet.SrcAddr2, packet.SrcAddr3 = h.src[0], h.src[1], h.src[2], h.src[3]
+	packet.OuterVlan, packet.InnerVlan = h.outerVlan, h.innerVlan
+	packet.ipv6 = h.ipv6
+	packet.Source = binary.BigEndian.Uint16(b)
+	packet.Dest = binary.BigEndian.Uint16(b[2:])
+	packet.Length = uint16(length)
+	packet.Payload = b[udpHdrLen:length:length]
+	packet.Saved = false
+
+	return nil
+}
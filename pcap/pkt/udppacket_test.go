@@ -0,0 +1,187 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkt
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// udpFrame returns an Ethernet frame with a UDP datagram from 5353 to 53
+// carrying payload, over IPv4 or IPv6.
+func udpFrame(t testing.TB, ip Hdr, payload string) []byte {
+	b, err := Serialize([]byte(payload),
+		&EthHdr{
+			DstAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02},
+			SrcAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01},
+		},
+		ip,
+		&UdpHdr{Source: 5353, Dest: 53})
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	return b
+}
+
+var (
+	udpIp  = &IpHdr{SrcAddr: net.IPv4(192, 168, 1, 2), DstAddr: net.IPv4(10, 0, 0, 1), IpFields: IpFields{Ttl: 64}}
+	udpIp6 = &Ip6Hdr{SrcAddr: net.ParseIP("2001:db8::1"), DstAddr: net.ParseIP("2001:db8::2"), HopLimit: 64}
+)
+
+// Make sure UDP datagrams decode to the same values on any host.
+func TestDecodeUdpPacket(t *testing.T) {
+	var p UdpPacket
+	frame := udpFrame(t, udpIp, "query")
+	if err := decodeUdpPacket(tagged(frame, EtherTypeDot1Q, 0x0064), LinkTypeEthernet, &p); err != nil {
+		t.Fatalf("decodeUdpPacket: %v", err)
+	}
+	if !p.IsIPv4() {
+		t.Error("p.IsIPv4() == false")
+	}
+	if src := addrBytes(p.SrcAddr0); !src.Equal(net.IPv4(192, 168, 1, 2)) {
+		t.Errorf("src (%s) != 192.168.1.2", src)
+	}
+	if dst := addrBytes(p.DstAddr0); !dst.Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Errorf("dst (%s) != 10.0.0.1", dst)
+	}
+	if p.Source != 5353 || p.Dest != 53 || p.Length != udpHdrLen+5 || p.OuterVlan != 100 {
+		t.Errorf("ports %d->%d len %d vlan %d != 5353->53 len 13 vlan 100", p.Source, p.Dest, p.Length, p.OuterVlan)
+	}
+	if !bytes.Equal(p.Payload, []byte("query")) {
+		t.Errorf("p.Payload (%q) != \"query\"", p.Payload)
+	}
+
+	// Ethernet padding is left out of the payload.
+	padded := append(append([]byte{}, udpFrame(t, udpIp6, "answer")...), 0, 0, 0, 0)
+	if err := decodeUdpPacket(padded, LinkTypeEthernet, &p); err != nil {
+		t.Fatalf("decodeUdpPacket: %v", err)
+	}
+	if p.IsIPv4() || p.OuterVlan != 0 {
+		t.Errorf("p.IsIPv4() = %t, p.OuterVlan = %d after reuse", p.IsIPv4(), p.OuterVlan)
+	}
+	src := addrBytes(p.SrcAddr0, p.SrcAddr1, p.SrcAddr2, p.SrcAddr3)
+	if !src.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("src (%s) != 2001:db8::1", src)
+	}
+	if !bytes.Equal(p.Payload, []byte("answer")) {
+		t.Errorf("p.Payload (%q) != \"answer\"", p.Payload)
+	}
+	if k := p.FlowKey(); k.String() != "[2001:db8::1]:5353->[2001:db8::2]:53 proto 17" {
+		t.Errorf("p.FlowKey() = %s", k)
+	}
+}
+
+// Make sure datagrams we can't handle are turned down for the right reason.
+func TestDecodeUdpPacketReject(t *testing.T) {
+	frame := udpFrame(t, udpIp, "query")
+	udpOff := ethHdrLen + ipHdrLen
+	for name, c := range map[string]struct {
+		frame  []byte
+		reason DecodeReason
+		value  int
+	}{
+		"tcp":       {tcp4EthFrame, DecodeNotUDP, int(IpProtoTCP)},
+		"short len": {patch(frame, udpOff+4, 0, 7), DecodeBadHeaderLength, 7},
+		"long len":  {patch(frame, udpOff+4, 0, 14), DecodeBadHeaderLength, 14},
+		"truncated": {frame[:udpOff+4], DecodeTruncated, udpHdrLen},
+		"snaplen":   {patch(frame, ethHdrLen+ipTotLenOff, 0, 0xff)[:len(frame)-1], DecodeTruncated, udpHdrLen + 5},
+	} {
+		var p UdpPacket
+		err := decodeUdpPacket(c.frame, LinkTypeEthernet, &p)
+		e, ok := err.(*DecodeError)
+		if !ok {
+			t.Errorf("%s: decodeUdpPacket = %v, want a *DecodeError", name, err)
+			continue
+		}
+		if e.Reason != c.reason || e.Value != c.value {
+			t.Errorf("%s: decodeUdpPacket = %v, want %s %d", name, e, c.reason, c.value)
+		}
+	}
+}
+
+// Make sure TCP and UDP packets each go to their own packet, and that the
+// protocols without one are turned down.
+func TestDecodeTransport(t *testing.T) {
+	udp := udpFrame(t, udpIp6, "query")
+	for name, c := range map[string]struct {
+		frame    []byte
+		tcp, udp bool
+		proto    uint8
+		reason   DecodeReason
+	}{
+		"tcp":         {tcp4EthFrame, true, true, IpProtoTCP, NumDecodeReasons},
+		"udp":         {udp, true, true, IpProtoUDP, NumDecodeReasons},
+		"udp only":    {udp, false, true, IpProtoUDP, NumDecodeReasons},
+		"tcp no tcp":  {tcp4EthFrame, false, true, IpProtoTCP, DecodeNotUDP},
+		"udp no udp":  {udp, true, false, IpProtoUDP, DecodeNotTCP},
+		"icmp":        {fragNeededFrame, true, true, IpProtoICMP, DecodeNotTCP},
+		"icmp no tcp": {fragNeededFrame, false, true, IpProtoICMP, DecodeNotUDP},
+		"arp":         {arpEthFrame, true, true, 0, DecodeUnsupportedEtherType},
+	} {
+		var tp TcpPacket
+		var up UdpPacket
+		tcp, udp := &tp, &up
+		if !c.tcp {
+			tcp = nil
+		}
+		if !c.udp {
+			udp = nil
+		}
+		proto, err := decodeTransport(c.frame, LinkTypeEthernet, tcp, udp)
+		if proto != c.proto {
+			t.Errorf("%s: proto = %d, want %d", name, proto, c.proto)
+		}
+		if c.reason == NumDecodeReasons {
+			if err != nil {
+				t.Errorf("%s: decodeTransport: %v", name, err)
+			}
+			continue
+		}
+		if e, ok := err.(*DecodeError); !ok || e.Reason != c.reason {
+			t.Errorf("%s: decodeTransport = %v, want %s", name, err, c.reason)
+		}
+	}
+}
+
+// Make sure Save and Clone copy the payload out of the capture, and that a
+// saved packet is mapped again once it is reused.
+func TestUdpPacketSave(t *testing.T) {
+	frame := udpFrame(t, udpIp, "query")
+	var p UdpPacket
+	if err := decodeUdpPacket(frame, LinkTypeEthernet, &p); err != nil {
+		t.Fatalf("decodeUdpPacket: %v", err)
+	}
+	c := p.Clone()
+	p.Save()
+	frame[len(frame)-1] = '?'
+	if string(p.Payload) != "query" || string(c.Payload) != "query" || !p.Saved || !c.Saved {
+		t.Errorf("payloads %q %q, want them saved", p.Payload, c.Payload)
+	}
+	if err := decodeUdpPacket(frame, LinkTypeEthernet, &p); err != nil {
+		t.Fatalf("decodeUdpPacket: %v", err)
+	}
+	if p.Saved || string(p.Clone().Payload) != "quer?" {
+		t.Errorf("p.Saved = %t after reuse", p.Saved)
+	}
+}
+
+// Make sure the UDP fast path stays free of heap allocations, failures
+// included.
+func TestDecodeUdpPacketAllocs(t *testing.T) {
+	var tp TcpPacket
+	var up UdpPacket
+	udp := udpFrame(t, udpIp, "query")
+	allocs := testing.AllocsPerRun(100, func() {
+		decodeUdpPacket(udp, LinkTypeEthernet, &up)
+		decodeUdpPacket(tcp4EthFrame, LinkTypeEthernet, &up)
+		decodeTransport(udp, LinkTypeEthernet, &tp, &up)
+		decodeTransport(tcp6SllFrame, LinkTypeLinuxSLL, &tp, &up)
+		decodeTransport(arpEthFrame, LinkTypeEthernet, nil, &up)
+	})
+	if allocs != 0 {
+		t.Errorf("decodeUdpPacket allocs = %v, want 0", allocs)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/VividCortex/golibpcap/pcap/pkt"
+)
+
+// An ARPBinding is an IP address to MAC address mapping announced over ARP.
+type ARPBinding struct {
+	IP        net.IP           // The protocol address being claimed
+	MAC       net.HardwareAddr // The hardware address claiming it
+	StartTime time.Time        // Time of the first packet with this binding
+	EndTime   time.Time        // Time of the last packet with this binding
+	Packets   int              // Number of packets announcing this binding
+}
+
+// ARPEventType says what an ARPEvent is about.
+type ARPEventType int
+
+const (
+	ARPGratuitous ARPEventType = iota // A host announced its own binding
+	ARPConflict                       // A binding changed to another MAC
+)
+
+// An ARPEvent is an ARP packet worth a closer look.
+type ARPEvent struct {
+	Type   ARPEventType
+	Packet *pkt.Packet // The packet that raised the event
+	IP     net.IP      // The protocol address involved
+	MAC    net.HardwareAddr
+	Prev   *ARPBinding // For an ARPConflict, the binding that was replaced
+}
+
+// An ARPTable follows the IP to MAC address bindings that the ARP traffic in
+// a trace announces.  Only the sender side of a packet claims anything, and
+// RFC 5227 probes, which have no sender address, claim nothing.
+type ARPTable struct {
+	Bindings map[string]*ARPBinding // The current bindings keyed by IP string
+	History  []*ARPBinding          // Every binding seen, in order of appearance
+	Events   []*ARPEvent            // Gratuitous ARPs and conflicts, in order
+}
+
+// NewARPTable returns an empty ARPTable.
+func NewARPTable() *ARPTable {
+	return &ARPTable{Bindings: make(map[string]*ARPBinding)}
+}
+
+// GetARPTable runs all the ARP traffic in the slice of pkt.Packet through a
+// new ARPTable.  The packets should be in capture order.
+func GetARPTable(d []*pkt.Packet) *ARPTable {
+	t := NewARPTable()
+	for i := range d {
+		_ = t.AddPacket(d[i])
+	}
+	return t
+}
+
+// AddPacket updates the ARPTable with the binding announced by the
+// pkt.Packet and records an ARPEvent when the packet is a gratuitous ARP or
+// moves an IP address to another MAC address.
+func (t *ARPTable) AddPacket(p *pkt.Packet) error {
+	arp, ok := p.Headers[pkt.NetworkLayer].(*pkt.ArpHdr)
+	if !ok || arp == nil {
+		return ErrNetworkLayerHeader
+	}
+	if arp.IsProbe() {
+		return nil
+	}
+	if arp.IsGratuitous() {
+		t.Events = append(t.Events, &ARPEvent{
+			Type:   ARPGratuitous,
+			Packet: p,
+			IP:     arp.SenderProtoAddr,
+			MAC:    arp.SenderHwAddr,
+		})
+	}
+	key := arp.SenderProtoAddr.String()
+	b := t.Bindings[key]
+	if b != nil && bytes.Equal(b.MAC, arp.SenderHwAddr) {
+		b.EndTime = p.Time
+		b.Packets++
+		return nil
+	}
+	if b != nil {
+		t.Events = append(t.Events, &ARPEvent{
+			Type:   ARPConflict,
+			Packet: p,
+			IP:     arp.SenderProtoAddr,
+			MAC:    arp.SenderHwAddr,
+			Prev:   b,
+		})
+	}
+	b = &ARPBinding{
+		IP:        arp.SenderProtoAddr,
+		MAC:       arp.SenderHwAddr,
+		StartTime: p.Time,
+		EndTime:   p.Time,
+		Packets:   1,
+	}
+	t.Bindings[key] = b
+	t.History = append(t.History, b)
+	return nil
+}
+
+// Lookup returns the MAC address currently bound to ip, or nil.
+func (t *ARPTable) Lookup(ip net.IP) net.HardwareAddr {
+	if b := t.Bindings[ip.String()]; b != nil {
+		return b.MAC
+	}
+	return nil
+}
+
+// Conflicts returns the ARPConflict events of the ARPTable.
+func (t *ARPTable) Conflicts() []*ARPEvent {
+	var c []*ARPEvent
+	for _, e := range t.Events {
+		if e.Type == ARPConflict {
+			c = append(c, e)
+		}
+	}
+	return c
+}
+
+// String returns a minimal encoding of the ARPBinding.
+func (b *ARPBinding) String() string {
+	return fmt.Sprintf("%s is-at %s (%d packets)", b.IP, b.MAC, b.Packets)
+}
+
+// String returns a minimal encoding of the ARPEvent.
+func (e *ARPEvent) String() string {
+	if e.Type == ARPConflict {
+		return fmt.Sprintf("%s %s moved from %s to %s", e.Packet.Time, e.IP, e.Prev.MAC, e.MAC)
+	}
+	return fmt.Sprintf("%s gratuitous %s is-at %s", e.Packet.Time, e.IP, e.MAC)
+}
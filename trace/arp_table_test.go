@@ -0,0 +1,55 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/VividCortex/golibpcap/pcap/pkt"
+)
+
+// arpPacket returns a packet with an ARP header sent at second s.
+func arpPacket(s int, op uint16, sha string, spa, tpa string) *pkt.Packet {
+	mac, _ := net.ParseMAC(sha)
+	return &pkt.Packet{
+		Time: time.Unix(int64(s), 0),
+		Headers: []pkt.Hdr{nil, &pkt.ArpHdr{
+			Op:              op,
+			SenderHwAddr:    mac,
+			SenderProtoAddr: net.ParseIP(spa).To4(),
+			TargetHwAddr:    make(net.HardwareAddr, 6),
+			TargetProtoAddr: net.ParseIP(tpa).To4(),
+		}, nil},
+	}
+}
+
+func TestARPTable(t *testing.T) {
+	const a, b = "00:11:22:33:44:55", "66:77:88:99:aa:bb"
+	tbl := GetARPTable([]*pkt.Packet{
+		arpPacket(1, pkt.ArpRequest, a, "10.0.0.1", "10.0.0.2"),
+		arpPacket(2, pkt.ArpRequest, a, "0.0.0.0", "10.0.0.3"), // probe
+		arpPacket(3, pkt.ArpReply, a, "10.0.0.1", "10.0.0.2"),
+		arpPacket(4, pkt.ArpRequest, b, "10.0.0.1", "10.0.0.1"), // gratuitous, conflict
+		{Headers: []pkt.Hdr{nil, nil, nil}},
+	})
+	if len(tbl.Bindings) != 1 || len(tbl.History) != 2 {
+		t.Fatalf("%d bindings, %d in history, want 1 and 2", len(tbl.Bindings), len(tbl.History))
+	}
+	if mac := tbl.Lookup(net.ParseIP("10.0.0.1")); mac.String() != b {
+		t.Errorf("Lookup(10.0.0.1) = %s, want %s", mac, b)
+	}
+	if tbl.History[0].Packets != 2 || tbl.History[0].EndTime.Unix() != 3 {
+		t.Errorf("History[0] = %s until %s", tbl.History[0], tbl.History[0].EndTime)
+	}
+	if len(tbl.Events) != 2 || tbl.Events[0].Type != ARPGratuitous {
+		t.Fatalf("Events = %v, want a gratuitous ARP and a conflict", tbl.Events)
+	}
+	c := tbl.Conflicts()
+	if len(c) != 1 || c[0].Prev.MAC.String() != a || c[0].MAC.String() != b {
+		t.Errorf("Conflicts() = %v", c)
+	}
+}
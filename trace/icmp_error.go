@@ -0,0 +1,118 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/VividCortex/golibpcap/pcap/pkt"
+)
+
+// An ICMPError ties an ICMP error message to the flow of the datagram that
+// triggered it.
+type ICMPError struct {
+	Packet   *pkt.Packet   // The packet carrying the ICMP message
+	IcmpHdr  *pkt.IcmpHdr  // The ICMP message, for IPv4
+	Icmp6Hdr *pkt.Icmp6Hdr // The ICMPv6 message, for IPv6
+	Reporter net.IP        // The router or host that sent the ICMP message
+	IPTuple  *IPTuple      // The addresses of the quoted datagram
+	TCPTuple *TCPTuple     // The addresses of the quoted segment, if it was TCP
+}
+
+// NewICMPError constructs an ICMPError from the information in the pkt.Packet
+// headers.  This fails unless the packet is an ICMP or ICMPv6 error message
+// quoting an IP datagram.
+func NewICMPError(p *pkt.Packet) (*ICMPError, error) {
+	e := &ICMPError{Packet: p}
+	ipHdr, ok := p.Headers[pkt.NetworkLayer].(pkt.InetProtoHdr)
+	if !ok || ipHdr == nil {
+		return e, ErrNetworkLayerHeader
+	}
+	e.Reporter = ipHdr.Src()
+	var q *pkt.IcmpQuote
+	switch h := p.Headers[pkt.TransportLayer].(type) {
+	case *pkt.IcmpHdr:
+		e.IcmpHdr, q = h, h.Quote
+	case *pkt.Icmp6Hdr:
+		e.Icmp6Hdr, q = h, h.Quote()
+	}
+	if q == nil {
+		return e, ErrTransportLayerHeader
+	}
+	e.IPTuple = &IPTuple{Src: q.SrcAddr, Dst: q.DstAddr}
+	if q.Protocol == pkt.IpProtoTCP {
+		e.TCPTuple = &TCPTuple{
+			Src: &net.TCPAddr{IP: q.SrcAddr, Port: int(q.Source)},
+			Dst: &net.TCPAddr{IP: q.DstAddr, Port: int(q.Dest)},
+		}
+		e.TCPTuple.setTunnel(p)
+	}
+	return e, nil
+}
+
+// GetICMPErrors finds the ICMP error messages in the slice of pkt.Packet and
+// groups them by the flow they are about.  Errors about TCP segments are keyed
+// like the TCPFlow from GetTCPTraffic, and the others like the IPFlow from
+// GetIPTraffic, so the two maps can be used together.
+func GetICMPErrors(d []*pkt.Packet) map[string][]*ICMPError {
+	m := make(map[string][]*ICMPError)
+	for i := range d {
+		e, err := NewICMPError(d[i])
+		if err != nil {
+			continue
+		}
+		key := e.IPTuple.String()
+		if e.TCPTuple != nil {
+			t := *e.TCPTuple
+			if t.srcByPort(ServerSrcPort) == nil {
+				key = t.String()
+			}
+		}
+		m[key] = append(m[key], e)
+	}
+	return m
+}
+
+// ICMPErrors returns the ICMP error messages in d about segments of the
+// TCPFlow, in either direction.
+func (f *TCPFlow) ICMPErrors(d []*pkt.Packet) []*ICMPError {
+	var s []*ICMPError
+	for i := range d {
+		e, err := NewICMPError(d[i])
+		if err != nil || e.TCPTuple == nil {
+			continue
+		}
+		if f.TCPTuple.MatchFlow(e.TCPTuple) {
+			s = append(s, e)
+		}
+	}
+	return s
+}
+
+// MTU returns the next-hop MTU reported by a fragmentation needed or packet
+// too big message, or 0 for any other ICMP error.  A flow that keeps sending
+// segments larger than this without getting through is likely behind a PMTU
+// black hole.
+func (e *ICMPError) MTU() int {
+	if e.Icmp6Hdr != nil {
+		if b, ok := e.Icmp6Hdr.Body.(*pkt.Icmp6TooBig); ok {
+			return int(b.MTU)
+		}
+		return 0
+	}
+	if e.IcmpHdr.Type == pkt.IcmpDestUnreachable && e.IcmpHdr.Code == pkt.IcmpFragNeeded {
+		return int(e.IcmpHdr.MTU)
+	}
+	return 0
+}
+
+// String returns a minimal encoding of the ICMPError.
+func (e *ICMPError) String() string {
+	if e.Icmp6Hdr != nil {
+		return fmt.Sprintf("%s %s: %s", e.Packet.Time, e.Reporter, e.Icmp6Hdr)
+	}
+	return fmt.Sprintf("%s %s: %s", e.Packet.Time, e.Reporter, e.IcmpHdr)
+}
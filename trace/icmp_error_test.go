@@ -0,0 +1,75 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"net"
+	"testing"
+
+	"github.com/VividCortex/golibpcap/pcap/pkt"
+)
+
+// icmpPacket returns a packet from a router carrying an ICMP error about a
+// datagram from src to dst.
+func icmpPacket(typ, code uint8, proto uint8, src, dst *net.TCPAddr) *pkt.Packet {
+	return &pkt.Packet{
+		Headers: []pkt.Hdr{nil, &pkt.IpHdr{
+			SrcAddr:  net.IPv4(172, 16, 0, 1).To4(),
+			DstAddr:  src.IP,
+			Protocol: pkt.IpProtoICMP,
+		}, &pkt.IcmpHdr{
+			Type: typ,
+			Code: code,
+			MTU:  1400,
+			Quote: &pkt.IcmpQuote{
+				SrcAddr:  src.IP,
+				DstAddr:  dst.IP,
+				Protocol: proto,
+				Source:   uint16(src.Port),
+				Dest:     uint16(dst.Port),
+			},
+		}},
+	}
+}
+
+func TestGetICMPErrors(t *testing.T) {
+	client := &net.TCPAddr{IP: net.IPv4(192, 168, 1, 2).To4(), Port: 50000}
+	server := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1).To4(), Port: ServerSrcPort}
+	d := []*pkt.Packet{
+		icmpPacket(pkt.IcmpDestUnreachable, pkt.IcmpFragNeeded, pkt.IpProtoTCP, server, client),
+		icmpPacket(pkt.IcmpTimeExceeded, 0, pkt.IpProtoUDP, client, server),
+		{Headers: []pkt.Hdr{nil, nil, nil}},
+	}
+	m := GetICMPErrors(d)
+	flow := &TCPTuple{Src: server, Dst: client}
+	tcp := m[flow.String()]
+	if len(tcp) != 1 || tcp[0].MTU() != 1400 || !tcp[0].Reporter.Equal(net.IPv4(172, 16, 0, 1)) {
+		t.Errorf("m[%s] = %v, want one fragmentation needed error", flow, tcp)
+	}
+	ip := (&IPTuple{Src: client.IP, Dst: server.IP}).String()
+	if udp := m[ip]; len(udp) != 1 || udp[0].MTU() != 0 || udp[0].TCPTuple != nil {
+		t.Errorf("m[%s] = %v, want one time exceeded error", ip, udp)
+	}
+
+	// ICMPv6 errors are tied to flows the same way.
+	six := &pkt.Packet{Headers: []pkt.Hdr{nil, &pkt.Ip6Hdr{SrcAddr: net.ParseIP("2001:db8::ff")}, &pkt.Icmp6Hdr{
+		Type: pkt.Icmp6PacketTooBig,
+		Body: &pkt.Icmp6TooBig{MTU: 1280, Quote: &pkt.IcmpQuote{
+			SrcAddr:  net.ParseIP("2001:db8::1"),
+			DstAddr:  net.ParseIP("2001:db8::2"),
+			Protocol: pkt.IpProtoTCP,
+			Source:   uint16(ServerSrcPort),
+			Dest:     50000,
+		}},
+	}}}
+	if e, err := NewICMPError(six); err != nil || e.MTU() != 1280 || e.TCPTuple == nil {
+		t.Errorf("NewICMPError(six) = %v, %v, want a packet too big error", e, err)
+	}
+
+	f := &TCPFlow{TCPTuple: &TCPTuple{Src: client, Dst: server}}
+	if s := f.ICMPErrors(d); len(s) != 1 || s[0].Packet != d[0] {
+		t.Errorf("f.ICMPErrors = %v, want the fragmentation needed error", s)
+	}
+}
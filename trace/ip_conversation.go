@@ -0,0 +1,158 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/VividCortex/golibpcap/pcap/pkt"
+)
+
+// An IPConversation merges both directions of the IP traffic between two
+// hosts.  The source is whichever host sent the first packet.
+type IPConversation struct {
+	IPTuple    *IPTuple                                // The source and destination addresses
+	Data       []*pkt.Packet                           // The array of packets
+	StartTime  time.Time                               // Time of the first packet
+	EndTime    time.Time                               // Time of the last packet
+	SrcBytes   int64                                   // Number of bytes Src->Dst
+	DstBytes   int64                                   // Number of bytes Dst->Src
+	SrcPLBytes int64                                   // Number of IP payload bytes Src->Dst
+	DstPLBytes int64                                   // Number of IP payload bytes Dst->Src
+	SrcPktCnt  int64                                   // Number of packets Src->Dst
+	DstPktCnt  int64                                   // Number of packets Dst->Src
+	Protos     map[uint8]*ConversationCount            // The counts by IP protocol number
+	Ports      map[ConversationPort]*ConversationCount // The TCP/UDP counts by port
+}
+
+// A ConversationCount holds the per-direction counts of a part of an
+// IPConversation.
+type ConversationCount struct {
+	SrcBytes  int64 // Number of bytes Src->Dst
+	DstBytes  int64 // Number of bytes Dst->Src
+	SrcPktCnt int64 // Number of packets Src->Dst
+	DstPktCnt int64 // Number of packets Dst->Src
+}
+
+// add counts a packet of n bytes in the direction given by downstream.
+func (c *ConversationCount) add(n int, downstream bool) {
+	if downstream {
+		c.SrcBytes += int64(n)
+		c.SrcPktCnt++
+	} else {
+		c.DstBytes += int64(n)
+		c.DstPktCnt++
+	}
+}
+
+// A ConversationPort keys the TCP and UDP traffic of an IPConversation.  Port
+// is the lower of the two ports of a packet, which is usually the well-known
+// port of the service, so that the ephemeral ports of the client side don't
+// each get their own count.
+type ConversationPort struct {
+	Proto uint8  // pkt.IpProtoTCP or pkt.IpProtoUDP
+	Port  uint16 // The service port
+}
+
+// String returns the protocol and port of a ConversationPort, as in "tcp/80".
+func (p ConversationPort) String() string {
+	switch p.Proto {
+	case pkt.IpProtoTCP:
+		return fmt.Sprintf("tcp/%d", p.Port)
+	case pkt.IpProtoUDP:
+		return fmt.Sprintf("udp/%d", p.Port)
+	}
+	return fmt.Sprintf("%d/%d", p.Proto, p.Port)
+}
+
+// NewIPConversation returns an empty IPConversation between the hosts of t.
+func NewIPConversation(t *IPTuple) *IPConversation {
+	return &IPConversation{
+		IPTuple: t,
+		Protos:  make(map[uint8]*ConversationCount),
+		Ports:   make(map[ConversationPort]*ConversationCount),
+	}
+}
+
+// GetIPConversations separates all the IP traffic in the slice of pkt.Packet
+// into the conversations between each pair of hosts.  Both directions go to
+// the same IPConversation, keyed by the string of the IPTuple of its first
+// packet.
+func GetIPConversations(d []*pkt.Packet) map[string]*IPConversation {
+	m := make(map[string]*IPConversation)
+	for i := range d {
+		t, err := NewIPTuple(d[i])
+		if err != nil {
+			continue
+		}
+		c := m[t.String()]
+		if c == nil {
+			c = m[t.Reverse().String()]
+		}
+		if c == nil {
+			c = NewIPConversation(t)
+			m[t.String()] = c
+		}
+		_ = c.AddPacket(d[i])
+	}
+	return m
+}
+
+// AddPacket updates the meta data for an IPConversation and saves a reference
+// to the pkt.Packet.  Packets from c.IPTuple.Src count as Src->Dst and any
+// others as Dst->Src (it does not check for matching addresses).
+func (c *IPConversation) AddPacket(p *pkt.Packet) error {
+	ip, ok := p.Headers[pkt.NetworkLayer].(pkt.InetProtoHdr)
+	if !ok || ip == nil {
+		return ErrNetworkLayerHeader
+	}
+	if p.Time.Before(c.StartTime) || c.StartTime.IsZero() {
+		c.StartTime = p.Time
+	}
+	if p.Time.After(c.EndTime) || c.EndTime.IsZero() {
+		c.EndTime = p.Time
+	}
+	downstream := c.IPTuple.Src.Equal(ip.Src())
+	if downstream {
+		c.SrcBytes += int64(p.Len)
+		c.SrcPLBytes += int64(ip.PL())
+		c.SrcPktCnt++
+	} else {
+		c.DstBytes += int64(p.Len)
+		c.DstPLBytes += int64(ip.PL())
+		c.DstPktCnt++
+	}
+
+	proto := ip.Proto()
+	pc := c.Protos[proto]
+	if pc == nil {
+		pc = &ConversationCount{}
+		c.Protos[proto] = pc
+	}
+	pc.add(int(p.Len), downstream)
+
+	h, ok := p.Headers[pkt.TransportLayer].(pkt.PortHdr)
+	if ok && h != nil && (proto == pkt.IpProtoTCP || proto == pkt.IpProtoUDP) {
+		src, dst := h.Ports()
+		k := ConversationPort{Proto: proto, Port: src}
+		if dst < src {
+			k.Port = dst
+		}
+		pc = c.Ports[k]
+		if pc == nil {
+			pc = &ConversationCount{}
+			c.Ports[k] = pc
+		}
+		pc.add(int(p.Len), downstream)
+	}
+	c.Data = append(c.Data, p)
+	return nil
+}
+
+// Bytes returns the number of bytes in both directions of the IPConversation.
+func (c *IPConversation) Bytes() int64 {
+	return c.SrcBytes + c.DstBytes
+}
@@ -0,0 +1,66 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"net"
+	"testing"
+
+	"github.com/VividCortex/golibpcap/pcap/pkt"
+)
+
+// Make sure both directions between two hosts go to one IPConversation, with
+// the counts broken down by protocol and by service port.
+func TestGetIPConversations(t *testing.T) {
+	client2 := &net.UDPAddr{IP: dnsClient.IP, Port: 5354}
+	query := udpPacket(t, dnsClient, dnsServer, "query", 0)
+	answer := udpPacket(t, dnsServer, dnsClient, "answer", 10)
+	again := udpPacket(t, client2, dnsServer, "query", 20)
+	tcp := rawPacket(t) // 192.168.1.2:50000 -> 10.0.0.1:80
+	other := udpPacket(t, dnsClient, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2).To4(), Port: 53}, "query", 30)
+	m := GetIPConversations([]*pkt.Packet{query, answer, again, tcp, other})
+	if len(m) != 2 {
+		t.Fatalf("len(GetIPConversations) = %d, want 2", len(m))
+	}
+	c := m["192.168.1.2->10.0.0.1"]
+	if c == nil {
+		t.Fatalf("GetIPConversations = %v, want a conversation from 192.168.1.2", m)
+	}
+	if c.SrcPktCnt != 3 || c.DstPktCnt != 1 || len(c.Data) != 4 {
+		t.Errorf("packets %d/%d, want 3/1", c.SrcPktCnt, c.DstPktCnt)
+	}
+	srcBytes := int64(query.Len + again.Len + tcp.Len)
+	if c.SrcBytes != srcBytes || c.DstBytes != int64(answer.Len) || c.Bytes() != srcBytes+int64(answer.Len) {
+		t.Errorf("bytes %d/%d, want %d/%d", c.SrcBytes, c.DstBytes, srcBytes, answer.Len)
+	}
+	if c.SrcPLBytes != 8+5+8+5+20+6 || c.DstPLBytes != 8+6 {
+		t.Errorf("payload bytes %d/%d", c.SrcPLBytes, c.DstPLBytes)
+	}
+
+	udp := c.Protos[pkt.IpProtoUDP]
+	if udp == nil || udp.SrcPktCnt != 2 || udp.DstPktCnt != 1 {
+		t.Errorf("c.Protos[udp] = %+v, want 2/1 packets", udp)
+	}
+	if p := c.Protos[pkt.IpProtoTCP]; p == nil || p.SrcPktCnt != 1 || p.SrcBytes != int64(tcp.Len) {
+		t.Errorf("c.Protos[tcp] = %+v, want 1 packet of %d bytes", p, tcp.Len)
+	}
+	if len(c.Ports) != 2 {
+		t.Errorf("c.Ports = %v, want udp/53 and tcp/80", c.Ports)
+	}
+	dns := ConversationPort{Proto: pkt.IpProtoUDP, Port: 53}
+	if p := c.Ports[dns]; p == nil || *p != *udp {
+		t.Errorf("c.Ports[%s] = %+v, want %+v", dns, p, udp)
+	}
+	http := ConversationPort{Proto: pkt.IpProtoTCP, Port: 80}
+	if p := c.Ports[http]; p == nil || p.SrcPktCnt != 1 || p.DstPktCnt != 0 {
+		t.Errorf("c.Ports[%s] = %+v, want 1/0 packets", http, p)
+	}
+	if s := http.String(); s != "tcp/80" {
+		t.Errorf("http.String() = %q, want \"tcp/80\"", s)
+	}
+	if !c.StartTime.Equal(query.Time) || !c.EndTime.Equal(again.Time) {
+		t.Errorf("times %v - %v", c.StartTime, c.EndTime)
+	}
+}
@@ -47,3 +47,9 @@ func (t *IPTuple) Equal(x *IPTuple) bool {
 	}
 	return false
 }
+
+// Reverse returns an IPTuple with the source and destination addresses of t
+// swapped.
+func (t *IPTuple) Reverse() *IPTuple {
+	return &IPTuple{Src: t.Dst, Dst: t.Src}
+}
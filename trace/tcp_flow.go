@@ -159,11 +159,13 @@ func (f *TCPFlow) Analyze() (*TCPFlowStats, error) {
 // Aggregate packet-level data for a TCPFlow.  This is usually returned from a
 // call to TCPFlow.Analysis().
 type TCPFlowStats struct {
+	DstCorrupt   int    // Number of Dst packets with a bad checksum
 	DstDupAck    int    // Total number of duplicate Dst ACKs
 	DstLoss      int    // Number retransmitted Dst packets
 	DstLossBytes uint32 // Number of retransmitted Dst bytes
 	DstOrder     int    // Number of out-of-order Dst packets
 	DstOther     int    // Number of other non-normal Dst packets
+	SrcCorrupt   int    // Number of Src packets with a bad checksum
 	SrcDupAck    int    // Total number of duplicate Src ACKs sent
 	SrcLoss      int    // Number retransmitted Src packets
 	SrcLossBytes uint32 // Number of retransmitted Src bytes
@@ -174,7 +176,10 @@ type TCPFlowStats struct {
 // Analysis computes the aggregate packet-level data for a TCPFlow.  This does
 // not take into account packets that may have been dropped by the pcap trace
 // and the analysis of such traces will be less accurate than those without any
-// missing packets.
+// missing packets.  Packets with a bad checksum would have been dropped by the
+// receiver, so they are counted as corrupt and left out of the rest of the
+// analysis, which then sees their retransmission as the first copy.  Checksums
+// that look offloaded are taken to be good.
 func TCPFlowAnalysis(d []*pkt.Packet, t *TCPTuple) (*TCPFlowStats, error) {
 	var (
 		ip           pkt.InetProtoHdr // IP header - to access the IP payload length
@@ -200,6 +205,14 @@ func TCPFlowAnalysis(d []*pkt.Packet, t *TCPTuple) (*TCPFlowStats, error) {
 		if !ok || tcp == nil {
 			return fs, ErrTransportLayerHeader
 		}
+		if d[i].Checksum(nil) == pkt.ChecksumInvalid {
+			if int(tcp.Source) == t.Src.Port {
+				fs.SrcCorrupt++
+			} else {
+				fs.DstCorrupt++
+			}
+			continue
+		}
 		pl = tcp.PayloadLen(ip.PL())
 		if int(tcp.Source) == t.Src.Port {
 			if tcp.AckSeq > srcAck {
@@ -0,0 +1,74 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"net"
+	"testing"
+
+	"github.com/VividCortex/golibpcap/pcap/pkt"
+)
+
+// vxlanPacket returns the decoded VXLAN packet with the given VNI carrying
+// the frame inner.
+func vxlanPacket(t *testing.T, vni uint32, inner []byte) *pkt.Packet {
+	frame, err := pkt.Serialize(inner,
+		&pkt.EthHdr{
+			DstAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02},
+			SrcAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01},
+		},
+		&pkt.IpHdr{
+			SrcAddr:  net.IPv4(172, 16, 0, 1),
+			DstAddr:  net.IPv4(172, 16, 0, 2),
+			IpFields: pkt.IpFields{Ttl: 64},
+		},
+		&pkt.UdpHdr{Source: 50123, Dest: pkt.VxlanPort},
+		&pkt.VxlanHdr{Flags: pkt.VxlanVNIValid, VNI: vni})
+	if err != nil {
+		t.Fatalf("pkt.Serialize: %v", err)
+	}
+	return pkt.DecodePacket(frame, pkt.LinkTypeEthernet)
+}
+
+// Make sure tunneled packets are sorted into flows by the packet inside, and
+// that the same addresses in different tunnels make different flows.
+func TestGetTCPTrafficTunnel(t *testing.T) {
+	inner := rawPacket(t)
+	d := []*pkt.Packet{
+		vxlanPacket(t, 1, inner.Raw),
+		vxlanPacket(t, 2, inner.Raw),
+		vxlanPacket(t, 1, inner.Raw),
+		inner,
+	}
+	m := GetTCPTraffic(d)
+	if len(m) != 3 {
+		t.Fatalf("len(m) = %d, want 3: %v", len(m), m)
+	}
+	server := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: ServerSrcPort}
+	client := &net.TCPAddr{IP: net.IPv4(192, 168, 1, 2), Port: 50000}
+	for _, c := range []struct {
+		tuple *TCPTuple
+		pkts  int64
+	}{
+		{&TCPTuple{Src: server, Dst: client, TunnelKey: 1, HasTunnelKey: true}, 2},
+		{&TCPTuple{Src: server, Dst: client, TunnelKey: 2, HasTunnelKey: true}, 1},
+		{&TCPTuple{Src: server, Dst: client}, 1},
+	} {
+		f := m[c.tuple.String()]
+		if f == nil {
+			t.Errorf("no flow %s", c.tuple)
+			continue
+		}
+		if !f.TCPTuple.Equal(c.tuple) || f.DstPktCnt != c.pkts {
+			t.Errorf("flow %s has %d packets from %s, want %d", c.tuple, f.DstPktCnt, f.TCPTuple, c.pkts)
+		}
+	}
+
+	a, _ := NewTCPTuple(d[0])
+	b, _ := NewTCPTuple(d[1])
+	if a.MatchFlow(b) {
+		t.Errorf("%s matches %s", a, b)
+	}
+}
@@ -12,14 +12,19 @@ import (
 )
 
 // A TCPTuple encapsulates the source and destination address for analysing
-// TCP/IP packet traces.
+// TCP/IP packet traces.  Packets that came out of a tunnel are told apart by
+// the VNI or key of the tunnel too, since the networks it carries can reuse
+// the same addresses.
 type TCPTuple struct {
-	Src *net.TCPAddr // The source IP address
-	Dst *net.TCPAddr // The destination IP address
+	Src          *net.TCPAddr // The source IP address
+	Dst          *net.TCPAddr // The destination IP address
+	TunnelKey    uint32       // The VNI or GRE key of the innermost tunnel
+	HasTunnelKey bool         // Whether the packets came with a TunnelKey
 }
 
 // NewTCPTuple constructs a TCPTuple from the information in the pkt.Packet
-// headers.  This assumes that the packet is a TCP/IP packet.
+// headers.  This assumes that the packet is a TCP/IP packet.  For a packet
+// that came out of a tunnel the addresses are those of the packet inside.
 func NewTCPTuple(p *pkt.Packet) (*TCPTuple, error) {
 	t := &TCPTuple{
 		Src: &net.TCPAddr{},
@@ -34,6 +39,7 @@ func NewTCPTuple(p *pkt.Packet) (*TCPTuple, error) {
 	}
 	t.Src.IP = ipHdr.Src()
 	t.Dst.IP = ipHdr.Dst()
+	t.setTunnel(p)
 	tcpHdr, ok := p.Headers[pkt.TransportLayer].(*pkt.TcpHdr)
 	if !ok || tcpHdr == nil {
 		return t, ErrTransportLayerHeader
@@ -43,6 +49,20 @@ func NewTCPTuple(p *pkt.Packet) (*TCPTuple, error) {
 	return t, nil
 }
 
+// setTunnel sets the tunnel key of the TCPTuple from the innermost tunnel p
+// came out of, if any.
+func (t *TCPTuple) setTunnel(p *pkt.Packet) {
+	if h := p.Tunnel(); h != nil {
+		t.TunnelKey, t.HasTunnelKey = h.TunnelKey()
+	}
+}
+
+// sameTunnel returns true if t and x came out of tunnels with the same key, or
+// neither has a key.
+func (t *TCPTuple) sameTunnel(x *TCPTuple) bool {
+	return t.HasTunnelKey == x.HasTunnelKey && t.TunnelKey == x.TunnelKey
+}
+
 // srcByPort sets the address with the given port number as the source address
 // in the TCPTuple.  If neither address has the given port or if both do it is
 // considered an error and nothing is changed.
@@ -63,13 +83,16 @@ func (t *TCPTuple) srcByPort(p int) error {
 
 // String returns a serialized form of a TCPTuple suitable for use as a key.
 func (t *TCPTuple) String() string {
+	if t.HasTunnelKey {
+		return fmt.Sprintf("%s<->%s key %d", t.Src, t.Dst, t.TunnelKey)
+	}
 	return fmt.Sprintf("%s<->%s", t.Src, t.Dst)
 }
 
 // Equal returns true if t and x have the same source and destination IP:Port
-// address.
+// address and tunnel key.
 func (t *TCPTuple) Equal(x *TCPTuple) bool {
-	if x == nil {
+	if x == nil || !t.sameTunnel(x) {
 		return false
 	}
 	if t.Src.Port == x.Src.Port {
@@ -82,9 +105,10 @@ func (t *TCPTuple) Equal(x *TCPTuple) bool {
 	return false
 }
 
-// REqual returns true if both source addresses match both destination addresses.
+// REqual returns true if both source addresses match both destination addresses
+// and the tunnel keys match.
 func (t *TCPTuple) REqual(x *TCPTuple) bool {
-	if x == nil {
+	if x == nil || !t.sameTunnel(x) {
 		return false
 	}
 	if t.Src.Port == x.Dst.Port {
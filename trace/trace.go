@@ -6,11 +6,11 @@
 //
 // The trace package provides support for analyzing and storing the data
 // gathered by the pcap package.
-//
 package trace
 
 import (
 	"compress/gzip"
+	"encoding/binary"
 	"encoding/gob"
 	"errors"
 	"io"
@@ -31,25 +31,47 @@ var (
 	ErrBadPort                = errors.New("No ports match")
 	ErrEmptyData              = errors.New("Data is empty")
 	ErrFlowMismatch           = errors.New("Flows do not match")
+	ErrLinkTypeMismatch       = errors.New("Packets have different link types")
 	ErrNetworkLayerHeader     = errors.New("Network Layer Header Error")
+	ErrNoRawData              = errors.New("Packet kept no raw data")
 	ErrSameSrcDstPorts        = errors.New("Src and Dst ports match")
 	ErrTCPSeqMissing          = errors.New("TCP Sequnce Number Missing")
 	ErrTransportLayerHeader   = errors.New("Transport Layer Header Error")
 )
 
 var (
-	ApplicationLayer = 3       // Index for OSI Application Layer in Pkt.Headers
-	ServerSrcPort    = int(80) // Expected server source port
+	ApplicationLayer = pkt.ApplicationLayer // Index for OSI Application Layer in Pkt.Headers
+	ServerSrcPort    = int(80)              // Expected server source port
 )
 
 func init() {
+	gob.Register(&pkt.ArpHdr{})
+	gob.Register(&pkt.Dot1QHdr{})
 	gob.Register(&pkt.EthHdr{})
+	gob.Register(&pkt.GeneveHdr{})
+	gob.Register(&pkt.GreHdr{})
 	gob.Register(&pkt.HttpHdr{})
+	gob.Register(&pkt.Icmp6Echo{})
+	gob.Register(&pkt.Icmp6Error{})
+	gob.Register(&pkt.Icmp6Hdr{})
+	gob.Register(&pkt.Icmp6TooBig{})
+	gob.Register(&pkt.IcmpHdr{})
+	gob.Register(&pkt.Ip6FragHdr{})
 	gob.Register(&pkt.Ip6Hdr{})
+	gob.Register(&pkt.Ip6OptsHdr{})
+	gob.Register(&pkt.Ip6RoutingHdr{})
 	gob.Register(&pkt.IpHdr{})
+	gob.Register(&pkt.MplsHdr{})
+	gob.Register(&pkt.NdpNeighborAdvert{})
+	gob.Register(&pkt.NdpNeighborSolicit{})
+	gob.Register(&pkt.NdpRouterAdvert{})
+	gob.Register(&pkt.NdpRouterSolicit{})
 	gob.Register(&pkt.Packet{})
+	gob.Register(&pkt.Sll2Hdr{})
+	gob.Register(&pkt.SllHdr{})
 	gob.Register(&pkt.TcpHdr{})
 	gob.Register(&pkt.UdpHdr{})
+	gob.Register(&pkt.VxlanHdr{})
 }
 
 // A PktTrace combines the pkt.Packet data with meta data so that it can be
@@ -61,7 +83,7 @@ type PktTrace struct {
 	Notes      string         // Meta data not otherwise specified
 	MetaPcap   *MetaPcap      // Meta data from pcap.Pcap
 	Stats      *stat.Stat     // Capture stats straight from libpcap
-	Data       *[]*pkt.Packet // The headers of the captured packets
+	Data       *[]*pkt.Packet // The headers, and any raw data, of the captured packets
 }
 
 // A MetaPcap is a copy of the meta data from pcap.Pcap.  We keep this copy
@@ -100,3 +122,85 @@ func (t *PktTrace) Archive(w io.Writer) error {
 	ge := gob.NewEncoder(gz)
 	return ge.Encode(t)
 }
+
+// Redecode decodes the headers of the packets that kept their raw data again
+// (see pkt.Packet.Redecode), so that a trace archived by an older version of
+// the pkt package gets what the current decoders find.  It returns the number
+// of packets decoded again.
+func (t *PktTrace) Redecode() int {
+	if t.Data == nil {
+		return 0
+	}
+	n := 0
+	for _, p := range *t.Data {
+		if p.Redecode() != pkt.ErrNoRaw {
+			n++
+		}
+	}
+	return n
+}
+
+// These are the magic number and version of the pcap savefile format.
+const (
+	pcapMagic        = 0xa1b2c3d4 // microsecond time stamps
+	pcapVersionMajor = 2
+	pcapVersionMinor = 4
+	pcapLinkTypeRaw  = 101 // LINKTYPE_RAW, which DLT_RAW is saved as
+)
+
+// WritePcap writes the raw data of the packets to w as a pcap savefile, which
+// libpcap based tools such as tcpdump and Wireshark can read.  Every packet
+// has to have kept its raw data, and they all have to have the same link
+// type.
+func (t *PktTrace) WritePcap(w io.Writer) error {
+	var d []*pkt.Packet
+	if t.Data != nil {
+		d = *t.Data
+	}
+	snaplen, linkType := uint32(65535), pkt.LinkTypeEthernet
+	if t.MetaPcap != nil && t.MetaPcap.Snaplen > 0 {
+		snaplen = uint32(t.MetaPcap.Snaplen)
+	}
+	if len(d) > 0 {
+		linkType = d[0].LinkType
+	}
+	for _, p := range d {
+		if p.Raw == nil {
+			return ErrNoRawData
+		}
+		if p.LinkType != linkType {
+			return ErrLinkTypeMismatch
+		}
+	}
+
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:], pcapVersionMajor)
+	binary.LittleEndian.PutUint16(hdr[6:], pcapVersionMinor)
+	binary.LittleEndian.PutUint32(hdr[16:], snaplen)
+	if linkType == pkt.LinkTypeRaw {
+		linkType = pcapLinkTypeRaw
+	}
+	binary.LittleEndian.PutUint32(hdr[20:], uint32(linkType))
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+	rec := hdr[:16]
+	for _, p := range d {
+		l := p.Len
+		if l < uint32(len(p.Raw)) {
+			l = uint32(len(p.Raw))
+		}
+		binary.LittleEndian.PutUint32(rec[0:], uint32(p.Time.Unix()))
+		binary.LittleEndian.PutUint32(rec[4:], uint32(p.Time.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(rec[8:], uint32(len(p.Raw)))
+		binary.LittleEndian.PutUint32(rec[12:], l)
+		if _, err := w.Write(rec); err != nil {
+			return err
+		}
+		if _, err := w.Write(p.Raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/VividCortex/golibpcap/pcap/pkt"
+)
+
+// rawPacket returns a decoded TCP/IPv4 packet carrying "hello!" that kept
+// its raw data.
+func rawPacket(t *testing.T) *pkt.Packet {
+	frame, err := pkt.Serialize([]byte("hello!"),
+		&pkt.EthHdr{
+			DstAddr: net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+			SrcAddr: net.HardwareAddr{0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb},
+		},
+		&pkt.IpHdr{
+			SrcAddr:  net.IPv4(192, 168, 1, 2),
+			DstAddr:  net.IPv4(10, 0, 0, 1),
+			IpFields: pkt.IpFields{Ttl: 64},
+		},
+		&pkt.TcpHdr{Source: 50000, Dest: 80, Seq: 1, Flags: pkt.TCP_PSH | pkt.TCP_ACK})
+	if err != nil {
+		t.Fatalf("pkt.Serialize: %v", err)
+	}
+	p := pkt.DecodePacket(frame, pkt.LinkTypeEthernet)
+	p.Time = time.Unix(1000, 2000)
+	p.Keep(-1)
+	return p
+}
+
+// Make sure the raw data survives an archive and can be decoded again.
+func TestArchiveRaw(t *testing.T) {
+	d := []*pkt.Packet{rawPacket(t)}
+	var b bytes.Buffer
+	if err := (&PktTrace{Version: Version, Data: &d}).Archive(&b); err != nil {
+		t.Fatalf("Archive: %v", err)
+	}
+	tr, err := PktTraceFromArchive(&b)
+	if err != nil {
+		t.Fatalf("PktTraceFromArchive: %v", err)
+	}
+	p := (*tr.Data)[0]
+	if !bytes.Equal(p.Raw, d[0].Raw) {
+		t.Errorf("p.Raw = % x, want % x", p.Raw, d[0].Raw)
+	}
+	if n := tr.Redecode(); n != 1 {
+		t.Errorf("tr.Redecode() = %d, want 1", n)
+	}
+	if p.String() != d[0].String() {
+		t.Errorf("p = %s, want %s", p, d[0])
+	}
+	tcp, ok := p.Headers[pkt.TransportLayer].(*pkt.TcpHdr)
+	if !ok {
+		t.Fatalf("p.Headers[TransportLayer] = %v, want *pkt.TcpHdr", p.Headers[pkt.TransportLayer])
+	}
+	if pl := tcp.GetPayloadBytes(p.Headers[pkt.NetworkLayer].(pkt.InetProtoHdr).PL()); string(pl) != "hello!" {
+		t.Errorf("payload = %q, want %q", pl, "hello!")
+	}
+}
+
+func TestWritePcap(t *testing.T) {
+	p := rawPacket(t)
+	d := []*pkt.Packet{p}
+	var b bytes.Buffer
+	if err := (&PktTrace{Data: &d}).WritePcap(&b); err != nil {
+		t.Fatalf("WritePcap: %v", err)
+	}
+	f := b.Bytes()
+	if len(f) != 24+16+len(p.Raw) {
+		t.Fatalf("len = %d, want %d", len(f), 24+16+len(p.Raw))
+	}
+	for _, c := range []struct {
+		off  int
+		want uint32
+	}{
+		{0, pcapMagic},
+		{16, 65535},
+		{20, uint32(pkt.LinkTypeEthernet)},
+		{24, 1000},
+		{28, 2},
+		{32, uint32(len(p.Raw))},
+		{36, uint32(len(p.Raw))},
+	} {
+		if v := binary.LittleEndian.Uint32(f[c.off:]); v != c.want {
+			t.Errorf("offset %d = %d, want %d", c.off, v, c.want)
+		}
+	}
+	if !bytes.Equal(f[40:], p.Raw) {
+		t.Errorf("packet data = % x, want % x", f[40:], p.Raw)
+	}
+
+	d = append(d, &pkt.Packet{})
+	if err := (&PktTrace{Data: &d}).WritePcap(&b); err != ErrNoRawData {
+		t.Errorf("WritePcap = %v, want ErrNoRawData", err)
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/VividCortex/golibpcap/pcap/pkt"
+)
+
+// A UDPFlow makes working with UDP data easier.  UDP has no handshake, so the
+// source of a UDPFlow is whichever side sent the first packet.
+type UDPFlow struct {
+	UDPTuple     *UDPTuple     // The source and destination addresses
+	Data         []*pkt.Packet // The array of packets
+	StartTime    time.Time     // Time of the first packet
+	EndTime      time.Time     // Time of the last packet
+	SrcBytes     int64         // Number of application bytes Src->Dst
+	DstBytes     int64         // Number of application bytes Dst->Src
+	SrcPLBytes   int64         // Number of IP payload bytes Src->Dst
+	DstPLBytes   int64         // Number of IP payload bytes Dst->Src
+	SrcWireBytes int64         // Number of off the wire bytes Src->Dst
+	DstWireBytes int64         // Number of off the wire bytes Dst->Src
+	SrcPktCnt    int64         // Number of packets Src->Dst
+	DstPktCnt    int64         // Number of packets Dst->Src
+	SrcData      []*pkt.Packet // An array of packets Src->Dst
+	DstData      []*pkt.Packet // An array of packets Dst->Src
+}
+
+// NewUDPFlow filters the packet in d by t.MatchFlow and returns the resulting
+// UDPFlow for analysis.
+func NewUDPFlow(d []*pkt.Packet, t *UDPTuple) *UDPFlow {
+	f := &UDPFlow{UDPTuple: t}
+	f.AddData(d)
+	return f
+}
+
+// GetUDPTraffic separates all the UDP traffic in the slice of pkt.Packet into
+// their respective flows.  Both directions of a conversation go to the same
+// key, the string of the UDPTuple of its first packet.  A packet that comes
+// more than idle after the last one of its flow starts a new flow under the
+// same key, so the slice holds the flows of a key in the order they started.
+// Each flow takes the UDPTuple of its own first packet, which may be the
+// reverse of the key.  An idle of 0 or less never splits a flow.  This assumes
+// that the packets in d are in the order they were captured.
+func GetUDPTraffic(d []*pkt.Packet, idle time.Duration) map[string][]*UDPFlow {
+	m := make(map[string][]*UDPFlow)
+	for i := range d {
+		t, err := NewUDPTuple(d[i])
+		if err != nil {
+			continue
+		}
+		k := t.String()
+		if m[k] == nil {
+			if r := t.Reverse().String(); m[r] != nil {
+				k = r
+			}
+		}
+		fs := m[k]
+		if n := len(fs); n == 0 || fs[n-1].Idle(d[i].Time, idle) {
+			fs = append(fs, &UDPFlow{UDPTuple: t})
+			m[k] = fs
+		}
+		_ = fs[len(fs)-1].AddPacket(d[i])
+	}
+	return m
+}
+
+// Idle returns true if the UDPFlow has seen no packet for more than idle at
+// time t.  An idle of 0 or less never times out.
+func (f *UDPFlow) Idle(t time.Time, idle time.Duration) bool {
+	return idle > 0 && !f.EndTime.IsZero() && t.Sub(f.EndTime) > idle
+}
+
+// AddData will call f.AddPacket for each packet in d where f.UDPTuple.MatchFlow
+// is true.  This is a faster way to create a UDPFlow if you know you are only
+// interested in the UDP traffic for a single UDPTuple.
+func (f *UDPFlow) AddData(d []*pkt.Packet) {
+	for i := range d {
+		t, err := NewUDPTuple(d[i])
+		if err != nil {
+			continue
+		}
+		if t.MatchFlow(f.UDPTuple) {
+			_ = f.AddPacket(d[i])
+		}
+	}
+}
+
+// AddPacket updates the meta data for a UDPFlow and saves a reference to the
+// pkt.Packet.  This assumes the packet addresses have been checked and the UDP
+// flow matches (it does not check for matching addresses).
+func (f *UDPFlow) AddPacket(p *pkt.Packet) error {
+	ip, ok := p.Headers[pkt.NetworkLayer].(pkt.InetProtoHdr)
+	if !ok || ip == nil {
+		return ErrNetworkLayerHeader
+	}
+	udpHdr, ok := p.Headers[pkt.TransportLayer].(*pkt.UdpHdr)
+	if !ok || udpHdr == nil {
+		return ErrTransportLayerHeader
+	}
+	pt, err := NewUDPTuple(p)
+	if err != nil {
+		return err
+	}
+	if p.Time.Before(f.StartTime) || f.StartTime.IsZero() {
+		f.StartTime = p.Time
+	}
+	if p.Time.After(f.EndTime) || f.EndTime.IsZero() {
+		f.EndTime = p.Time
+	}
+	if f.UDPTuple.Equal(pt) {
+		f.SrcBytes += int64(udpHdr.PayloadLen(ip.PL()))
+		f.SrcPLBytes += int64(ip.PL())
+		f.SrcWireBytes += int64(p.Len)
+		f.SrcPktCnt++
+		f.SrcData = append(f.SrcData, p)
+	} else {
+		f.DstBytes += int64(udpHdr.PayloadLen(ip.PL()))
+		f.DstPLBytes += int64(ip.PL())
+		f.DstWireBytes += int64(p.Len)
+		f.DstPktCnt++
+		f.DstData = append(f.DstData, p)
+	}
+	f.Data = append(f.Data, p)
+	return nil
+}
+
+// Analyze computes the timing statistics for a UDPFlow.
+func (f *UDPFlow) Analyze() *UDPFlowStats {
+	return &UDPFlowStats{
+		Duration:        f.EndTime.Sub(f.StartTime),
+		InterArrival:    NewInterArrival(f.Data),
+		SrcInterArrival: NewInterArrival(f.SrcData),
+		DstInterArrival: NewInterArrival(f.DstData),
+	}
+}
+
+// Timing statistics for a UDPFlow.  This is usually returned from a call to
+// UDPFlow.Analyze().
+type UDPFlowStats struct {
+	Duration        time.Duration // Time from the first to the last packet
+	InterArrival    InterArrival  // Gaps between packets either way
+	SrcInterArrival InterArrival  // Gaps between Src->Dst packets
+	DstInterArrival InterArrival  // Gaps between Dst->Src packets
+}
+
+// InterArrival holds statistics on the gaps between packets that arrive one
+// after the other.
+type InterArrival struct {
+	Count  int           // Number of gaps, one less than the number of packets
+	Min    time.Duration // Shortest gap
+	Max    time.Duration // Longest gap
+	Mean   time.Duration // Average gap
+	StdDev time.Duration // Standard deviation of the gaps
+}
+
+// NewInterArrival computes the InterArrival statistics for the packets in d,
+// taken in the order of their time stamps.  With fewer than two packets all
+// the statistics are 0.
+func NewInterArrival(d []*pkt.Packet) InterArrival {
+	var s InterArrival
+	if len(d) < 2 {
+		return s
+	}
+	t := make([]time.Time, len(d))
+	for i := range d {
+		t[i] = d[i].Time
+	}
+	sort.Slice(t, func(i, j int) bool { return t[i].Before(t[j]) })
+
+	var sum, sumSq float64
+	s.Min = time.Duration(math.MaxInt64)
+	for i := 1; i < len(t); i++ {
+		gap := t[i].Sub(t[i-1])
+		if gap < s.Min {
+			s.Min = gap
+		}
+		if gap > s.Max {
+			s.Max = gap
+		}
+		sum += float64(gap)
+		sumSq += float64(gap) * float64(gap)
+	}
+	s.Count = len(t) - 1
+	mean := sum / float64(s.Count)
+	s.Mean = time.Duration(mean)
+	s.StdDev = time.Duration(math.Sqrt(math.Max(sumSq/float64(s.Count)-mean*mean, 0)))
+	return s
+}
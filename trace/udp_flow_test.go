@@ -0,0 +1,112 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/VividCortex/golibpcap/pcap/pkt"
+)
+
+var (
+	dnsClient = &net.UDPAddr{IP: net.IPv4(192, 168, 1, 2).To4(), Port: 5353}
+	dnsServer = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 53}
+)
+
+// udpPacket returns a decoded UDP/IPv4 packet from src to dst carrying
+// payload, captured ms milliseconds into the trace.
+func udpPacket(t *testing.T, src, dst *net.UDPAddr, payload string, ms int) *pkt.Packet {
+	frame, err := pkt.Serialize([]byte(payload),
+		&pkt.EthHdr{
+			DstAddr: net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+			SrcAddr: net.HardwareAddr{0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb},
+		},
+		&pkt.IpHdr{SrcAddr: src.IP, DstAddr: dst.IP, IpFields: pkt.IpFields{Ttl: 64}},
+		&pkt.UdpHdr{Source: uint16(src.Port), Dest: uint16(dst.Port)})
+	if err != nil {
+		t.Fatalf("pkt.Serialize: %v", err)
+	}
+	p := pkt.DecodePacket(frame, pkt.LinkTypeEthernet)
+	p.Time = time.Unix(1000, 0).Add(time.Duration(ms) * time.Millisecond)
+	return p
+}
+
+// Make sure both directions of a conversation go to one flow, that the
+// direction of its first packet is the source, and that an idle gap starts a
+// new flow under the same key.
+func TestGetUDPTraffic(t *testing.T) {
+	d := []*pkt.Packet{
+		udpPacket(t, dnsClient, dnsServer, "query", 0),
+		udpPacket(t, dnsServer, dnsClient, "answer", 10),
+		udpPacket(t, dnsClient, dnsServer, "query2", 40),
+		udpPacket(t, dnsServer, dnsClient, "answer2", 50),
+		udpPacket(t, dnsServer, dnsClient, "late", 5050),
+		rawPacket(t),
+	}
+	m := GetUDPTraffic(d, 0)
+	if len(m) != 1 {
+		t.Fatalf("len(GetUDPTraffic(d, 0)) = %d, want 1", len(m))
+	}
+	fs := m["192.168.1.2:5353<->10.0.0.1:53"]
+	if len(fs) != 1 {
+		t.Fatalf("GetUDPTraffic(d, 0) = %v, want one flow from the client", m)
+	}
+	f := fs[0]
+	if f.SrcPktCnt != 2 || f.DstPktCnt != 3 || len(f.Data) != 5 {
+		t.Errorf("packets %d/%d, want 2/3", f.SrcPktCnt, f.DstPktCnt)
+	}
+	if f.SrcBytes != 11 || f.DstBytes != 17 {
+		t.Errorf("bytes %d/%d, want 11/17", f.SrcBytes, f.DstBytes)
+	}
+	if f.SrcPLBytes != 11+2*8 || f.SrcWireBytes != 11+2*(8+20+14) {
+		t.Errorf("payload bytes %d, wire bytes %d", f.SrcPLBytes, f.SrcWireBytes)
+	}
+	if !f.StartTime.Equal(d[0].Time) || !f.EndTime.Equal(d[4].Time) {
+		t.Errorf("times %v - %v", f.StartTime, f.EndTime)
+	}
+
+	fs = GetUDPTraffic(d, time.Second)["192.168.1.2:5353<->10.0.0.1:53"]
+	if len(fs) != 2 {
+		t.Fatalf("GetUDPTraffic(d, time.Second) gave %d flows, want 2", len(fs))
+	}
+	if fs[0].SrcPktCnt != 2 || fs[0].DstPktCnt != 2 || !fs[0].EndTime.Equal(d[3].Time) {
+		t.Errorf("first flow %d/%d packets until %v", fs[0].SrcPktCnt, fs[0].DstPktCnt, fs[0].EndTime)
+	}
+	// The server starts the second flow, so it is the source.
+	if !fs[1].UDPTuple.Equal(fs[0].UDPTuple.Reverse()) || fs[1].SrcPktCnt != 1 || fs[1].DstPktCnt != 0 {
+		t.Errorf("second flow %s has %d/%d packets, want 1/0", fs[1].UDPTuple, fs[1].SrcPktCnt, fs[1].DstPktCnt)
+	}
+}
+
+// Make sure the inter-arrival statistics are taken over the gaps in time
+// order, each way and both ways.
+func TestUDPFlowAnalyze(t *testing.T) {
+	d := []*pkt.Packet{
+		udpPacket(t, dnsClient, dnsServer, "a", 0),
+		udpPacket(t, dnsServer, dnsClient, "b", 10),
+		udpPacket(t, dnsClient, dnsServer, "c", 40),
+		udpPacket(t, dnsServer, dnsClient, "d", 30),
+	}
+	fs := NewUDPFlow(d, &UDPTuple{Src: dnsClient, Dst: dnsServer}).Analyze()
+	if fs.Duration != 40*time.Millisecond {
+		t.Errorf("fs.Duration = %v, want 40ms", fs.Duration)
+	}
+	want := InterArrival{Count: 3, Min: 10 * time.Millisecond, Max: 20 * time.Millisecond, Mean: 40 * time.Millisecond / 3, StdDev: 4714045}
+	if fs.InterArrival != want {
+		t.Errorf("fs.InterArrival = %+v, want %+v", fs.InterArrival, want)
+	}
+	want = InterArrival{Count: 1, Min: 40 * time.Millisecond, Max: 40 * time.Millisecond, Mean: 40 * time.Millisecond}
+	if fs.SrcInterArrival != want {
+		t.Errorf("fs.SrcInterArrival = %+v, want %+v", fs.SrcInterArrival, want)
+	}
+	if fs.DstInterArrival.Mean != 20*time.Millisecond {
+		t.Errorf("fs.DstInterArrival = %+v, want a 20ms mean", fs.DstInterArrival)
+	}
+	if s := NewInterArrival(d[:1]); s != (InterArrival{}) {
+		t.Errorf("NewInterArrival of one packet = %+v, want all 0", s)
+	}
+}
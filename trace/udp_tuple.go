@@ -0,0 +1,113 @@
+// Copyright 2013 The golibpcap Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/VividCortex/golibpcap/pcap/pkt"
+)
+
+// A UDPTuple encapsulates the source and destination address for analysing
+// UDP/IP packet traces.  As with a TCPTuple, packets that came out of a tunnel
+// are told apart by the VNI or key of the tunnel too.
+type UDPTuple struct {
+	Src          *net.UDPAddr // The source IP address
+	Dst          *net.UDPAddr // The destination IP address
+	TunnelKey    uint32       // The VNI or GRE key of the innermost tunnel
+	HasTunnelKey bool         // Whether the packets came with a TunnelKey
+}
+
+// NewUDPTuple constructs a UDPTuple from the information in the pkt.Packet
+// headers.  This assumes that the packet is a UDP/IP packet.  For a packet
+// that came out of a tunnel the addresses are those of the packet inside.
+func NewUDPTuple(p *pkt.Packet) (*UDPTuple, error) {
+	t := &UDPTuple{
+		Src: &net.UDPAddr{},
+		Dst: &net.UDPAddr{},
+	}
+	if p == nil {
+		return t, nil
+	}
+	ipHdr, ok := p.Headers[pkt.NetworkLayer].(pkt.InetProtoHdr)
+	if !ok || ipHdr == nil {
+		return t, ErrNetworkLayerHeader
+	}
+	t.Src.IP = ipHdr.Src()
+	t.Dst.IP = ipHdr.Dst()
+	if h := p.Tunnel(); h != nil {
+		t.TunnelKey, t.HasTunnelKey = h.TunnelKey()
+	}
+	udpHdr, ok := p.Headers[pkt.TransportLayer].(*pkt.UdpHdr)
+	if !ok || udpHdr == nil {
+		return t, ErrTransportLayerHeader
+	}
+	t.Src.Port = int(udpHdr.Source)
+	t.Dst.Port = int(udpHdr.Dest)
+	return t, nil
+}
+
+// sameTunnel returns true if t and x came out of tunnels with the same key, or
+// neither has a key.
+func (t *UDPTuple) sameTunnel(x *UDPTuple) bool {
+	return t.HasTunnelKey == x.HasTunnelKey && t.TunnelKey == x.TunnelKey
+}
+
+// Reverse returns a UDPTuple with the source and destination addresses of t
+// swapped.
+func (t *UDPTuple) Reverse() *UDPTuple {
+	return &UDPTuple{
+		Src:          t.Dst,
+		Dst:          t.Src,
+		TunnelKey:    t.TunnelKey,
+		HasTunnelKey: t.HasTunnelKey,
+	}
+}
+
+// String returns a serialized form of a UDPTuple suitable for use as a key.
+func (t *UDPTuple) String() string {
+	if t.HasTunnelKey {
+		return fmt.Sprintf("%s<->%s key %d", t.Src, t.Dst, t.TunnelKey)
+	}
+	return fmt.Sprintf("%s<->%s", t.Src, t.Dst)
+}
+
+// Equal returns true if t and x have the same source and destination IP:Port
+// address and tunnel key.
+func (t *UDPTuple) Equal(x *UDPTuple) bool {
+	if x == nil || !t.sameTunnel(x) {
+		return false
+	}
+	if t.Src.Port == x.Src.Port {
+		if t.Dst.Port == x.Dst.Port {
+			if t.Src.IP.Equal(x.Src.IP) {
+				return t.Dst.IP.Equal(x.Dst.IP)
+			}
+		}
+	}
+	return false
+}
+
+// REqual returns true if both source addresses match both destination addresses
+// and the tunnel keys match.
+func (t *UDPTuple) REqual(x *UDPTuple) bool {
+	if x == nil || !t.sameTunnel(x) {
+		return false
+	}
+	if t.Src.Port == x.Dst.Port {
+		if t.Dst.Port == x.Src.Port {
+			if t.Src.IP.Equal(x.Dst.IP) {
+				return t.Dst.IP.Equal(x.Src.IP)
+			}
+		}
+	}
+	return false
+}
+
+// MatchFlow returns t.Equal || t.REqual
+func (t *UDPTuple) MatchFlow(x *UDPTuple) bool {
+	return t.Equal(x) || t.REqual(x)
+}